@@ -0,0 +1,108 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// AffectsRule declares a dependency the imports don't show (packages coupled at
+// runtime by way of registries, SQL files, reflection, etc...): a change to any file
+// under the source (a folder relative to the working directory, or an import path)
+// also tests the targets (the same, or import path patterns where "..." matches
+// anything). The [affects] table of .scantest.toml:
+//
+//	[affects]
+//	"./schema" = ["./store", "./migrations"]
+type AffectsRule struct {
+	Source  string
+	Targets []string
+}
+
+// resolveFolder resolves the source (or a target) to an absolute folder.
+func resolveFolder(root, path string) string {
+	if strings.HasPrefix(path, ".") || filepath.IsAbs(path) {
+		return filepath.Join(root, path)
+	}
+	if pkg, err := build.Default.Import(path, root, build.FindOnly); err == nil {
+		return pkg.Dir
+	}
+	return filepath.Join(root, path)
+}
+
+// covers reports whether the file (or folder) is under the source of the rule.
+func (self AffectsRule) covers(root, path string) bool {
+	relative, err := filepath.Rel(resolveFolder(root, self.Source), path)
+	return err == nil && isWithin(relative)
+}
+
+// mergeAffects adds the targets of each rule to the cascade of the packages under its
+// source, as if they imported them.
+func mergeAffects(rules []AffectsRule, root string, all []*Package, cascade map[string][]string) {
+	for _, rule := range rules {
+		targets := []string{}
+		for _, target := range rule.Targets {
+			folder := resolveFolder(root, target)
+			for _, pkg := range all {
+				if len(pkg.Info.GoFiles)+len(pkg.Info.TestGoFiles)+len(pkg.Info.XTestGoFiles) == 0 {
+					continue // (a folder of other files can't be tested)
+				}
+				if pkg.Info.Dir == folder || strings.Contains(target, "...") && matchesPattern(target, pkg.Info.ImportPath) {
+					targets = append(targets, pkg.Info.ImportPath)
+				}
+			}
+		}
+		for _, pkg := range all {
+			if !rule.covers(root, pkg.Info.Dir) {
+				continue
+			}
+			known := map[string]bool{pkg.Info.ImportPath: true}
+			for _, upstream := range cascade[pkg.Info.ImportPath] {
+				known[upstream] = true
+			}
+			for _, target := range targets {
+				if !known[target] {
+					known[target] = true
+					cascade[pkg.Info.ImportPath] = append(cascade[pkg.Info.ImportPath], target)
+				}
+			}
+		}
+	}
+}
+
+// isHint reports whether a file (of any kind) is under the source of a rule, so that
+// the scan keeps track of it even though it isn't a go file (SQL files, templates, etc...).
+func isHint(rules []AffectsRule, root, path string) bool {
+	for _, rule := range rules {
+		if rule.covers(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// modifiedHints reports whether any of the modified files of a package aren't go files
+// (which means they were tracked for an [affects] rule).
+func modifiedHints(pkg *Package) bool {
+	for _, path := range pkg.ModifiedFiles {
+		if !strings.HasSuffix(path, ".go") {
+			return true
+		}
+	}
+	return false
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) affects(path string, value interface{}) (rules []AffectsRule) {
+	for source, targets := range self.table(path, value) {
+		rules = append(rules, AffectsRule{Source: source, Targets: self.strings(path+"."+source, targets)})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Source < rules[j].Source })
+	return rules
+}
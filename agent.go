@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	agentTokenVariable = "SCANTEST_AGENT_TOKEN"
+	agentExitTrailer   = "Scantest-Exit-Code"
+)
+
+// runAgent implements `scantest agent serve`, which runs the jobs of other scantest
+// processes (see RemoteSettings) on this machine: the AgentJob is POSTed as JSON
+// to /run, and its combined output streamed back, followed by its exit code (in a
+// trailer). `scantest agent run <address> <job>` is the other end: it prints the
+// output and exits with the code, as the job would.
+//
+// A job runs whatever command it names, as the agent's user, so the token of
+// $SCANTEST_AGENT_TOKEN (the same on both sides) is all that keeps others out: -root
+// only restricts the folders jobs run in, and isn't a sandbox. The agent listens on
+// the loopback interface unless told otherwise (reach it over an SSH tunnel, ie. `ssh
+// -L 7070:localhost:7070 builder`), and the token only goes over the network in the
+// clear when it's told to listen elsewhere without -tls-cert and -tls-key.
+func runAgent(arguments []string) {
+	if len(arguments) == 3 && arguments[0] == "run" {
+		os.Exit(runAgentJob(arguments[1], arguments[2]))
+	}
+	if len(arguments) == 0 || arguments[0] != "serve" {
+		fmt.Fprintln(os.Stderr, "Usage: scantest agent serve [-listen <address>] [-root <folder>]")
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("agent serve", flag.ExitOnError)
+	listen := flags.String("listen", "127.0.0.1:7070", "The address to listen on (only this machine, by default: reach it over an SSH tunnel, or see -tls-cert).")
+	root := flags.String("root", ".", "The folder the jobs must run under (which isn't a sandbox: jobs run whatever command they name).")
+	certificate := flags.String("tls-cert", "", "The certificate file to serve HTTPS with (along with -tls-key), so that the token doesn't go over the network in the clear.")
+	key := flags.String("tls-key", "", "The private key file of -tls-cert.")
+	flags.Parse(arguments[1:])
+	if (*certificate == "") != (*key == "") {
+		fmt.Fprintln(os.Stderr, "-tls-cert and -tls-key go together.")
+		os.Exit(2)
+	}
+
+	token := os.Getenv(agentTokenVariable)
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "$%s must be set (to a secret shared with the machines sending jobs).\n", agentTokenVariable)
+		os.Exit(2)
+	}
+	folder, err := filepath.Abs(*root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", func(response http.ResponseWriter, request *http.Request) {
+		serveAgentJob(response, request, token, folder)
+	})
+	if host, _, err := net.SplitHostPort(*listen); *certificate == "" && (err != nil || !isLoopback(host)) {
+		fmt.Fprintf(os.Stderr, "Warning: listening on %s over plain HTTP, where the token (and the output of the jobs) can be read by anyone on the network (see -tls-cert).\n", *listen)
+	}
+	fmt.Fprintf(os.Stderr, "Running jobs under %s (listening on %s)...\n", folder, *listen)
+	if *certificate != "" {
+		err = http.ListenAndServeTLS(*listen, *certificate, *key, mux)
+	} else {
+		err = http.ListenAndServe(*listen, mux)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// isLoopback reports whether the host (of an address to listen on) is this machine only.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func serveAgentJob(response http.ResponseWriter, request *http.Request, token, root string) {
+	if request.Method != http.MethodPost {
+		http.Error(response, "POST a job", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(request.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		http.Error(response, "wrong token", http.StatusUnauthorized)
+		return
+	}
+	var job AgentJob
+	if err := json.NewDecoder(request.Body).Decode(&job); err != nil || len(job.Args) == 0 {
+		http.Error(response, "malformed job", http.StatusBadRequest)
+		return
+	}
+	if relative, err := filepath.Rel(root, filepath.Clean(job.Dir)); err != nil || !isWithin(relative) {
+		http.Error(response, job.Dir+" isn't under "+root, http.StatusForbidden)
+		return
+	}
+	flusher, _ := response.(http.Flusher)
+	writer := &flushingWriter{writer: response, flusher: flusher}
+	command := newCommand(request.Context(), job.Args[0], job.Args[1:]...) // (killed when the client goes away)
+	command.Dir = job.Dir
+	command.Env = mergeEnvironment(withoutVariable(os.Environ(), agentTokenVariable), job.Env) // (or the tests could send jobs of their own)
+	command.Stdout, command.Stderr = writer, writer
+	response.Header().Set("Trailer", agentExitTrailer)
+	response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	err := command.Run()
+	code := exitCode(err)
+	if err != nil && code == 0 {
+		fmt.Fprintln(writer, err)
+		code = 2 // (it didn't start: as if it didn't build)
+	}
+	response.Header().Set(agentExitTrailer, strconv.Itoa(code))
+}
+
+// runAgentJob sends the job (as JSON) to the agent at the address, printing its output
+// and returning its exit code.
+func runAgentJob(address, job string) int {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(address, "/")+"/run", bytes.NewReader([]byte(job)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	request.Header.Set("Authorization", "Bearer "+os.Getenv(agentTokenVariable))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(response.Body)
+		fmt.Fprintf(os.Stderr, "The agent at %s refused the job: %s\n", address, strings.TrimSpace(string(raw)))
+		return 2
+	}
+	if _, err = io.Copy(os.Stdout, response.Body); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	code, err := strconv.Atoi(response.Trailer.Get(agentExitTrailer))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The agent at %s went away before the job was done.\n", address)
+		return 2
+	}
+	return code
+}
+
+// flushingWriter sends the output of a job as it comes (the job's stdout and stderr
+// are written to it from one goroutine at a time, see exec.Cmd).
+type flushingWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (self *flushingWriter) Write(p []byte) (int, error) {
+	n, err := self.writer.Write(p)
+	if self.flusher != nil {
+		self.flusher.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import "os/exec"
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// BackgroundSettings make scantest a background citizen (the [background] table of
+// .scantest.toml, or -background): the go commands it runs (and so the tests) get
+// a lower priority (nice and ionice on unix, below normal on windows) and, with
+// gomaxprocs, fewer CPUs, so that the churn of watching never makes the editor (or
+// a video call) stutter:
+//
+//	[background]
+//	enabled = true
+//	nice = 10
+//	gomaxprocs = 2
+type BackgroundSettings struct {
+	Enabled    bool
+	Nice       int // 1 (a little nicer) to 19 (the nicest), on unix
+	GOMAXPROCS int // the most CPUs the tests of each package may use (0: no limit)
+}
+
+var defaultBackground = BackgroundSettings{Nice: 10}
+
+// apply lowers the priority of the command (before it's started), when enabled.
+func (self BackgroundSettings) apply(command *exec.Cmd) *exec.Cmd {
+	if self.Enabled && command.Err == nil {
+		lowerPriority(command, self.Nice)
+	}
+	return command
+}
+
+// limit caps the GOMAXPROCS tune picked for a package, when enabled.
+func (self BackgroundSettings) limit(gomaxprocs int) int {
+	if self.Enabled && self.GOMAXPROCS > 0 && (gomaxprocs == 0 || gomaxprocs > self.GOMAXPROCS) {
+		return self.GOMAXPROCS
+	}
+	return gomaxprocs
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) background(path string, value interface{}, background *BackgroundSettings) {
+	for key, value := range self.table(path, value) {
+		switch key {
+		case "enabled":
+			background.Enabled = self.boolean(path+"."+key, value)
+		case "nice":
+			if number, ok := value.(int64); ok && number >= 1 && number <= 19 {
+				background.Nice = int(number)
+			} else {
+				self.fail(path+"."+key, "must be an integer from 1 to 19.")
+			}
+		case "gomaxprocs":
+			background.GOMAXPROCS = self.positive(path+"."+key, value)
+		default:
+			self.unknown(path+"."+key, key, "enabled", "nice", "gomaxprocs")
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const baselineFilename = "baseline.json"
+
+const (
+	baselinePreExisting = "pre-existing" // failed the same way on the baseline
+	baselineIntroduced  = "introduced"   // passed on the baseline (or failed differently)
+)
+
+// BaselineResults are the failures of the whole suite at the baseline commit (kept in
+// .scantest/baseline.json, so they're only collected once per commit).
+type BaselineResults struct {
+	Ref    string              `json:"ref"`
+	Commit string              `json:"commit"`
+	Failed map[string][]string `json:"failed"` // key: package, value: the failed tests (none if it didn't build)
+}
+
+// Baseline runs the suite once at the point where the current branch forked from a
+// ref (ie. origin/main), in a temporary git worktree, so that failures can be told
+// apart: those that already happen on the baseline and those introduced by local
+// changes. Until the baseline is known (or without one), failures aren't marked.
+// A nil Baseline marks nothing.
+type Baseline struct {
+	root    string
+	ref     string
+	config  *ConfigWatcher
+	mutex   sync.Mutex
+	results *BaselineResults
+}
+
+func NewBaseline(root, ref string, config *ConfigWatcher) *Baseline {
+	return &Baseline{root: root, ref: ref, config: config}
+}
+
+// Collect runs the baseline suite (unless its results are already on file).
+func (self *Baseline) Collect() {
+	commit, err := self.commit()
+	if err != nil {
+		logf("%s", err)
+		return
+	}
+	path := filepath.Join(self.root, stateFolder, baselineFilename)
+	results := &BaselineResults{}
+	if raw, err := os.ReadFile(path); err == nil && json.Unmarshal(raw, results) == nil && results.Commit == commit {
+		self.ready(results)
+		return
+	}
+
+	logf("Baseline: testing %s (%s)...", self.ref, shortCommit(commit))
+	results, err = self.run(commit)
+	if err != nil {
+		logf("Baseline: %s", err)
+		return
+	}
+	if raw, err := json.MarshalIndent(results, "", "  "); err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = os.WriteFile(path, raw, 0644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	self.ready(results)
+}
+
+func (self *Baseline) ready(results *BaselineResults) {
+	self.mutex.Lock()
+	self.results = results
+	self.mutex.Unlock()
+	logf("Baseline: %s (%s) has %d failing package(s).", self.ref, shortCommit(results.Commit), len(results.Failed))
+}
+
+func (self *Baseline) commit() (string, error) {
+	if _, err := exec.Command("git", "rev-parse", "--verify", "--quiet", self.ref+"^{commit}").Output(); err != nil {
+		return "", fmt.Errorf("-baseline: '%s' isn't a known git ref (or this isn't a git repository).", self.ref)
+	}
+	base, err := exec.Command("git", "merge-base", self.ref, "HEAD").Output()
+	if err != nil {
+		base, err = exec.Command("git", "rev-parse", self.ref+"^{commit}").Output()
+	}
+	if err != nil {
+		return "", fmt.Errorf("-baseline: %s", err)
+	}
+	return strings.TrimSpace(string(base)), nil
+}
+
+// run checks out the commit in a temporary worktree, linked into a temporary GOPATH
+// entry (ahead of the real GOPATH) at the import path of the working directory, so
+// that the baseline's packages are the ones imported by its tests.
+func (self *Baseline) run(commit string) (*BaselineResults, error) {
+	importPath := importPathOf(self.root)
+	if importPath == "" {
+		return nil, fmt.Errorf("%s isn't under GOPATH.", self.root)
+	}
+	prefix, err := exec.Command("git", "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return nil, err
+	}
+	temporary, err := os.MkdirTemp("", "scantest-baseline-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(temporary)
+
+	worktree := filepath.Join(temporary, "worktree")
+	if output, err := exec.Command("git", "worktree", "add", "--detach", worktree, commit).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("git worktree add: %s\n%s", err, output)
+	}
+	defer exec.Command("git", "worktree", "remove", "--force", worktree).Run()
+
+	gopath := filepath.Join(temporary, "gopath")
+	folder := filepath.Join(gopath, "src", filepath.FromSlash(importPath))
+	if err = os.MkdirAll(filepath.Dir(folder), 0755); err != nil {
+		return nil, err
+	}
+	if err = os.Symlink(filepath.Join(worktree, filepath.FromSlash(strings.TrimSpace(string(prefix)))), folder); err != nil {
+		return nil, err
+	}
+
+	settings := self.config.Settings()
+	command := settings.Background.apply(exec.Command("go", append(append([]string{"test", "-json"}, settings.TestArgs...), "./...")...))
+	command.Dir = folder
+	command.Env = mergeEnvironment(settings.Environ(), map[string]string{
+		"GOPATH": gopath + string(filepath.ListSeparator) + goPath(),
+		"PWD":    folder,
+	})
+	output, _ := command.Output() // (failing tests are the point)
+	return &BaselineResults{Ref: self.ref, Commit: commit, Failed: parseTestEvents(output)}, nil
+}
+
+func goPath() string {
+	if gopath := os.Getenv("GOPATH"); gopath != "" {
+		return gopath
+	}
+	output, _ := exec.Command("go", "env", "GOPATH").Output()
+	return strings.TrimSpace(string(output))
+}
+
+// parseTestEvents gathers the failed packages (and tests) from `go test -json` output.
+func parseTestEvents(output []byte) map[string][]string {
+	failed := map[string][]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var event struct{ Action, Package, Test string }
+		if json.Unmarshal(scanner.Bytes(), &event) != nil || event.Action != "fail" || event.Package == "" {
+			continue
+		}
+		if event.Test != "" {
+			failed[event.Package] = append(failed[event.Package], event.Test)
+		} else if _, found := failed[event.Package]; !found {
+			failed[event.Package] = nil
+		}
+	}
+	for _, tests := range failed {
+		sort.Strings(tests)
+	}
+	return failed
+}
+
+// Compare classifies a failed result: pre-existing if the package failed on the
+// baseline with (at least) the same failed tests, introduced otherwise.
+func (self *Baseline) Compare(result Result) string {
+	if self == nil || result.Status == TestsPassed {
+		return ""
+	}
+	self.mutex.Lock()
+	results := self.results
+	self.mutex.Unlock()
+	if results == nil {
+		return ""
+	}
+	tests, failed := results.Failed[result.PackageName]
+	if !failed {
+		return baselineIntroduced
+	}
+	if result.Status != TestsFailed {
+		if len(tests) == 0 {
+			return baselinePreExisting // didn't build there either.
+		}
+		return baselineIntroduced
+	}
+	known := map[string]bool{}
+	for _, test := range tests {
+		known[test] = true
+	}
+	for _, test := range parser.Tests(result.Output) {
+		if test.Status == "FAIL" && !known[test.Name] {
+			return baselineIntroduced
+		}
+	}
+	return baselinePreExisting
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// describeBaseline is appended to the name of a failed package in the console.
+func describeBaseline(result Result) string {
+	switch result.Baseline {
+	case baselinePreExisting:
+		return " [pre-existing on the baseline]"
+	case baselineIntroduced:
+		return " [introduced by local changes]"
+	}
+	return ""
+}
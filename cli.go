@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// subcommands are those of `scantest <command>`. watch, once and run take the flags
+// of scantest itself (see main); the others have flags of their own.
+var subcommands = []struct{ name, usage string }{
+	{"watch", "Test the packages as their files change (the default)."},
+	{"once", "Test everything (or see -since) once, print the results and exit."},
+	{"run", "Test the packages given (import paths or folders, where ... matches anything) once, and exit."},
+	{"history", "List the latest runs recorded in .scantest/history.jsonl (or those of a package)."},
+	{"doctor", "Check the environment before relying on it."},
+	{"graph", "Print the package dependency graph (DOT or JSON)."},
+	{"stress", "Run the tests of a package over and over, to catch flaky ones."},
+	{"config", "Validate the config files (config validate)."},
+	{"pause", "Pause the scantest running in this folder."},
+	{"resume", "Resume the scantest running in this folder."},
+	{"agent", "Run the jobs of other scantest processes on this machine (see [remote])."},
+	{"completion", "Print the completion script of a shell: bash, zsh or fish."},
+}
+
+// parseCommand takes the subcommand (watch, once or run) off the arguments, if any.
+func parseCommand(arguments []string) (command string, rest []string, ok bool) {
+	if len(arguments) == 0 || strings.HasPrefix(arguments[0], "-") {
+		return "watch", arguments, true
+	}
+	switch arguments[0] {
+	case "watch", "once", "run":
+		return arguments[0], arguments[1:], true
+	}
+	return arguments[0], arguments[1:], false
+}
+
+func printUsage() {
+	writer := flag.CommandLine.Output()
+	fmt.Fprintln(writer, "Usage: scantest [command] [flags]")
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "Commands:")
+	for _, command := range subcommands {
+		fmt.Fprintf(writer, "  %-11s %s\n", command.name, command.usage)
+	}
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "Flags (of watch, once and run):")
+	flag.PrintDefaults()
+}
+
+// focusPackageArguments is the expression (see Focus) matching the packages `scantest run` was
+// given: import paths, or folders (relative to the working directory), either of which
+// may end with /... (for the packages under it too).
+func focusPackageArguments(root string, arguments []string) (string, error) {
+	alternatives := []string{}
+	for _, argument := range arguments {
+		pattern, recursive := strings.TrimSuffix(argument, "/..."), strings.HasSuffix(argument, "/...")
+		if pattern == "." || pattern == "..." {
+			pattern = "."
+		}
+		pkg, err := build.Default.Import(pattern, root, build.FindOnly)
+		if err != nil || pkg.ImportPath == "" || pkg.ImportPath == "." {
+			return "", fmt.Errorf("run: '%s' isn't a package (or a folder of packages) here.", argument)
+		}
+		expression := regexp.QuoteMeta(pkg.ImportPath)
+		if recursive {
+			expression += "(/.*)?"
+		}
+		alternatives = append(alternatives, expression)
+	}
+	if len(alternatives) == 0 {
+		return "", fmt.Errorf("run: which packages? (ie. scantest run ./store example.com/app/api/...)")
+	}
+	return "^(" + strings.Join(alternatives, "|") + ")$", nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// listHistory implements `scantest history [-n 20] [package]`: a line for each of the
+// latest runs (or, for a package, its status and duration in each of them).
+func listHistory(arguments []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	count := flags.Int("n", 20, "The number of runs listed (the latest ones).")
+	flags.Parse(arguments)
+
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	packageName := flags.Arg(0)
+	if strings.HasPrefix(packageName, ".") {
+		if pkg, err := build.Default.Import(packageName, workingDirectory, build.FindOnly); err == nil {
+			packageName = pkg.ImportPath
+		}
+	}
+	records := NewHistory(workingDirectory).Recent(historyRetention)
+	listed := 0
+	for x := len(records) - 1; x >= 0 && listed < *count; x-- {
+		record := records[x]
+		line := record.Time.Format("2006-01-02 15:04:05")
+		if packageName != "" {
+			found := false
+			for _, pkg := range record.Packages {
+				if pkg.PackageName == packageName {
+					line += fmt.Sprintf("  %-15s %s", statusLabels[pkg.Status], pkg.Duration.Round(time.Millisecond*10))
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+		} else {
+			passed, failed, duration := 0, 0, time.Duration(0)
+			for _, pkg := range record.Packages {
+				if pkg.Status == TestsPassed {
+					passed++
+				} else {
+					failed++
+				}
+				if pkg.Duration > duration {
+					duration = pkg.Duration
+				}
+			}
+			line += fmt.Sprintf("  %3d passed, %3d failed  (slowest: %s)", passed, failed, duration.Round(time.Millisecond*10))
+		}
+		if record.Git != nil {
+			line += "  " + record.Git.String()
+		}
+		fmt.Println(line)
+		listed++
+	}
+	if listed == 0 {
+		fmt.Fprintln(os.Stderr, "No runs recorded yet (see -history).")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// runCompletion implements `scantest completion bash|zsh|fish`, which prints the
+// completion script of the shell (ie. `source <(scantest completion bash)`): the
+// commands, the flags (and the values of some), and for `run`, `stress` and
+// `history`, the import paths of the packages under the working directory (which
+// the script gets from `scantest completion packages`).
+func runCompletion(arguments []string) {
+	if len(arguments) == 1 && arguments[0] == "packages" {
+		workingDirectory, err := os.Getwd()
+		if err != nil {
+			os.Exit(1)
+		}
+		names := []string{}
+		for name := range buildGraph(workingDirectory, true, false) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println(strings.Join(names, "\n"))
+		return
+	}
+	commands := []string{}
+	for _, command := range subcommands {
+		commands = append(commands, command.name)
+	}
+	flags := []string{}
+	flag.VisitAll(func(defined *flag.Flag) { flags = append(flags, "-"+defined.Name) })
+
+	if len(arguments) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: scantest completion bash|zsh|fish")
+		os.Exit(2)
+	}
+	switch arguments[0] {
+	case "bash":
+		fmt.Printf(bashCompletion, strings.Join(commands, " "), strings.Join(flags, " "), strings.Join(formats, " "))
+	case "zsh":
+		fmt.Printf("autoload -U +X bashcompinit && bashcompinit\n"+bashCompletion, strings.Join(commands, " "), strings.Join(flags, " "), strings.Join(formats, " "))
+	case "fish":
+		fmt.Printf("complete -c scantest -f\n")
+		for _, command := range subcommands {
+			fmt.Printf("complete -c scantest -n __fish_use_subcommand -a %s -d %s\n", command.name, shellQuote(command.usage))
+		}
+		flag.VisitAll(func(defined *flag.Flag) {
+			usage := defined.Usage
+			if end := strings.Index(usage, ". "); end >= 0 {
+				usage = usage[:end+1]
+			}
+			fmt.Printf("complete -c scantest -o %s -d %s\n", defined.Name, shellQuote(usage))
+		})
+		fmt.Printf("complete -c scantest -o format -x -a %s\n", shellQuote(strings.Join(formats, " ")))
+		fmt.Printf("complete -c scantest -n '__fish_seen_subcommand_from run stress history' -a '(scantest completion packages 2>/dev/null)'\n")
+		fmt.Printf("complete -c scantest -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'\n")
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown shell '%s' (expected bash, zsh or fish).\n", arguments[0])
+		os.Exit(2)
+	}
+}
+
+// bashCompletion is the script for bash (and zsh, by way of bashcompinit), with the
+// commands, the flags and the formats.
+const bashCompletion = `_scantest() {
+	local current="${COMP_WORDS[COMP_CWORD]}" previous="${COMP_WORDS[COMP_CWORD-1]}" command="${COMP_WORDS[1]}"
+	if [ "$previous" = "-format" ]; then
+		COMPREPLY=($(compgen -W "%[3]s" -- "$current"))
+	elif [[ "$current" == -* ]]; then
+		COMPREPLY=($(compgen -W "%[2]s" -- "$current"))
+	elif [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%[1]s" -- "$current"))
+	elif [ "$command" = "completion" ]; then
+		COMPREPLY=($(compgen -W "bash zsh fish" -- "$current"))
+	elif [ "$command" = "run" ] || [ "$command" = "stress" ] || [ "$command" = "history" ]; then
+		COMPREPLY=($(compgen -W "$(scantest completion packages 2>/dev/null)" -- "$current"))
+	fi
+}
+complete -o default -F _scantest scantest
+`
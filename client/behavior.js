@@ -12,48 +12,136 @@
 $(function() {
 	var ws = new WebSocket('ws://localhost:8888/socket');
 	ws.onopen = function() { console.log('CONNECT'); };
-	ws.onclose = function() { console.log('DISCONNECT'); };
+	ws.onclose = function() { console.log('DISCONNECT'); $.notify('Disconnected from scantest', 'error'); };
 	$('#execute').click(function() {
 		ws.send('\n'); // \n is the signal to the server to re-run tests.
 		$('pre').fadeOut();
 	});
-	ws.onmessage = function(event) {
-		if (event.data == "Running tests...") {
-			$('pre').fadeOut();
-			$('center').fadeOut();
-			$.notify("Wait for it...", 'info');
-			return;
+
+	var lastHeartbeat = new Date();
+	setInterval(function() {
+		if (new Date() - lastHeartbeat > 45000) { // 3 missed heartbeats
+			$.notify('No word from scantest since '+lastHeartbeat.toLocaleTimeString(), 'warn');
+			lastHeartbeat = new Date();
 		}
+	}, 5000);
 
-		$('pre').hide();
-		var data = JSON.parse(event.data);
-		console.log("DATA:", data);
-
-		var passed = true;
-		for (var x = 0; x < data.packages.length; x++) {
-			var pkg = data.packages[x];
-
-			if (pkg.Status == 3) { // success:
-				$('<pre><code id="'+pkg.PackageName+'" class="pass">'+pkg.Output+'</code></pre>').appendTo('body').hide().fadeIn();
-			} else if (pkg.Status == 2) { // failed tests:
-				passed = false;
-				var failures = '<pre><code class="fail">FAILURES: '+pkg.PackageName+'\n\n'+'</code>';
-				for (var y = 0; y < pkg.Failures.length; y++) {
-					failures += '<code class="fail">'+pkg.Failures[y]+'</code>';
-				}
-				failures += '</pre>';
-				$(failures).appendTo('body').hide().fadeIn();
+	// render appends a package (or a module header) to the page, reporting whether it passed.
+	var render = function(pkg) {
+		if (pkg.Header) {
+			$('<pre><code class="'+(pkg.Failed ? 'fail' : 'pass')+'">'+pkg.Header+'</code></pre>').appendTo('body').hide().fadeIn();
+			return !pkg.Failed;
+		}
+		var name = pkg.PackageName + (pkg.Narrowed ? ' (only '+pkg.Narrowed.join(', ')+')' : '') + (pkg.NewFailures ? ' {'+pkg.NewFailures.length+' new}' : '') + (pkg.StillFailing ? ' {'+pkg.StillFailing.length+' still failing}' : '') + (pkg.Skipped ? ' {'+pkg.Skipped.length+' skipped}' : '') + (pkg.Baseline ? ' ['+pkg.Baseline+']' : '') + ' ('+(pkg.Duration/1e9).toFixed(2)+'s'+(pkg.CPUTime ? ', cpu '+(pkg.CPUTime/1e9).toFixed(2)+'s' : '')+(pkg.MaxRSS ? ', '+(pkg.MaxRSS/1048576).toFixed(1)+' MB max rss' : '')+', finished at '+new Date(pkg.Finished).toLocaleTimeString()+')' + (pkg.Generated ? ' [go generate changed: '+pkg.Generated.join(', ')+']' : '') + (pkg.Matrix ? ' ['+pkg.Matrix.map(function(entry) { return entry.Entry+': '+(entry.Status == 3 ? 'pass' : 'fail'); }).join('; ')+']' : '');
+		if (pkg.Status == 3) { // success:
+			$('<pre title="'+name+'"><code id="'+pkg.PackageName+'" class="pass">'+pkg.Output+'</code></pre>').appendTo('body').hide().fadeIn();
+			return true;
+		}
+		if (pkg.Status == 2) { // failed tests:
+			var failures = '<pre><code class="fail">FAILURES: '+name+'\n\n'+'</code>';
+			for (var y = 0; y < pkg.Failures.length; y++) {
+				failures += '<code class="fail">'+pkg.Failures[y]+'</code>';
 			}
-			if (pkg.Status <= 2) { // failed tests and broken packages:
-				passed = false;
-				$('<pre><code id="'+pkg.PackageName+'" class="fail">'+pkg.Output+'</code></pre>').appendTo('body').hide().fadeIn();
+			failures += '</pre>';
+			$(failures).appendTo('body').hide().fadeIn();
+		}
+		// failed tests and broken packages:
+		$('<pre><code id="'+pkg.PackageName+'" class="fail">'+pkg.Output+'</code></pre>').appendTo('body').hide().fadeIn();
+		if (pkg.Hints) {
+			var hints = '<pre>';
+			for (var y = 0; y < pkg.Hints.length; y++) {
+				var hint = pkg.Hints[y];
+				hints += '<code class="warn">Hint: '+(/^https?:\/\/\S+$/.test(hint) ? '<a href="'+hint+'" target="_blank">'+hint+'</a>' : hint)+'</code>\n';
 			}
+			$(hints+'</pre>').appendTo('body').hide().fadeIn();
 		}
-		if (passed) {
-			$.notify('OK', 'success');
-		} else {
-			$.notify(':(', 'error')
+		return false;
+	};
+
+	var handlers = {
+		'run-start': function(message) {
+			$('pre').remove();
+			$('center').fadeOut();
+			$.notify(message.banner.replace("Running tests...", "Wait for it...").trim(), 'info');
+		},
+
+		// package-result is rendered as soon as it arrives (run-end replaces it all).
+		'package-result': function(message) {
+			render(message.result);
+		},
+
+		// progress updates the line at the top of the page as packages finish.
+		'progress': function(message) {
+			var line = $('#progress');
+			if (!line.length) {
+				line = $('<pre id="progress"><code class="warn"></code></pre>').prependTo('body');
+			}
+			line.find('code').text('Running: ' + message.text);
+		},
+
+		'run-end': function(message) {
+			$('pre').remove();
+			var data = message.run;
+			var packages = data.packages;
+			if (data.modules) { // group by module (those with failures come last)
+				packages = [];
+				for (var m = 0; m < data.modules.length; m++) {
+					var module = data.modules[m];
+					packages.push({Header: 'MODULE '+module.module+' ('+module.passed+' passed, '+module.failed+' failed)', Failed: module.failed > 0});
+					for (var x = 0; x < data.packages.length; x++) {
+						if ((data.packages[x].Module || '') == module.module) {
+							packages.push(data.packages[x]);
+						}
+					}
+				}
+			}
+
+			var passed = true, added = 0, still = 0, fixed = [];
+			for (var x = 0; x < packages.length; x++) {
+				passed = render(packages[x]) && passed;
+				added += (packages[x].NewFailures || []).length;
+				still += (packages[x].StillFailing || []).length;
+				fixed = fixed.concat(packages[x].Fixed || []);
+			}
+			if (added + still + fixed.length > 0) {
+				$('<pre><code class="'+(added ? 'fail' : 'warn')+'">FAILURES: '+added+' new, '+still+' still failing, '+fixed.length+' fixed'+(fixed.length ? '\n\nFIXED: '+fixed.join(', ') : '')+'</code></pre>').appendTo('body').hide().fadeIn();
+			}
+			if (data.git) {
+				$('<pre><code>TESTED: '+data.git.branch+'@'+data.git.commit.substring(0, 12)+(data.git.dirty ? ' ('+data.git.dirty+' dirty)' : '')+'</code></pre>').appendTo('body').hide().fadeIn();
+			}
+			if (data.pending) {
+				var pending = [];
+				for (var p = 0; p < data.pending.length; p++) {
+					pending.push(data.pending[p].package+' (due '+new Date(data.pending[p].due).toLocaleTimeString()+')');
+				}
+				$('<pre><code class="warn">PENDING (rerun interval):\n\n'+pending.join('\n')+'</code></pre>').appendTo('body').hide().fadeIn();
+			}
+			if (data.diagnostics) {
+				$('<pre><code class="warn">DIAGNOSTICS:\n\n'+data.diagnostics.join('\n')+'</code></pre>').appendTo('body').hide().fadeIn();
+			}
+			if (passed) {
+				$.notify('OK', 'success');
+			} else {
+				$.notify(':(', 'error')
+			}
+			$('center').fadeIn();
+		},
+
+		'log': function(message) {
+			console.log('LOG:', message.text);
+			$.notify(message.text, 'info');
+		},
+
+		'heartbeat': function(message) {}
+	};
+
+	ws.onmessage = function(event) {
+		lastHeartbeat = new Date();
+		var message = JSON.parse(event.data);
+		console.log("MESSAGE:", message);
+		var handler = handlers[message.type];
+		if (handler) {
+			handler(message);
 		}
-		$('center').fadeIn();
 	};
-});
\ No newline at end of file
+});
@@ -0,0 +1,64 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// newCommand places the child in its own process group so that cancelling ctx
+// also kills anything it spawned (like the test binary compiled by `go test`).
+// Being out of the terminal's group, the children don't get its <ctrl>+c or SIGHUP,
+// so quitting cancels the run in flight (see Runner.Stop) rather than orphan them.
+func newCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	command := exec.CommandContext(ctx, name, args...)
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	command.Cancel = func() error {
+		return syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+	}
+	return command
+}
+
+// lockFile takes an exclusive advisory lock on the file, without waiting for it.
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+// terminate asks the process to stop (scantest restores the terminal and exits).
+func terminate(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+func newShellCommand(ctx context.Context, line string) *exec.Cmd {
+	return newCommand(ctx, "sh", "-c", line)
+}
+
+// newWrapperCommand runs the shell command line with the arguments appended (as is).
+func newWrapperCommand(ctx context.Context, line string, args ...string) *exec.Cmd {
+	return newCommand(ctx, "sh", append([]string{"-c", line + ` "$@"`, "sh"}, args...)...)
+}
+
+// lowerPriority runs the command by way of nice (and ionice, where there is one), so
+// that it (and whatever it starts) yields the CPU and the disk to everything else.
+func lowerPriority(command *exec.Cmd, nice int) {
+	prefix := []string{}
+	if path, err := exec.LookPath("ionice"); err == nil {
+		prefix = append(prefix, path, "-c", "2", "-n", "7") // (the lowest best-effort priority)
+	}
+	if path, err := exec.LookPath("nice"); err == nil {
+		prefix = append(prefix, path, "-n", strconv.Itoa(nice))
+	}
+	if len(prefix) == 0 {
+		return
+	}
+	command.Args = append(prefix, append([]string{command.Path}, command.Args[1:]...)...)
+	command.Path = prefix[0]
+}
@@ -0,0 +1,80 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+func newCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+)
+
+// lockFile takes an exclusive lock on a byte of the file far past its contents
+// (locks are mandatory on windows, and the pid has to stay readable), without
+// waiting for it.
+func lockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{OffsetHigh: 1}
+	if ok, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped))); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{OffsetHigh: 1}
+	if ok, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped))); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func terminate(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
+
+// newShellCommand hands the line to cmd as is (the arguments of exec.Command would be
+// escaped the way of C programs, with backslashes, which cmd doesn't understand).
+func newShellCommand(ctx context.Context, line string) *exec.Cmd {
+	command := newCommand(ctx, "cmd")
+	command.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd /S /C "` + line + `"`}
+	return command
+}
+
+func newWrapperCommand(ctx context.Context, line string, args ...string) *exec.Cmd {
+	for _, arg := range args {
+		line += ` "` + strings.Replace(arg, `"`, `""`, -1) + `"`
+	}
+	return newShellCommand(ctx, line)
+}
+
+const belowNormalPriorityClass = 0x00004000
+
+// lowerPriority starts the command below normal priority (which whatever it starts
+// inherits), so that it yields the CPU to everything else.
+func lowerPriority(command *exec.Cmd, nice int) {
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	command.SysProcAttr.CreationFlags |= belowNormalPriorityClass
+}
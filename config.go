@@ -0,0 +1,566 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const configFilename = ".scantest.toml"
+
+// Settings are the options that may be changed while scantest is running, by
+// editing .scantest.toml (flags given on the command line always win):
+//
+//	parallel = 4                  # packages tested at once
+//	ignore = ["testdata/big/"]    # gitignore-style patterns, relative to the working directory
+//	skip-dirs = ["tmp"]           # see skippedFolder
+//	max-output = "16MB"           # see OutputBuffer
+//	settle = "100ms"              # see unsettled
+//	test-args = ["-count=1"]      # extra arguments for `go test`
+//	profile = "race"              # the [profiles.<name>] table to apply on top of the above
+//	check-updates = true          # announce newer releases of scantest (never installed)
+//	expected-skips = ["short"]    # see markUnexpectedSkips
+//	cooldown = 3                  # see Runner.cooldown
+//	retry-args = ["-race"]        # see Runner.retry
+//	redact = ['token=(\S+)']      # see Redactor
+//	forbidden-skips = ["TODO"]    # see enforcePolicy
+//	min-coverage = 60             # see enforcePolicy
+//	sort = ["status", "name"]     # see sortKeys
+//
+//	[profiles.race]
+//	parallel = 2
+//	test-args = ["-race"]
+//
+//	[mocks.store]                 # see MockRule
+//	sources = ["store/interfaces.go"]
+//	generate = "store/mocks"
+//
+//	[throttle]                    # see ThrottleSettings
+//	enabled = true
+//	max-load = 1.5
+//	on-battery = true
+//	scan-interval = "2s"
+//	parallel = 1
+//
+//	[background]                  # see BackgroundSettings
+//	enabled = true
+//	nice = 10
+//
+//	[remote]                      # see RemoteSettings
+//	ssh = "me@builder"
+//
+//	[notify]                      # see NotifySettings
+//	webhook = "https://hooks.slack.com/services/..."
+//
+//	[sweep]                       # see SweepSettings
+//	every = "1h"
+//
+//	[network]                     # see NetworkSettings
+//	mode = "auto"
+//
+//	[env]                         # see Settings.Environ
+//	GOPRIVATE = "example.com/*"
+//
+//	[rerun]                       # see RerunRule
+//	"example.com/app/integration/..." = "5m"
+//
+//	[hints]                       # see HintRule
+//	':5432: connect: connection refused' = "start the dev database: make db-up"
+//
+//	[validators.gunit]            # see ValidatorRule
+//
+//	[keys]                        # see defaultKeys
+//	run-all = "R"
+//
+//	[commands]
+//	l = "make lint"
+type Settings struct {
+	Parallel       int
+	Ignore         []string
+	TestArgs       []string
+	Profile        string
+	MaxFileSize    int64    // in bytes: larger files aren't scanned (0: no limit)
+	MaxOutput      int64    // in bytes: the output of a command kept in memory, past which it spills to a file (see OutputBuffer; 0: no limit)
+	SkipDirs       []string // names (or patterns) of the folders not scanned, on top of defaultSkipDirs (see skippedFolder)
+	CheckUpdates   bool     // announce newer releases (checked at most once a day)
+	Cooldown       int      // consecutive identical failures after which a package is left alone until its own files change (0: never)
+	ExpectedSkips  []string // regular expressions: the reasons for skipping tests that -warn-skips doesn't warn about
+	RetryArgs      []string // extra arguments for testing failed packages again with -quiet
+	Redact         []string // regular expressions: the secrets masked in the output (see Redactor)
+	Sort           []string // the order of the results (see sortKeys)
+	ForbiddenSkips []string // regular expressions: the reasons for skipping tests that fail a package (see enforcePolicy)
+	MinCoverage    float64  // the percentage of statements below which a package fails (0: none)
+	Throttle       ThrottleSettings
+	Background     BackgroundSettings
+	Mocks          []MockRule
+	Validators     []ValidatorRule
+	Rerun          []RerunRule
+	Hints          []HintRule
+	Parallelism    ParallelismSettings
+	Idle           IdleSettings
+	Sweep          SweepSettings
+	Remote         RemoteSettings
+	Notify         NotifySettings
+	Network        NetworkSettings
+	Packages       []PackageRule
+	Affects        []AffectsRule
+	Matrix         []MatrixRule
+	Settle         time.Duration     // how long a changed file has to stay the same before it's tested (see unsettled; 0: not at all)
+	Env            map[string]string // see Settings.Environ
+	Keys           map[string]string // key: action, value: key name
+	Commands       map[string]string // key: key name (after the chord key), value: shell command
+}
+
+type Profile struct {
+	Parallel int
+	Ignore   []string
+	TestArgs []string
+}
+
+type Config struct {
+	Settings
+	Profiles map[string]Profile
+}
+
+// readConfigDocument parses the file (a missing file being an empty document).
+func readConfigDocument(path string) (map[string]interface{}, map[string]Position, error) {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]interface{}{}, map[string]Position{}, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return parseTOML(string(raw))
+}
+
+func decodeConfig(document map[string]interface{}, positions map[string]Position) (*Config, error) {
+	config := &Config{Profiles: map[string]Profile{}}
+	config.Throttle = defaultThrottle
+	config.Background = defaultBackground
+	config.Idle = defaultIdle
+	config.Network = defaultNetwork
+	config.Validators = defaultValidators
+	config.Sort = defaultOrder
+	config.MaxOutput = defaultMaxOutput
+	config.Settle = defaultSettle
+	decoder := &configDecoder{positions: positions}
+	for key, value := range document {
+		switch key {
+		case "profile":
+			config.Profile = decoder.string(key, value)
+		case "max-file-size":
+			config.MaxFileSize = decoder.size(key, value)
+		case "max-output":
+			config.MaxOutput = decoder.size(key, value)
+		case "settle":
+			settle, err := time.ParseDuration(decoder.string(key, value))
+			if err != nil || settle < 0 {
+				decoder.fail(key, "must be a duration (ie. \"100ms\", or \"0s\" not to wait).")
+			}
+			config.Settle = settle
+		case "skip-dirs":
+			config.SkipDirs = decoder.skipDirs(key, value)
+		case "check-updates":
+			config.CheckUpdates = decoder.boolean(key, value)
+		case "cooldown":
+			config.Cooldown = decoder.positive(key, value)
+		case "expected-skips":
+			config.ExpectedSkips = decoder.patterns(key, value)
+		case "retry-args":
+			config.RetryArgs = decoder.strings(key, value)
+		case "redact":
+			config.Redact = decoder.patterns(key, value)
+		case "sort":
+			config.Sort = decoder.sort(key, value)
+		case "forbidden-skips":
+			config.ForbiddenSkips = decoder.patterns(key, value)
+		case "min-coverage":
+			config.MinCoverage = decoder.percentage(key, value)
+		case "profiles":
+			for name, table := range decoder.table(key, value) {
+				path := key + "." + name
+				profile := Profile{}
+				for key, value := range decoder.table(path, table) {
+					if !decoder.setting(path+"."+key, key, value, &profile.Parallel, &profile.Ignore, &profile.TestArgs) {
+						decoder.unknown(path+"."+key, key, profileKeys...)
+					}
+				}
+				config.Profiles[name] = profile
+			}
+		case "mocks":
+			config.Mocks = decoder.mocks(key, value)
+		case "env":
+			config.Env = decoder.env(key, value)
+		case "rerun":
+			config.Rerun = decoder.rerun(key, value)
+		case "hints":
+			config.Hints = decoder.hints(key, value)
+		case "parallelism":
+			config.Parallelism = decoder.parallelism(key, value)
+		case "validators":
+			config.Validators = decoder.validators(key, value)
+		case "keys":
+			config.Keys = decoder.keys(key, value)
+		case "commands":
+			config.Commands = decoder.commands(key, value)
+		case "packages":
+			config.Packages = decoder.packages(key, value)
+		case "affects":
+			config.Affects = decoder.affects(key, value)
+		case "matrix":
+			config.Matrix = decoder.matrix(key, value)
+		case "idle":
+			decoder.idle(key, value, &config.Idle)
+		case "remote":
+			decoder.remote(key, value, &config.Remote)
+		case "notify":
+			decoder.notify(key, value, &config.Notify)
+		case "sweep":
+			decoder.sweep(key, value, &config.Sweep)
+		case "network":
+			decoder.network(key, value, &config.Network)
+		case "background":
+			decoder.background(key, value, &config.Background)
+		case "throttle":
+			for name, value := range decoder.table(key, value) {
+				decoder.throttle(key+"."+name, name, value, &config.Throttle)
+			}
+		default:
+			if !decoder.setting(key, key, value, &config.Parallel, &config.Ignore, &config.TestArgs) {
+				decoder.unknown(key, key, configKeys...)
+			}
+		}
+	}
+	if decoder.err == nil {
+		decoder.conflicts(config)
+	}
+	if decoder.err != nil {
+		return nil, decoder.err
+	}
+	return config, nil
+}
+
+// configKeys are the top-level keys of .scantest.toml (see decodeConfig), and
+// profileKeys those of its profiles.
+var (
+	configKeys = []string{"parallel", "ignore", "test-args", "profile", "max-file-size", "max-output", "settle", "skip-dirs", "check-updates", "cooldown",
+		"expected-skips", "retry-args", "redact", "sort", "forbidden-skips", "min-coverage", "profiles", "mocks", "env",
+		"rerun", "hints", "parallelism", "validators", "keys", "commands", "packages", "affects", "matrix", "idle",
+		"sweep", "remote", "notify", "network", "background", "throttle"}
+	profileKeys = []string{"parallel", "ignore", "test-args"}
+)
+
+// Resolve applies the active profile (the one named on the command line, or else in the file).
+func (self *Config) Resolve(profile string) (Settings, error) {
+	settings := self.Settings
+	if profile == "" {
+		profile = self.Profile
+	}
+	settings.Profile = profile
+	if profile == "" {
+		return settings, nil
+	}
+	overrides, found := self.Profiles[profile]
+	if !found {
+		return settings, fmt.Errorf("The profile '%s' isn't defined in %s.", profile, configFilename)
+	}
+	if overrides.Parallel > 0 {
+		settings.Parallel = overrides.Parallel
+	}
+	settings.Ignore = append(append([]string{}, settings.Ignore...), overrides.Ignore...)
+	settings.TestArgs = append(append([]string{}, settings.TestArgs...), overrides.TestArgs...)
+	return settings, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+type configDecoder struct {
+	positions map[string]Position
+	err       error
+}
+
+func (self *configDecoder) fail(path, format string, args ...interface{}) {
+	if self.err == nil {
+		self.err = fmt.Errorf("%s: %s", self.positions[path], fmt.Sprintf("'"+path+"' "+format, args...))
+	}
+}
+
+// setting decodes the settings a profile may override, reporting whether the key is one.
+func (self *configDecoder) setting(path, key string, value interface{}, parallel *int, ignore, testArgs *[]string) bool {
+	switch key {
+	case "parallel":
+		if number, ok := value.(int64); ok && number > 0 {
+			*parallel = int(number)
+		} else {
+			self.fail(path, "must be a positive integer.")
+		}
+	case "ignore":
+		*ignore = self.globs(path, value)
+	case "test-args":
+		*testArgs = self.strings(path, value)
+	default:
+		return false
+	}
+	return true
+}
+
+// unknown fails for a key that isn't one of the known ones, suggesting the closest.
+func (self *configDecoder) unknown(path, key string, known ...string) {
+	if suggestion := closest(key, known); suggestion != "" {
+		self.fail(path, "isn't a setting (did you mean '%s'?).", suggestion)
+	} else {
+		self.fail(path, "isn't a setting (expected one of: %s).", strings.Join(known, ", "))
+	}
+}
+
+// globs decodes gitignore-style patterns (see parseIgnoreLines).
+func (self *configDecoder) globs(path string, value interface{}) []string {
+	patterns := self.strings(path, value)
+	for _, pattern := range patterns {
+		trimmed := strings.TrimSpace(pattern)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") && len(parseIgnoreLines([]string{pattern})) == 0 {
+			self.fail(path, "must be gitignore-style patterns, which '%s' isn't.", pattern)
+		}
+	}
+	return patterns
+}
+
+func (self *configDecoder) throttle(path, key string, value interface{}, throttle *ThrottleSettings) {
+	switch key {
+	case "enabled":
+		throttle.Enabled = self.boolean(path, value)
+	case "on-battery":
+		throttle.OnBattery = self.boolean(path, value)
+	case "max-load":
+		switch number := value.(type) {
+		case float64:
+			throttle.MaxLoad = number
+		case int64:
+			throttle.MaxLoad = float64(number)
+		default:
+			self.fail(path, "must be a number.")
+		}
+	case "scan-interval":
+		interval, err := time.ParseDuration(self.string(path, value))
+		if err != nil || interval <= 0 {
+			self.fail(path, "must be a positive duration (ie. \"2s\").")
+		}
+		throttle.ScanInterval = interval
+	case "parallel":
+		if number, ok := value.(int64); ok && number > 0 {
+			throttle.Parallel = int(number)
+		} else {
+			self.fail(path, "must be a positive integer.")
+		}
+	default:
+		self.unknown(path, key, "enabled", "on-battery", "max-load", "scan-interval", "parallel")
+	}
+}
+
+// size decodes a number of bytes, or a string like "512KB", "100MB" or "2GB".
+func (self *configDecoder) size(path string, value interface{}) int64 {
+	if number, ok := value.(int64); ok && number >= 0 {
+		return number
+	}
+	text, _ := value.(string)
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	for _, unit := range units {
+		if strings.HasSuffix(strings.ToUpper(text), unit.suffix) {
+			number, err := strconv.ParseInt(strings.TrimSpace(text[:len(text)-len(unit.suffix)]), 10, 64)
+			if err == nil && number >= 0 {
+				return number * unit.multiplier
+			}
+			break
+		}
+	}
+	self.fail(path, "must be a size (ie. 1048576, \"512KB\", \"100MB\" or \"2GB\").")
+	return 0
+}
+
+func (self *configDecoder) boolean(path string, value interface{}) bool {
+	flag, ok := value.(bool)
+	if !ok {
+		self.fail(path, "must be true or false.")
+	}
+	return flag
+}
+
+func (self *configDecoder) string(path string, value interface{}) string {
+	text, ok := value.(string)
+	if !ok {
+		self.fail(path, "must be a string.")
+	}
+	return text
+}
+
+func (self *configDecoder) strings(path string, value interface{}) (values []string) {
+	list, ok := value.([]interface{})
+	if !ok {
+		self.fail(path, "must be an array of strings.")
+		return nil
+	}
+	for _, item := range list {
+		text, ok := item.(string)
+		if !ok {
+			self.fail(path, "must be an array of strings.")
+			return nil
+		}
+		values = append(values, text)
+	}
+	return values
+}
+
+func (self *configDecoder) table(path string, value interface{}) map[string]interface{} {
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		self.fail(path, "must be a table.")
+	}
+	return table
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// ConfigWatcher keeps the current settings, re-reading .scantest.toml (and the global
+// config and the policy, see loadLayeredConfig) whenever it changes (a broken file is reported and
+// the previous settings are kept).
+type ConfigWatcher struct {
+	path      string
+	global    string
+	policy    *Policy
+	overrides Settings // from command line flags
+
+	mutex     sync.Mutex
+	settings  Settings
+	signature string // the modification times and sizes of the files
+	problem   error  // with the files, as of the latest reload
+}
+
+func NewConfigWatcher(root string, overrides Settings) *ConfigWatcher {
+	self := &ConfigWatcher{
+		path:      filepath.Join(root, configFilename),
+		global:    globalConfigPath(),
+		policy:    NewPolicy(root, policySource),
+		overrides: overrides,
+		settings:  Settings{Parallel: runtime.NumCPU(), Throttle: defaultThrottle, Validators: defaultValidators},
+	}
+	self.reload(false)
+	return self
+}
+
+func (self *ConfigWatcher) Settings() Settings {
+	if self == nil {
+		return Settings{Parallel: runtime.NumCPU(), Throttle: defaultThrottle, Validators: defaultValidators}
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.settings
+}
+
+// Problem is what's wrong with the config files (nil when they're fine), as of the
+// latest reload.
+func (self *ConfigWatcher) Problem() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.problem
+}
+
+func (self *ConfigWatcher) WatchForever() {
+	for {
+		time.Sleep(time.Second)
+		self.reload(true)
+	}
+}
+
+func (self *ConfigWatcher) reload(verbose bool) {
+	layers := []string{self.policy.Path(), self.global}
+	signature := ""
+	for _, path := range append(layers, self.path) {
+		if info, err := os.Stat(path); err == nil {
+			signature += fmt.Sprintf("%s %d %d\n", path, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	self.mutex.Lock()
+	unchanged := signature == self.signature
+	self.signature = signature
+	self.mutex.Unlock()
+	if unchanged && verbose {
+		return
+	}
+
+	config, err := loadLayeredConfig(layers, self.path)
+	settings := Settings{}
+	if err == nil {
+		settings, err = config.Resolve(self.overrides.Profile)
+	}
+	self.mutex.Lock()
+	self.problem = err
+	self.mutex.Unlock()
+	if err != nil && verbose {
+		logf("%s (keeping the previous settings)", err)
+		return
+	} else if err != nil {
+		logf("%s", err) // (at startup, see Problem)
+		return
+	}
+	if settings.Parallel == 0 {
+		settings.Parallel = runtime.NumCPU()
+	}
+	if self.overrides.Parallel > 0 {
+		settings.Parallel = self.overrides.Parallel
+	}
+	if self.overrides.Throttle.Enabled {
+		settings.Throttle.Enabled = true
+	}
+	if self.overrides.Background.Enabled {
+		settings.Background.Enabled = true
+	}
+
+	self.mutex.Lock()
+	previous := self.settings
+	self.settings = settings
+	self.mutex.Unlock()
+
+	if verbose {
+		for _, change := range describeChanges(previous, settings) {
+			logf("Config changed: %s", change)
+		}
+	}
+}
+
+// secretSettings are the settings whose values may hold secrets (tokens in [env],
+// webhook URLs in [notify]), so that only their names are logged when they change:
+// the log reaches -serve's subscribers too.
+var secretSettings = map[string]bool{"Env": true, "Notify": true}
+
+func describeChanges(before, after Settings) (changes []string) {
+	previous, current := reflect.ValueOf(before), reflect.ValueOf(after)
+	for x := 0; x < previous.NumField(); x++ {
+		if reflect.DeepEqual(previous.Field(x).Interface(), current.Field(x).Interface()) {
+			continue
+		}
+		name := previous.Type().Field(x).Name
+		if secretSettings[name] {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, redacted, redacted))
+		} else {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, previous.Field(x).Interface(), current.Field(x).Interface()))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
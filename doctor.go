@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+type Checkup struct {
+	Name   string
+	Level  int // checkupOK, checkupWarning or checkupFailed
+	Detail string
+	Fix    string // what to do about a warning or failure
+}
+
+const (
+	checkupOK = iota
+	checkupWarning
+	checkupFailed
+)
+
+// runDoctor implements `scantest doctor`, which verifies up front what scantest
+// needs from the environment (and says how to fix whatever is missing) rather
+// than letting it fail cryptically mid-run. It exits with 1 if anything failed.
+func runDoctor(arguments []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	web := flags.Bool("web", false, "When true, also check what scantest-web needs.")
+	gitignore := flags.Bool("gitignore", true, "When true, paths matched by .gitignore files aren't scanned.")
+	flags.StringVar(&policySource, "policy", policySource, "A file or a URL (the default is $SCANTEST_POLICY) with the config an organization mandates.")
+	flags.Parse(arguments)
+
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	settings := Settings{}
+	policy := NewPolicy(workingDirectory, policySource).Path()
+	if config, err := loadLayeredConfig([]string{policy, globalConfigPath()}, filepath.Join(workingDirectory, configFilename)); err == nil {
+		settings = config.Settings // (problems with the file are reported by checkConfig)
+	}
+	environment := settings.Environ()
+
+	checkups := []Checkup{
+		checkGo(),
+		checkEnvironment(settings),
+		checkWorkspace(workingDirectory, environment),
+		checkBuildCache(environment),
+		checkStateFolder(workingDirectory),
+		checkConfig(workingDirectory, policy),
+		checkScan(workingDirectory, *gitignore),
+		checkGunit(workingDirectory, *gitignore),
+	}
+	if *web {
+		checkups = append(checkups, checkWebsocketd())
+	}
+
+	failed := false
+	for _, checkup := range checkups {
+		switch checkup.Level {
+		case checkupOK:
+			fmt.Printf("%sok%s      %s: %s\n", green, reset, checkup.Name, checkup.Detail)
+		case checkupWarning:
+			fmt.Printf("%swarning%s %s: %s\n", yellow, reset, checkup.Name, checkup.Detail)
+		default:
+			failed = true
+			fmt.Printf("%sFAILED%s  %s: %s\n", red, reset, checkup.Name, checkup.Detail)
+		}
+		if checkup.Level != checkupOK && checkup.Fix != "" {
+			fmt.Println("        fix:", checkup.Fix)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func checkGo() Checkup {
+	checkup := Checkup{Name: "go"}
+	if _, err := exec.LookPath("go"); err != nil {
+		checkup.Level = checkupFailed
+		checkup.Detail = "the go command wasn't found on the PATH."
+		checkup.Fix = "Install Go (https://go.dev/dl/) and add its bin folder to the PATH."
+		return checkup
+	}
+	output, err := exec.Command("go", "version").CombinedOutput()
+	if err != nil {
+		checkup.Level = checkupFailed
+		checkup.Detail = "`go version` failed: " + strings.TrimSpace(string(output))
+		checkup.Fix = "Repair (or reinstall) the Go installation."
+		return checkup
+	}
+	checkup.Detail = strings.TrimSpace(string(output))
+	return checkup
+}
+
+// checkWorkspace verifies that packages under the working directory resolve to
+// import paths, as scantest names (and tests) packages by their GOPATH import path.
+func checkWorkspace(root string, environment []string) Checkup {
+	checkup := Checkup{Name: "workspace"}
+	mode := "GOPATH mode"
+	if gomod := goEnv(environment, "GOMOD"); gomod != "" && gomod != os.DevNull {
+		mode = "module mode (" + gomod + ")"
+	}
+	if problem := describeOutsideGOPATH(root, mode); problem != "" {
+		checkup.Level = checkupFailed
+		checkup.Detail = problem
+		checkup.Fix = outsideGOPATHFix
+		return checkup
+	}
+	pkg, _ := build.ImportDir(root, build.FindOnly)
+	checkup.Detail = fmt.Sprintf("%s is %s, %s.", relativePath(root), pkg.ImportPath, mode)
+	return checkup
+}
+
+const outsideGOPATHFix = "Run scantest from a project under $GOPATH/src (or set GOPATH to include it)."
+
+// describeOutsideGOPATH is the problem with a folder that isn't inside a GOPATH src
+// folder (or "" when it is): its packages have no import path for scantest to name
+// them by, whatever the mode of the go command (which is only given the paths).
+func describeOutsideGOPATH(root, mode string) string {
+	if pkg, err := build.ImportDir(root, build.FindOnly); err == nil && pkg.ImportPath != "." {
+		return ""
+	}
+	return fmt.Sprintf("%s isn't inside a GOPATH src folder (%s), so its packages have no import path to test them by (%s).", root, build.Default.GOPATH, mode)
+}
+
+func checkBuildCache(environment []string) Checkup {
+	checkup := Checkup{Name: "build cache"}
+	cache := goEnv(environment, "GOCACHE")
+	if cache == "" || cache == "off" {
+		checkup.Level = checkupFailed
+		checkup.Detail = "the go build cache is disabled, so every run rebuilds everything (or fails, with recent versions of Go)."
+		checkup.Fix = "Set GOCACHE to a writable folder (ie. `go env -w GOCACHE=$HOME/.cache/go-build`)."
+		return checkup
+	}
+	if err := probeWritable(cache); err != nil {
+		checkup.Level = checkupFailed
+		checkup.Detail = fmt.Sprintf("%s isn't writable: %s", cache, err)
+		checkup.Fix = "Fix the permissions of that folder or point GOCACHE elsewhere (`go env -w GOCACHE=...`)."
+		return checkup
+	}
+	checkup.Detail = cache + " is writable."
+	return checkup
+}
+
+// checkEnvironment verifies that the go command accepts the [env] overrides (and
+// knows the GO... variables among them), listing the settings that decide how
+// modules are resolved.
+func checkEnvironment(settings Settings) Checkup {
+	checkup := Checkup{Name: "environment"}
+	validate := exec.Command("go", "version") // (parses GOFLAGS, unlike go env)
+	validate.Env = settings.Environ()
+	output, err := validate.CombinedOutput()
+	if err == nil {
+		command := exec.Command("go", "env", "-json")
+		command.Env = settings.Environ()
+		output, err = command.CombinedOutput()
+	}
+	if err != nil {
+		checkup.Level = checkupFailed
+		checkup.Detail = "the go command rejects the environment: " + strings.TrimSpace(string(output))
+		checkup.Fix = "Correct the variable (in the [env] table of " + configFilename + ", or in your shell)."
+		return checkup
+	}
+	known := map[string]string{}
+	json.Unmarshal(output, &known)
+
+	unknown := []string{}
+	for name := range settings.Env {
+		if _, found := known[name]; !found && strings.HasPrefix(name, "GO") {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	effective := []string{}
+	for _, name := range []string{"GOFLAGS", "GOPROXY", "GOPRIVATE", "GONOSUMDB", "GONOPROXY", "GOMODCACHE"} {
+		if value := known[name]; value != "" {
+			effective = append(effective, name+"="+value)
+		}
+	}
+	checkup.Detail = fmt.Sprintf("%d overrides from [env]; %s.", len(settings.Env), strings.Join(effective, " "))
+	if len(unknown) > 0 {
+		checkup.Level = checkupWarning
+		checkup.Detail = fmt.Sprintf("the go command doesn't know %s (set in [env]).", strings.Join(unknown, ", "))
+		checkup.Fix = "Check the spelling (ie. GONOSUMDB, GONOPROXY, GOPRIVATE, GOPROXY, GOFLAGS)."
+	}
+	return checkup
+}
+
+func checkStateFolder(root string) Checkup {
+	checkup := Checkup{Name: "state folder"}
+	folder := filepath.Join(root, stateFolder)
+	if err := probeWritable(folder); err != nil {
+		checkup.Level = checkupWarning
+		checkup.Detail = fmt.Sprintf("%s isn't writable (%s), so history and status won't be kept.", relativePath(folder), err)
+		checkup.Fix = "Fix the permissions of the working directory, or run with -history=false -status=false."
+		return checkup
+	}
+	checkup.Detail = relativePath(folder) + " is writable."
+	return checkup
+}
+
+func checkConfig(root, policy string) Checkup {
+	checkup := Checkup{Name: "config"}
+	path, global := filepath.Join(root, configFilename), globalConfigPath()
+	if policySource != "" && policy == "" {
+		checkup.Level = checkupFailed
+		checkup.Detail = "the policy (" + policySource + ") can't be read."
+		checkup.Fix = "Correct -policy (or $" + policyVariable + "), or make the policy available."
+		return checkup
+	}
+	files := []string{}
+	if policy != "" {
+		files = append(files, policy)
+	}
+	if isFile(global) {
+		files = append(files, global)
+	}
+	if isFile(path) {
+		files = append(files, configFilename)
+	}
+	if len(files) == 0 {
+		checkup.Detail = "no " + configFilename + " (using the defaults)."
+		return checkup
+	}
+	config, err := loadLayeredConfig([]string{policy, global}, path)
+	if err == nil {
+		_, err = config.Resolve("")
+	}
+	if err != nil {
+		checkup.Level = checkupFailed
+		checkup.Detail = err.Error()
+		checkup.Fix = "Correct the file (see the Configuration section of the README)."
+		return checkup
+	}
+	checkup.Detail = files[0] + " is valid."
+	if len(files) > 1 {
+		checkup.Detail = strings.Join(files[:len(files)-1], ", ") + " and " + files[len(files)-1] + " are valid."
+	}
+	return checkup
+}
+
+// checkScan times a scan, as scantest polls the file system (so no inotify
+// watches are needed, but a huge tree makes every poll expensive).
+func checkScan(root string, gitignore bool) Checkup {
+	checkup := Checkup{Name: "scanning"}
+	scanner := &FileSystemScanner{root: root, gitignore: gitignore, config: NewConfigWatcher(root, Settings{})}
+	files, goFiles, folders := 0, 0, 0
+	batch := make(chan *File)
+	started := time.Now()
+	go func() {
+		scanner.walk(batch)
+		close(batch)
+	}()
+	for file := range batch {
+		if file.IsFolder {
+			folders++
+			continue
+		}
+		files++
+		if file.IsGoFile {
+			goFiles++
+		}
+	}
+	elapsed := time.Since(started)
+	checkup.Detail = fmt.Sprintf("%d files (%d .go files) scanned in %s (scantest polls, so no inotify watches are needed).", files, goFiles, elapsed.Round(time.Millisecond))
+	if elapsed > time.Second {
+		checkup.Level = checkupWarning
+		checkup.Fix = "List big folders that hold no code under test in .gitignore or the ignore setting of " + configFilename + "."
+	}
+	if goFiles == 0 {
+		checkup.Level = checkupWarning
+		checkup.Fix = "Run scantest from the folder of a Go project."
+	}
+	if limit, ok := watchLimit(); ok {
+		if warning := describeWatchLimit(folders, limit); warning != "" {
+			checkup.Level = checkupWarning
+			checkup.Detail += " " + warning
+			checkup.Fix = fmt.Sprintf("Raise fs.inotify.max_user_watches (it's %d), or list big folders that hold no code under test in .gitignore.", limit)
+		}
+	}
+	return checkup
+}
+
+func checkGunit(root string, gitignore bool) Checkup {
+	checkup := Checkup{Name: "gunit"}
+	scanner := &FileSystemScanner{root: root, gitignore: gitignore, config: NewConfigWatcher(root, Settings{})}
+	batch := make(chan *File)
+	go func() {
+		scanner.walk(batch)
+		close(batch)
+	}()
+	directive := ""
+	for file := range batch {
+		if directive != "" || !file.IsGoFile {
+			continue
+		}
+		if raw, err := os.ReadFile(file.Path); err == nil && bytes.Contains(raw, []byte("//go:generate gunit")) {
+			directive = file.Path
+		}
+	}
+	if directive == "" {
+		checkup.Detail = "not used (no //go:generate gunit directives)."
+		return checkup
+	}
+	if _, err := exec.LookPath("gunit"); err != nil {
+		checkup.Level = checkupFailed
+		checkup.Detail = fmt.Sprintf("%s has a //go:generate gunit directive, but the gunit command wasn't found on the PATH.", relativePath(directive))
+		checkup.Fix = "go get github.com/smartystreets/gunit/gunit (and add $GOPATH/bin to the PATH)."
+		return checkup
+	}
+	checkup.Detail = "the gunit command is available."
+	return checkup
+}
+
+func checkWebsocketd() Checkup {
+	checkup := Checkup{Name: "websocketd"}
+	if _, err := exec.LookPath("websocketd"); err != nil {
+		checkup.Level = checkupFailed
+		checkup.Detail = "scantest-web needs websocketd, which wasn't found on the PATH."
+		checkup.Fix = "go get github.com/joewalnes/websocketd (and add $GOPATH/bin to the PATH)."
+		return checkup
+	}
+	checkup.Detail = "websocketd is available."
+	return checkup
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func goEnv(environment []string, name string) string {
+	command := exec.Command("go", "env", name)
+	command.Env = environment
+	output, err := command.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func probeWritable(folder string) error {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(folder, "doctor-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// embedDirectives are the patterns of the //go:embed directives of a go file, as of
+// the checksum of the file (so that it's only read again when it changes).
+type embedDirectives struct {
+	checksum int64
+	patterns []string
+}
+
+// embedPatterns gathers the //go:embed patterns of the go files, by folder, reading
+// only the files that changed since the last call.
+func (self *Checksummer) embedPatterns(files []*File) map[string][]string {
+	embeds := map[string]embedDirectives{}
+	folders := map[string][]string{}
+	for _, file := range files {
+		if !file.IsGoFile {
+			continue // (see isHint)
+		}
+		directives, found := self.embeds[file.Path]
+		if !found || directives.checksum != file.Checksum {
+			directives = embedDirectives{checksum: file.Checksum, patterns: readEmbedPatterns(file.Path)}
+		}
+		embeds[file.Path] = directives
+		if len(directives.patterns) > 0 {
+			folders[file.ParentFolder] = append(folders[file.ParentFolder], directives.patterns...)
+		}
+	}
+	self.embeds = embeds
+	return folders
+}
+
+// readEmbedPatterns parses the //go:embed directives of a go file (its build
+// constraints aside).
+func readEmbedPatterns(path string) (patterns []string) {
+	raw, err := os.ReadFile(path)
+	if err != nil || !bytes.Contains(raw, []byte("//go:embed")) {
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "//go:embed ") && !strings.HasPrefix(line, "//go:embed\t") {
+			continue
+		}
+		patterns = append(patterns, splitEmbedPatterns(strings.TrimSpace(line[len("//go:embed"):]))...)
+	}
+	return patterns
+}
+
+// splitEmbedPatterns splits the arguments of a directive, which may be quoted (with
+// double quotes or backquotes) for patterns with spaces.
+func splitEmbedPatterns(arguments string) (patterns []string) {
+	for arguments != "" {
+		end := strings.IndexAny(arguments, " \t")
+		if arguments[0] == '"' || arguments[0] == '`' {
+			end = strings.IndexByte(arguments[1:], arguments[0]) + 2
+			if end == 1 {
+				return patterns // (unterminated)
+			}
+			if unquoted, err := strconv.Unquote(arguments[:end]); err == nil {
+				patterns = append(patterns, unquoted)
+			}
+		} else if end < 0 {
+			end = len(arguments)
+			patterns = append(patterns, arguments)
+		} else {
+			patterns = append(patterns, arguments[:end])
+		}
+		arguments = strings.TrimSpace(arguments[end:])
+	}
+	return patterns
+}
+
+// embeddingFolder is the folder of the package that embeds the file (by way of the
+// patterns of the folders at or above it, up to the root), or "".
+func embeddingFolder(root string, folders map[string][]string, file string) string {
+	for folder := filepath.Dir(file); ; folder = filepath.Dir(folder) {
+		if relative, err := filepath.Rel(folder, file); err == nil {
+			for _, pattern := range folders[folder] {
+				if embeds(pattern, filepath.ToSlash(relative)) {
+					return folder
+				}
+			}
+		}
+		if folder == root || filepath.Dir(folder) == folder {
+			return ""
+		}
+	}
+}
+
+// embeds reports whether the pattern embeds the file (a slash-separated path, relative
+// to the package): those it matches, and those under the folders it matches, but for
+// the ones whose names start with . or _ (unless the pattern starts with all:).
+func embeds(pattern, relative string) bool {
+	all := strings.HasPrefix(pattern, "all:")
+	pattern = strings.TrimPrefix(pattern, "all:")
+	parts := strings.Split(relative, "/")
+	for x := len(parts); x > 0; x-- {
+		if matched, _ := path.Match(pattern, strings.Join(parts[:x], "/")); !matched {
+			continue
+		}
+		for _, part := range parts[x:] {
+			if !all && (strings.HasPrefix(part, ".") || strings.HasPrefix(part, "_")) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
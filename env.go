@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Environ is the environment of every command scantest runs (go test, go generate,
+// validators, plugins, etc...): scantest's own environment with the variables of
+// the [env] table of .scantest.toml applied on top, so that runs resolve modules
+// the same way a manual `go test` would in a given setup:
+//
+//	[env]
+//	GOFLAGS = "-mod=vendor"
+//	GOPRIVATE = "example.com/*"
+//	GOPROXY = "https://proxy.example.com,direct"
+func (self Settings) Environ() []string {
+	return mergeEnvironment(os.Environ(), self.Env)
+}
+
+func mergeEnvironment(base []string, overrides map[string]string) []string {
+	if len(overrides) == 0 {
+		return base
+	}
+	merged := []string{}
+	for _, variable := range base {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if _, found := lookupVariable(overrides, name); !found {
+			merged = append(merged, variable)
+		}
+	}
+	names := []string{}
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		merged = append(merged, name+"="+overrides[name])
+	}
+	return merged
+}
+
+// withoutVariable is the environment without the variable (ie. a secret that the
+// commands run with it have no business reading).
+func withoutVariable(environment []string, name string) []string {
+	kept := []string{}
+	for _, variable := range environment {
+		candidate := strings.SplitN(variable, "=", 2)[0]
+		if candidate != name && !(runtime.GOOS == "windows" && strings.EqualFold(candidate, name)) {
+			kept = append(kept, variable)
+		}
+	}
+	return kept
+}
+
+func lookupVariable(variables map[string]string, name string) (string, bool) {
+	for candidate, value := range variables {
+		if candidate == name || runtime.GOOS == "windows" && strings.EqualFold(candidate, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) env(path string, value interface{}) map[string]string {
+	variables := map[string]string{}
+	for name, value := range self.table(path, value) {
+		if name == "" || strings.ContainsAny(name, "= \t") {
+			self.fail(path+"."+name, "isn't a valid environment variable name.")
+			continue
+		}
+		variables[name] = self.string(path+"."+name, value)
+	}
+	return variables
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// FileEvents are synthetic "modified" events: files said to have changed (by an editor
+// plugin whose atomic saves keep the size and modification time, by a test of the
+// pipeline, etc...), which the Checksummer takes as modified on the next scan (started
+// straight away), whatever their checksums. A nil FileEvents injects nothing.
+type FileEvents struct {
+	root string
+	idle *Idle
+
+	mutex    sync.Mutex
+	modified map[string]bool
+}
+
+func NewFileEvents(root string, idle *Idle) *FileEvents {
+	return &FileEvents{root: root, idle: idle, modified: map[string]bool{}}
+}
+
+// Modified injects an event for each of the files (absolute, or relative to the
+// working directory), or none, should one of them not be a file under it.
+func (self *FileEvents) Modified(paths ...string) error {
+	if self == nil {
+		return nil
+	}
+	resolved := []string{}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(self.root, path)
+		}
+		path = diskPath(filepath.Clean(path))
+		if relative, err := filepath.Rel(self.root, path); err != nil || !isWithin(relative) || !isFile(path) {
+			return fmt.Errorf("Not a file under %s: %s", self.root, path)
+		}
+		resolved = append(resolved, path)
+	}
+	if len(resolved) == 0 {
+		return nil
+	}
+	self.mutex.Lock()
+	for _, path := range resolved {
+		self.modified[path] = true
+	}
+	self.mutex.Unlock()
+	self.idle.Wake()
+	return nil
+}
+
+// take returns (and forgets) the files injected since the last call.
+func (self *FileEvents) take() map[string]bool {
+	if self == nil {
+		return nil
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	modified := self.modified
+	self.modified = map[string]bool{}
+	return modified
+}
+
+// requeue injects the files again (those taken by a pass the Checksummer let go).
+func (self *FileEvents) requeue(paths map[string]bool) {
+	if self == nil || len(paths) == 0 {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for path := range paths {
+		self.modified[path] = true
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// serveModified answers POST /modified?path=<file>&path=... by injecting an event for
+// each of the files (see FileEvents).
+func (self *Hub) serveModified(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(response, "POST the files as ?path=<file>&path=...", http.StatusMethodNotAllowed)
+		return
+	}
+	paths := request.URL.Query()["path"]
+	if len(paths) == 0 {
+		http.Error(response, "no path given", http.StatusBadRequest)
+		return
+	}
+	if err := self.injector.Modified(paths...); err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
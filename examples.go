@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// describeFailure is the failure as listed with the results. A failed Example
+// function gets its expected output (the // Output: comment) and the output it
+// actually printed in separate, labeled blocks.
+func describeFailure(failure parser.Failure) string {
+	if !failure.Example {
+		return failure.Output
+	}
+	header, logged := "", ""
+	for _, line := range strings.Split(failure.Output, "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed == "got:" {
+			break
+		} else if strings.HasPrefix(trimmed, "--- FAIL") {
+			header = line + "\n"
+		} else {
+			logged += line + "\n" // (printed to stderr, or by the example before it failed)
+		}
+	}
+	return header + logged +
+		"    want (// Output:):\n" + indentLines(failure.Want, "        ") + "\n" +
+		"    got:\n" + indentLines(failure.Got, "        ") + "\n"
+}
+
+func indentLines(text, indent string) string {
+	if text == "" {
+		return indent + "(nothing)"
+	}
+	lines := strings.Split(text, "\n")
+	for x, line := range lines {
+		lines[x] = indent + line
+	}
+	return strings.Join(lines, "\n")
+}
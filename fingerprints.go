@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// The parts of a failure message that vary from one run to the next without the
+// failure being any different.
+var volatile = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`\(\d+(\.\d+)?m?s\)`), "(?)"},                       // durations of the --- FAIL lines
+	{regexp.MustCompile(`\d+(\.\d+)?(ns|µs|us|ms|s|m|h)\b`), "?"},           // other durations
+	{regexp.MustCompile(`0x[0-9a-fA-F]+`), "0x?"},                           // addresses
+	{regexp.MustCompile(`goroutine \d+`), "goroutine ?"},                    // goroutine ids
+	{regexp.MustCompile(`\d{4}[-/]\d\d[-/]\d\d[ T]\d\d:\d\d:\d\d\S*`), "?"}, // timestamps
+	{regexp.MustCompile(`/tmp/\S+`), "/tmp/?"},                              // temporary files
+}
+
+// fingerprint identifies a failure by the test and its (normalized) message.
+func fingerprint(test, output string) string {
+	for _, item := range volatile {
+		output = item.pattern.ReplaceAllString(output, item.replacement)
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(test+"\x00"+strings.TrimSpace(output))))[:12]
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Fingerprints compares the failures of each package with those of the package's
+// previous run (in this session), labeling them as new (a test that didn't fail before, or fails
+// differently now) or still failing (the very same failure), and listing the tests
+// fixed since. A nil Fingerprints labels nothing.
+type Fingerprints struct {
+	mutex    sync.Mutex
+	previous map[string]map[string]string // key: package, value: (key: fingerprint, value: test)
+	streaks  map[string]int               // key: package, value: consecutive runs that failed the very same way
+}
+
+func NewFingerprints() *Fingerprints {
+	return &Fingerprints{previous: map[string]map[string]string{}, streaks: map[string]int{}}
+}
+
+// Streak is the number of consecutive runs in which the package failed the very
+// same way (the same tests, with the same messages).
+func (self *Fingerprints) Streak(packageName string) int {
+	if self == nil {
+		return 0
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.streaks[packageName]
+}
+
+func (self *Fingerprints) Compare(results []Result) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for x := range results {
+		result := &results[x]
+		previous, seen := self.previous[result.PackageName]
+		current := map[string]string{}
+		if result.Status < TestsPassed {
+			for _, failure := range parser.Parse(result.Output) {
+				current[fingerprint(failure.Test, failure.Output)] = failure.Test
+			}
+			if len(current) == 0 { // (ie. it didn't build)
+				current[fingerprint("", result.Output)] = ""
+			}
+		}
+		for key, test := range current {
+			if _, found := previous[key]; found {
+				result.StillFailing = append(result.StillFailing, test)
+			} else if seen { // (the first run of a package has nothing to compare with)
+				result.NewFailures = append(result.NewFailures, test)
+			}
+		}
+
+		passed := map[string]bool{"": result.Status >= TestsFailed} // (a blank test means the package didn't build)
+		for _, test := range parser.Tests(result.Output) {
+			passed[test.Name] = test.Status == "PASS"
+		}
+		for _, test := range previous {
+			if passed[test] && test == "" {
+				result.Fixed = append(result.Fixed, "(build)")
+			} else if passed[test] {
+				result.Fixed = append(result.Fixed, test)
+			}
+		}
+		sort.Strings(result.NewFailures)
+		sort.Strings(result.StillFailing)
+		sort.Strings(result.Fixed)
+
+		if len(current) == 0 && len(result.Narrowed) == 0 {
+			delete(self.streaks, result.PackageName)
+		} else if len(result.Narrowed) == 0 && len(result.NewFailures) == 0 && len(result.StillFailing) == len(previous) {
+			self.streaks[result.PackageName]++
+		} else if len(result.Narrowed) == 0 {
+			self.streaks[result.PackageName] = 1
+		}
+
+		if len(result.Narrowed) > 0 { // the tests that didn't run are still failing (or not) as before
+			for key, test := range previous {
+				if _, ran := passed[test]; !ran && test != "" {
+					current[key] = test
+				}
+			}
+		}
+		self.previous[result.PackageName] = current
+	}
+}
+
+// describeTrend is appended to the name of a package in the console.
+func describeTrend(result Result) string {
+	described := []string{}
+	if len(result.NewFailures) > 0 {
+		described = append(described, fmt.Sprintf("%d new", len(result.NewFailures)))
+	}
+	if len(result.StillFailing) > 0 {
+		described = append(described, fmt.Sprintf("%d still failing", len(result.StillFailing)))
+	}
+	if len(result.Fixed) > 0 {
+		described = append(described, fmt.Sprintf("fixed: %s", strings.Join(result.Fixed, ", ")))
+	}
+	if len(described) == 0 {
+		return ""
+	}
+	return " {" + strings.Join(described, "; ") + "}"
+}
+
+// summarizeTrends counts the new, still failing and fixed failures of a run.
+func summarizeTrends(results []Result) (summary string) {
+	added, still, fixed := 0, 0, 0
+	for _, result := range results {
+		added += len(result.NewFailures)
+		still += len(result.StillFailing)
+		fixed += len(result.Fixed)
+	}
+	if added+still+fixed == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Failures: %d new, %d still failing, %d fixed", added, still, fixed)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Console output formats (see -format). The compact ones print a line per package
+// (with a character per test, for dots) and then the failures in full. The emacs one
+// prints the failures with GNU-style locations (for compilation-mode and flycheck),
+// without colors or other escape codes (as with -plain).
+const (
+	formatStandard = "standard"
+	formatDots     = "dots"
+	formatPkgname  = "pkgname"
+	formatEmacs    = "emacs"
+)
+
+var formats = []string{formatStandard, formatDots, formatPkgname, formatEmacs}
+
+func validFormat(format string) bool {
+	for _, known := range formats {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
+
+var ( // (blank with -plain, see plainOutput)
+	red    = "\033[31m"
+	green  = "\033[32m"
+	yellow = "\033[33m"
+	reset  = "\033[0m"
+)
+
+func (self *Printer) compact(writer io.Writer, report *Report) (failed bool) {
+	for x := len(report.Results) - 1; x >= 0; x-- {
+		result := report.Results[x]
+		color, mark := green, "✓"
+		if result.Status < TestsPassed {
+			color, mark, failed = red, "✖", true
+		}
+		if self.plain {
+			mark = describeVerdict(result)
+		}
+
+		if self.format == formatDots {
+			dots := new(strings.Builder)
+			for _, test := range parser.Tests(result.Output) {
+				switch test.Status {
+				case "PASS":
+					dots.WriteString(".")
+				case "FAIL":
+					dots.WriteString("✖")
+				case "SKIP":
+					dots.WriteString("↷")
+				}
+			}
+			if self.plain {
+				fmt.Fprint(writer, mark+" ")
+			}
+			fmt.Fprintf(writer, "%s%s %s%s\n", color, result.PackageName, dots, reset)
+		} else {
+			fmt.Fprintf(writer, "%s%s  %s (%s)%s\n", color, mark, result.PackageName, describeStatus(result), reset)
+		}
+	}
+
+	for x := len(report.Results) - 1; x >= 0; x-- {
+		result := report.Results[x]
+		if result.Status == TestsPassed {
+			continue
+		}
+		fmt.Fprintf(writer, "\n%s=== %s %s%s%s%s\n", red, statusLabels[result.Status], result.PackageName, describeTrend(result), describeBaseline(result), reset)
+		if len(result.Failures) > 0 && result.Status == TestsFailed {
+			for _, failure := range result.Failures {
+				fmt.Fprint(writer, highlight(self.links.Link(failure, result.PackageName), "", self.fold))
+			}
+		} else {
+			fmt.Fprintln(writer, highlight(self.links.Link(result.Output, result.PackageName), "", self.fold))
+		}
+		printHints(writer, result, "")
+	}
+	fmt.Fprintln(writer)
+	return failed
+}
+
+// emacs prints a line per package and the output of the failed ones, where each line
+// that starts with a location is rewritten in GNU error format (file:line:column:
+// message), with the file relative to the working directory.
+func (self *Printer) emacs(writer io.Writer, report *Report) (failed bool) {
+	for x := len(report.Results) - 1; x >= 0; x-- {
+		result := report.Results[x]
+		if result.Status == TestsPassed {
+			fmt.Fprintf(writer, "ok %s (%s)\n", result.PackageName, describeStatus(result))
+			continue
+		}
+		failed = true
+		fmt.Fprintf(writer, "=== %s %s%s%s\n", statusLabels[result.Status], result.PackageName, describeTrend(result), describeBaseline(result))
+		for _, line := range strings.Split(strings.TrimRight(result.Output, "\n"), "\n") {
+			trimmed := strings.TrimSpace(line)
+			if location := fileReference.FindStringIndex(trimmed); location != nil && location[0] == 0 {
+				line = rewriteReferences(trimmed[:location[1]], result.PackageName, func(reference, path, number, column string) string {
+					if column == "" {
+						return relativePath(path) + ":" + number
+					}
+					return relativePath(path) + ":" + number + ":" + column
+				}) + trimmed[location[1]:]
+			}
+			fmt.Fprintln(writer, line)
+		}
+		for _, hint := range result.Hints {
+			fmt.Fprintln(writer, "Hint: "+hint)
+		}
+	}
+	return failed
+}
+
+// describeTiming is appended to the name of a package in the console.
+func describeTiming(result Result) string {
+	return fmt.Sprintf(" (%s%s, finished at %s)", result.Duration.Round(time.Millisecond*10), describeUsage(result), result.Finished.Format("15:04:05"))
+}
+
+func describeStatus(result Result) string {
+	if result.Status < TestsFailed {
+		return strings.ToLower(statusLabels[result.Status])
+	}
+	return result.Duration.Round(time.Millisecond * 10).String()
+}
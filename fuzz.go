@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// fuzzPackage runs each fuzz target in the package folder (one at a time, as
+// `go test -fuzz` insists) for the configured duration, recording any crasher
+// as a failure. It reports false if ctx was cancelled in the meantime.
+func (self *Runner) fuzzPackage(ctx context.Context, folder string, result *Result) bool {
+	for _, target := range findFuzzTargets(folder) {
+		settings := self.config.Settings()
+		command := settings.Background.apply(newCommand(ctx, "go", "test", "-run=^$", "-fuzz=^"+target+"$", "-fuzztime="+self.fuzz.String(), "."))
+		command.Dir = folder
+		command.Env = settings.Environ()
+		output, err := command.CombinedOutput()
+		if ctx.Err() != nil {
+			return false
+		}
+		result.Output += "\n" + string(output)
+		if err == nil {
+			continue
+		}
+		result.Status = TestsFailed
+		result.Failures = append(result.Failures, string(output))
+		for _, line := range strings.Split(string(output), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "Failing input written to ") {
+				crasher := strings.TrimPrefix(line, "Failing input written to ")
+				result.Crashers = append(result.Crashers, filepath.Join(folder, crasher))
+			}
+		}
+	}
+	return true
+}
+
+// findFuzzTargets lists the FuzzXxx(*testing.F) functions declared in the _test.go files of the folder.
+func findFuzzTargets(folder string) (targets []string) {
+	if folder == "" {
+		return nil
+	}
+	fileset := token.NewFileSet()
+	packages, err := parser.ParseDir(fileset, folder, func(info os.FileInfo) bool {
+		return strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil
+	}
+	for _, pkg := range packages {
+		for _, file := range pkg.Files {
+			for _, declaration := range file.Decls {
+				function, ok := declaration.(*ast.FuncDecl)
+				if !ok || function.Recv != nil || !strings.HasPrefix(function.Name.Name, "Fuzz") {
+					continue
+				}
+				if params := function.Type.Params.List; len(params) == 1 && isTestingF(params[0].Type) {
+					targets = append(targets, function.Name.Name)
+				}
+			}
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+func isTestingF(expression ast.Expr) bool {
+	star, ok := expression.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	selector, ok := star.X.(*ast.SelectorExpr)
+	return ok && selector.Sel.Name == "F"
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// snapshotFolder checksums the files of a folder (not its subfolders) the way the
+// Checksummer does (size + modification time).
+func snapshotFolder(folder string) map[string]int64 {
+	snapshot := map[string]int64{}
+	entries, _ := os.ReadDir(folder)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			snapshot[filepath.Join(folder, entry.Name())] = info.Size() + info.ModTime().Unix()
+		}
+	}
+	return snapshot
+}
+
+// changedFiles lists the files that appeared or changed between the snapshots.
+func changedFiles(before, after map[string]int64) (changed []string) {
+	for path, checksum := range after {
+		if previous, found := before[path]; !found || previous != checksum {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// GeneratedFiles remembers the files just written by go generate (by the test step,
+// or for mocks), so that the scan that notices them doesn't trigger yet another run
+// (which would generate them again, and so on...). It also remembers the output of
+// go generate in each folder until the files it depends on change (see generateKey),
+// and keeps too many generators from running at once. A nil GeneratedFiles remembers
+// nothing (and limits nothing).
+type GeneratedFiles struct {
+	mutex    sync.Mutex
+	files    map[string]int64          // key: path, value: checksum (size + modification time)
+	outputs  map[string]generateOutput // key: folder
+	fixtures map[string]Fixtures       // key: folder, value: as gunit last generated them (see Stale)
+	slots    chan struct{}
+}
+
+type generateOutput struct {
+	key    string
+	output string
+}
+
+func NewGeneratedFiles() *GeneratedFiles {
+	concurrency := runtime.NumCPU() / 2
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &GeneratedFiles{files: map[string]int64{}, outputs: map[string]generateOutput{}, fixtures: map[string]Fixtures{}, slots: make(chan struct{}, concurrency)}
+}
+
+// Generate runs generate (once there's room), returning the files it changed in the
+// folder (which are remembered until the scan gets to them).
+func (self *GeneratedFiles) Generate(folder string, generate func() error) ([]string, error) {
+	if self != nil {
+		self.slots <- struct{}{}
+		defer func() { <-self.slots }()
+	}
+	before := snapshotFolder(folder)
+	err := generate()
+	after := snapshotFolder(folder)
+	changed := changedFiles(before, after)
+	if self != nil {
+		self.mutex.Lock()
+		defer self.mutex.Unlock()
+		for _, path := range changed {
+			self.files[path] = after[path]
+		}
+	}
+	return changed, err
+}
+
+// Consume reports whether the (modified) file is just as go generate left it.
+func (self *GeneratedFiles) Consume(path string, checksum int64) bool {
+	if self == nil {
+		return false
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	generated, found := self.files[path]
+	delete(self.files, path)
+	return found && generated == checksum
+}
+
+// Cached returns the output of the last go generate in the folder, unless the files
+// it depends on changed since.
+func (self *GeneratedFiles) Cached(folder string) (output string, cached bool) {
+	if self == nil {
+		return "", false
+	}
+	key := generateKey(folder)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	previous, found := self.outputs[folder]
+	return previous.output, found && previous.key == key
+}
+
+// Remember keeps the output of a successful go generate in the folder.
+func (self *GeneratedFiles) Remember(folder, output string) {
+	if self == nil {
+		return
+	}
+	self.expectFixtures(folder, output)
+	key := generateKey(folder)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.outputs[folder] = generateOutput{key: key, output: output}
+}
+
+// generateKey fingerprints what go generate depends on in a folder: the contents of
+// the files with go:generate directives, and the names of all the files (so that
+// deleting a generated file gets it generated again).
+func generateKey(folder string) string {
+	hash := sha256.New()
+	entries, _ := os.ReadDir(folder)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fmt.Fprintln(hash, entry.Name())
+		if !strings.HasSuffix(entry.Name(), ".go") {
+			continue
+		}
+		if raw, err := os.ReadFile(filepath.Join(folder, entry.Name())); err == nil && bytes.Contains(raw, []byte("//go:generate")) {
+			hash.Write(raw)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
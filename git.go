@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// GitState identifies the code that was tested: the branch, HEAD and the number of
+// files with uncommitted changes (or untracked, besides .scantest) under the working
+// directory at the start of the run.
+type GitState struct {
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+	Dirty  int    `json:"dirty"`
+}
+
+// currentGitState returns nil outside of a git repository (or without git).
+func currentGitState() *GitState {
+	commit, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return nil
+	}
+	state := &GitState{Commit: strings.TrimSpace(string(commit))}
+	if branch, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output(); err == nil {
+		state.Branch = strings.TrimSpace(string(branch)) // "HEAD" when detached
+	}
+	if status, err := exec.Command("git", "status", "--porcelain", "--", ".", ":!"+stateFolder).Output(); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if strings.TrimSpace(line) != "" {
+				state.Dirty++
+			}
+		}
+	}
+	return state
+}
+
+func (self *GitState) String() string {
+	if self == nil {
+		return ""
+	}
+	described := self.Branch + "@" + shortCommit(self.Commit)
+	if self.Dirty > 0 {
+		described += fmt.Sprintf(" (%d dirty)", self.Dirty)
+	}
+	return described
+}
+
+// changedSince lists the files under the root (absolute paths) that differ from the
+// point where the current branch forked from ref (committed or not), along with
+// untracked files. The folders of deleted files are listed instead of the files.
+func changedSince(root, ref string) (map[string]bool, error) {
+	if _, err := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}").Output(); err != nil {
+		return nil, fmt.Errorf("-since: '%s' isn't a known git ref (or this isn't a git repository).", ref)
+	}
+	base := ref
+	if merged, err := exec.Command("git", "merge-base", ref, "HEAD").Output(); err == nil {
+		base = strings.TrimSpace(string(merged))
+	}
+	changed, err := exec.Command("git", "diff", "--name-only", "--relative", "-z", base, "--").Output()
+	if err != nil {
+		return nil, fmt.Errorf("-since: git diff failed: %s", err)
+	}
+	untracked, err := exec.Command("git", "ls-files", "--others", "--exclude-standard", "-z", "--", ".").Output()
+	if err != nil {
+		return nil, fmt.Errorf("-since: git ls-files failed: %s", err)
+	}
+
+	files := map[string]bool{}
+	for _, name := range strings.Split(string(changed)+string(untracked), "\x00") {
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			path = filepath.Dir(path)
+		}
+		files[diskPath(path)] = true
+	}
+	return files, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// GitIgnore matches paths against the .gitignore files found along the way (and
+// .git/info/exclude at the root), following git's rules: patterns apply relative to
+// the folder of their file, deeper files take precedence and the last match wins.
+// Additional patterns (from the config) apply as if listed in a root .gitignore.
+type GitIgnore struct {
+	root  string
+	files bool
+	rules map[string][]ignoreRule // key: folder
+}
+
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func NewGitIgnore(root string, files bool, patterns []string) *GitIgnore {
+	self := &GitIgnore{root: root, files: files, rules: map[string][]ignoreRule{}}
+	if files {
+		self.rules[root] = parseIgnoreFile(filepath.Join(root, ".git", "info", "exclude"))
+	}
+	self.rules[root] = append(self.rules[root], parseIgnoreLines(patterns)...)
+	return self
+}
+
+// Enter loads the .gitignore of a folder. Folders must be entered before their contents are matched.
+func (self *GitIgnore) Enter(folder string) {
+	if self.files {
+		self.rules[folder] = append(self.rules[folder], parseIgnoreFile(filepath.Join(folder, ".gitignore"))...)
+	}
+}
+
+func (self *GitIgnore) Ignored(path string, isDir bool) bool {
+	ignored := false
+	folders := []string{}
+	for folder := filepath.Dir(path); strings.HasPrefix(folder, self.root); folder = filepath.Dir(folder) {
+		folders = append(folders, folder)
+		if folder == self.root || filepath.Dir(folder) == folder {
+			break
+		}
+	}
+	for x := len(folders) - 1; x >= 0; x-- {
+		relative, err := filepath.Rel(folders[x], path)
+		if err != nil {
+			continue
+		}
+		relative = filepath.ToSlash(relative)
+		for _, rule := range self.rules[folders[x]] {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.pattern.MatchString(relative) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func parseIgnoreFile(path string) []ignoreRule {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return parseIgnoreLines(strings.Split(string(raw), "\n"))
+}
+
+func parseIgnoreLines(lines []string) (rules []ignoreRule) {
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		var err error
+		if rule.pattern, err = regexp.Compile(globToRegexp(line, anchored)); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// globToRegexp translates a gitignore glob. Patterns without a slash match a
+// name at any depth; the rest are matched from the folder of the .gitignore.
+func globToRegexp(glob string, anchored bool) string {
+	expression := new(strings.Builder)
+	if anchored {
+		expression.WriteString("^")
+	} else {
+		expression.WriteString("(^|/)")
+	}
+	for x := 0; x < len(glob); x++ {
+		switch c := glob[x]; c {
+		case '*':
+			if strings.HasPrefix(glob[x:], "**/") {
+				expression.WriteString("(.*/)?")
+				x += 2
+			} else if strings.HasPrefix(glob[x:], "**") {
+				expression.WriteString(".*")
+				x++
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[x:], ']')
+			if end < 0 {
+				expression.WriteString(`\[`)
+				continue
+			}
+			class := glob[x+1 : x+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expression.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			x += end
+		case '\\':
+			if x+1 < len(glob) {
+				x++
+				expression.WriteString(regexp.QuoteMeta(string(glob[x])))
+			}
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expression.WriteString("$")
+	return expression.String()
+}
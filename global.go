@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// globalConfigPath is the personal config, whose settings (any of .scantest.toml: ie.
+// parallel, [keys], [commands], [background]) apply to every project:
+// $XDG_CONFIG_HOME/scantest/config.toml, or else ~/.config/scantest/config.toml.
+func globalConfigPath() string {
+	folder := os.Getenv("XDG_CONFIG_HOME")
+	if folder == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		folder = filepath.Join(home, ".config")
+	}
+	return filepath.Join(folder, "scantest", "config.toml")
+}
+
+// loadLayeredConfig loads the layers (the policy, if any, and the global config) with
+// the .scantest.toml of the project on top: each overrides the ones beneath, key by
+// key (within tables too, so a project may rebind a key and keep the other personal
+// bindings). Problems are reported with the file they're in.
+func loadLayeredConfig(layers []string, project string) (*Config, error) {
+	document, positions := map[string]interface{}{}, map[string]Position{}
+	for _, layer := range layers {
+		if layer == "" {
+			continue
+		}
+		layerDocument, layerPositions, err := readConfigDocument(layer)
+		if err == nil {
+			_, err = decodeConfig(layerDocument, layerPositions)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s", layer, err)
+		}
+		document = mergeDocuments(document, layerDocument)
+		for path, position := range layerPositions {
+			positions[path] = position
+		}
+	}
+
+	projectDocument, projectPositions, err := readConfigDocument(project)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", configFilename, err)
+	}
+	document = mergeDocuments(document, projectDocument)
+	for path, position := range projectPositions {
+		positions[path] = position
+	}
+	config, err := decodeConfig(document, positions)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", configFilename, err)
+	}
+	return config, nil
+}
+
+// mergeDocuments overlays the top document on the base one (tables are merged, other
+// values replaced).
+func mergeDocuments(base, top map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range top {
+		table, isTable := value.(map[string]interface{})
+		baseTable, baseIsTable := merged[key].(map[string]interface{})
+		if isTable && baseIsTable {
+			merged[key] = mergeDocuments(baseTable, table)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// moduleProblem matches the output of go commands that fail for want of a download or
+// a requirement (ie. right after importing a new dependency), not for the code.
+var moduleProblem = regexp.MustCompile(`missing go\.sum entry|no required module provides package|updates to go\.(mod|sum) needed|is replaced but not required|cannot find module providing package`)
+
+// ModuleFixer runs `go mod download` (or `go mod tidy`, which edits go.mod and go.sum)
+// in the module of a package that failed to build for want of a module, so that it
+// is tested again straight away, rather than failing the same way every run. It runs
+// once for each state of go.mod and go.sum (so a download that can't help isn't
+// repeated every run). A nil ModuleFixer (without -mod-fix) only says what would help.
+type ModuleFixer struct {
+	mode string // download or tidy
+
+	mutex    sync.Mutex
+	attempts map[string]*moduleAttempt // key: module folder
+}
+
+type moduleAttempt struct {
+	signature string          // of go.mod and go.sum, once fixed
+	failed    bool            // or changed nothing
+	retried   map[string]bool // key: package
+}
+
+func NewModuleFixer(mode string) (*ModuleFixer, error) {
+	switch mode {
+	case "off":
+		return nil, nil
+	case "download", "tidy":
+		return &ModuleFixer{mode: mode, attempts: map[string]*moduleAttempt{}}, nil
+	default:
+		return nil, fmt.Errorf("Unknown -mod-fix mode '%s' (expected off, download or tidy).", mode)
+	}
+}
+
+// Fix runs the go mod command in the module of the package, if the output is that of
+// a missing module. It reports whether the package is worth testing again (once for
+// each fix, which may well have been run for another package of the module), along
+// with a note on what was done (or would help) for the output.
+func (self *ModuleFixer) Fix(ctx context.Context, packageName, folder, output string, settings Settings) (note string, retry bool) {
+	if !moduleProblem.MatchString(output) {
+		return "", false
+	}
+	root := findModuleRoot(folder)
+	if root == "" {
+		return "", false
+	}
+	if self == nil {
+		return "(a module is missing: `go mod tidy` should help, or start scantest with -mod-fix=tidy to have it done)\n", false
+	}
+
+	args := []string{"mod", self.mode}
+	if self.mode == "download" {
+		args = append(args, "all") // (so that go.sum gets the entries of every imported package)
+	}
+	self.mutex.Lock() // (the packages of a module tend to fail together)
+	defer self.mutex.Unlock()
+	before := moduleSignature(root)
+	attempt, found := self.attempts[root]
+	if !found || attempt.signature != before {
+		command := settings.Background.apply(newCommand(ctx, "go", args...))
+		command.Dir = root
+		command.Env = settings.Environ()
+		commandOutput, err := command.CombinedOutput()
+		if ctx.Err() != nil {
+			return "", false
+		}
+		logf("Modules: ran `go %s` in %s (%s).", strings.Join(args, " "), relativePath(root), describeOutcome(err))
+		attempt = &moduleAttempt{signature: moduleSignature(root), retried: map[string]bool{}}
+		attempt.failed = err != nil || attempt.signature == before // (nothing changed, so nothing's fixed)
+		self.attempts[root] = attempt
+		if err != nil {
+			return fmt.Sprintf("(a module is missing, and `go %s` failed: %s)\n%s\n", strings.Join(args, " "), err, strings.TrimSpace(string(commandOutput))), false
+		}
+	}
+	if attempt.failed || attempt.retried[packageName] {
+		return fmt.Sprintf("(a module is still missing after `go %s`: see `go mod tidy` or `go get`)\n", strings.Join(args, " ")), false
+	}
+	attempt.retried[packageName] = true
+	return fmt.Sprintf("(a module was missing, so `go %s` ran and the package was tested again)\n", strings.Join(args, " ")), true
+}
+
+func describeOutcome(err error) string {
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+	return "ok"
+}
+
+// findModuleRoot is the folder of the nearest go.mod at or above the folder (or "").
+func findModuleRoot(folder string) string {
+	for current := folder; current != ""; current = filepath.Dir(current) {
+		if isFile(filepath.Join(current, "go.mod")) {
+			return current
+		}
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	return ""
+}
+
+func moduleSignature(root string) string {
+	signature := ""
+	for _, name := range []string{"go.mod", "go.sum"} {
+		if info, err := os.Stat(filepath.Join(root, name)); err == nil {
+			signature += fmt.Sprintf("%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return signature
+}
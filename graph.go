@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+type GraphNode struct {
+	Imports    []string `json:"imports"`           // local packages this package imports
+	ImportedBy []string `json:"importedBy"`        // local packages re-tested when this package's code changes (by imports or [affects] rules)
+	Affects    []string `json:"affects,omitempty"` // local packages re-tested by the [affects] rules of .scantest.toml (which the imports don't show)
+}
+
+// runGraph implements `scantest graph`, which scans the working directory once and
+// prints the package dependency (and reverse-dependency) graph used to cascade runs.
+func runGraph(arguments []string) {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	format := flags.String("format", "dot", "The output format: dot or json.")
+	gitignore := flags.Bool("gitignore", true, "When true, paths matched by .gitignore files aren't scanned.")
+	moduleRoot := flags.String("module-root", "", "When set, the folder of the module to graph (as with scantest -module-root).")
+	gowork := flags.Bool("gowork", true, "When false, go.work files are ignored (as with scantest -gowork=false).")
+	flags.Parse(arguments)
+	if *format != "dot" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format '%s' (expected dot or json).\n", *format)
+		os.Exit(1)
+	}
+	if *moduleRoot != "" {
+		if err := pinModuleRoot(*moduleRoot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if !*gowork {
+		os.Setenv("GOWORK", "off")
+	}
+
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	graph := buildGraph(workingDirectory, *gitignore, *moduleRoot != "" || !*gowork)
+
+	if *format == "json" {
+		raw, _ := json.MarshalIndent(graph, "", "  ")
+		fmt.Println(string(raw))
+		return
+	}
+	names := []string{}
+	for name := range graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("digraph scantest {")
+	fmt.Println("\trankdir=LR;")
+	for _, name := range names {
+		fmt.Printf("\t%q;\n", name)
+		for _, imported := range graph[name].Imports {
+			fmt.Printf("\t%q -> %q;\n", name, imported)
+		}
+	}
+	for _, name := range names {
+		for _, target := range graph[name].Affects {
+			fmt.Printf("\t%q -> %q [style=dashed];\n", target, name) // (drawn like an import of the source)
+		}
+	}
+	fmt.Println("}")
+}
+
+// buildGraph runs a single scan through the same Packager (and import resolution)
+// as the watch loop, and adds the [affects] rules of .scantest.toml like its selector.
+func buildGraph(root string, gitignore, modular bool) map[string]*GraphNode {
+	config := NewConfigWatcher(root, Settings{})
+	scanner := &FileSystemScanner{
+		root:      root,
+		gitignore: gitignore,
+		modular:   modular,
+		config:    config,
+	}
+	files := make(chan chan *File)
+	packages := make(chan chan *Package)
+	packager := &Packager{in: files, out: packages}
+	go packager.ListenForever()
+
+	scanned := make(chan *File)
+	go func() {
+		scanner.walk(scanned)
+		close(scanned)
+	}()
+	goFiles := make(chan *File)
+	files <- goFiles
+	go func() {
+		for file := range scanned {
+			if !file.IsFolder && file.IsGoFile {
+				goFiles <- file
+			}
+		}
+		close(goFiles)
+	}()
+
+	cascade := map[string][]string{}
+	imports := map[string][]string{}
+	affects := map[string][]string{}
+	graph := map[string]*GraphNode{}
+	all := []*Package{}
+	for pkg := range <-packages {
+		all = append(all, pkg)
+		linkImports(pkg, cascade, imports, map[string]string{})
+		graph[pkg.Info.ImportPath] = &GraphNode{Imports: []string{}, ImportedBy: []string{}}
+	}
+	mergeAffects(config.Settings().Affects, root, all, affects)
+	mergeAffects(config.Settings().Affects, root, all, cascade)
+	for name, node := range graph {
+		seen := map[string]bool{}
+		for _, imported := range imports[name] {
+			if _, local := graph[imported]; local && !seen[imported] {
+				seen[imported] = true
+				node.Imports = append(node.Imports, imported)
+			}
+		}
+		for _, upstream := range cascade[name] {
+			if _, local := graph[upstream]; local {
+				node.ImportedBy = append(node.ImportedBy, upstream)
+			}
+		}
+		for _, target := range affects[name] {
+			if _, local := graph[target]; local {
+				node.Affects = append(node.Affects, target)
+			}
+		}
+		sort.Strings(node.Imports)
+		sort.Strings(node.ImportedBy)
+		sort.Strings(node.Affects)
+	}
+	return graph
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+var (
+	dim     = "\033[2m"
+	boldRed = "\033[1;31m"
+	magenta = "\033[35m"
+)
+
+// highlight colors the lines of the output of a package so that failures stand out
+// in a mostly-passing verbose dump: --- FAIL lines (bold red), panics (magenta) and
+// lines with Error: (yellow), with the lines of passing tests dimmed or, with fold,
+// replaced by a count. base is the color the rest of the output is printed in.
+func highlight(output, base string, fold bool) string {
+	highlighted := new(strings.Builder)
+	folded := 0
+	flush := func() {
+		if folded > 0 {
+			fmt.Fprintf(highlighted, "%s    (%d passed, folded)%s%s\n", dim, folded, reset, base)
+			folded = 0
+		}
+	}
+	lines := strings.Split(output, "\n")
+	for x, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		color := ""
+		switch {
+		case isPassingLine(trimmed) && fold:
+			if strings.HasPrefix(trimmed, "--- PASS") {
+				folded++
+			}
+			continue
+		case isPassingLine(trimmed), strings.HasPrefix(trimmed, "--- SKIP"):
+			color = dim
+		case strings.HasPrefix(trimmed, "--- FAIL"):
+			color = boldRed
+		case strings.HasPrefix(trimmed, "panic:"), strings.HasPrefix(trimmed, "goroutine ") && strings.HasSuffix(trimmed, ":"):
+			color = magenta
+		case strings.Contains(trimmed, "Error:"), strings.HasPrefix(trimmed, "Error Trace:"):
+			color = yellow
+		}
+		flush()
+		if color != "" {
+			line = color + line + reset + base
+		}
+		highlighted.WriteString(line)
+		if x < len(lines)-1 {
+			highlighted.WriteString("\n")
+		}
+	}
+	flush()
+	return highlighted.String()
+}
+
+// isPassingLine reports whether the (trimmed) line of `go test -v` output is about a
+// test that passed (or just started, paused or resumed).
+func isPassingLine(line string) bool {
+	for _, prefix := range []string{"=== RUN", "=== PAUSE", "=== CONT", "=== NAME", "--- PASS"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// HintRule attaches a hint (what to do about it, or the URL of a runbook) to the
+// failures whose output matches a regular expression (the [hints] table of
+// .scantest.toml), so that what the team knows about a failure shows up with it:
+//
+//	[hints]
+//	':5432: connect: connection refused' = "start the dev database: make db-up"
+//	'x509: certificate'         = "https://wiki.example.com/runbooks/certificates"
+type HintRule struct {
+	Pattern string
+	Hint    string
+}
+
+// hints are those of the rules matching the output of a package that failed (in the
+// order of the patterns).
+func (self Settings) hints(result Result) (hints []string) {
+	if result.Status == TestsPassed {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, rule := range self.Hints {
+		pattern, err := regexp.Compile(rule.Pattern) // (validated by the config decoder)
+		if err != nil || seen[rule.Hint] || !pattern.MatchString(result.Output) {
+			continue
+		}
+		seen[rule.Hint] = true
+		hints = append(hints, rule.Hint)
+	}
+	return hints
+}
+
+func printHints(writer io.Writer, result Result, base string) {
+	for _, hint := range result.Hints {
+		fmt.Fprintf(writer, "%sHint: %s%s%s\n", yellow, hint, reset, base)
+	}
+}
+
+func (self *configDecoder) hints(path string, value interface{}) (rules []HintRule) {
+	for pattern, value := range self.table(path, value) {
+		hint := strings.TrimSpace(self.string(path+"."+pattern, value))
+		if _, err := regexp.Compile(pattern); err != nil {
+			self.fail(path+"."+pattern, "isn't a valid regular expression (%s).", err)
+			continue
+		}
+		if hint == "" {
+			self.fail(path+"."+pattern, "must be a hint (some text, or a URL).")
+			continue
+		}
+		rules = append(rules, HintRule{Pattern: pattern, Hint: hint})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Pattern < rules[j].Pattern })
+	return rules
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	stateFolder       = ".scantest"
+	historyFilename   = "history.jsonl"
+	historyRetention  = 500 // runs kept per package (in memory and in the file)
+	historyCompaction = 50  // results past retention that the file may hold before it's rewritten
+	historySamples    = 5   // recent durations averaged for an estimate
+)
+
+type HistoryRecord struct {
+	Time     time.Time
+	Git      *GitState `json:",omitempty"`
+	Packages []HistoryPackage
+}
+
+type HistoryPackage struct {
+	PackageName string
+	Status      PackageStatus
+	Duration    time.Duration
+	CPUTime     time.Duration `json:",omitempty"`
+	MaxRSS      int64         `json:",omitempty"`
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// History is the record of past runs, appended to .scantest/history.jsonl
+// (one JSON object per run) under the working directory, and rewritten with just
+// the latest historyRetention results of each package once it holds more.
+type History struct {
+	mutex   sync.Mutex
+	path    string
+	records []HistoryRecord
+	stale   int // results the file still has that trim let go of
+}
+
+func NewHistory(root string) *History {
+	self := &History{path: filepath.Join(root, stateFolder, historyFilename)}
+	self.load()
+	return self
+}
+
+func (self *History) load() {
+	file, err := os.Open(self.path)
+	if err != nil {
+		return // no history yet.
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var record HistoryRecord
+		if json.Unmarshal(scanner.Bytes(), &record) == nil {
+			self.records = append(self.records, record)
+		}
+	}
+	self.trim()
+}
+
+// trim keeps the latest historyRetention results of each package (and the records
+// that still have any), so that neither the history nor its file grows forever,
+// while packages that are seldom tested keep theirs.
+func (self *History) trim() {
+	counts := map[string]int{}
+	kept := 0
+	for x := len(self.records) - 1; x >= 0; x-- {
+		packages := []HistoryPackage{}
+		for _, pkg := range self.records[x].Packages {
+			if counts[pkg.PackageName] < historyRetention {
+				counts[pkg.PackageName]++
+				packages = append(packages, pkg)
+			} else {
+				self.stale++
+			}
+		}
+		if len(packages) > 0 {
+			kept++
+			self.records[len(self.records)-kept] = HistoryRecord{Time: self.records[x].Time, Git: self.records[x].Git, Packages: packages}
+		}
+	}
+	self.records = self.records[len(self.records)-kept:]
+}
+
+// save rewrites the history file with the records (the caller holds the mutex).
+func (self *History) save() {
+	buffer := new(bytes.Buffer)
+	for _, record := range self.records {
+		if raw, err := json.Marshal(record); err == nil {
+			buffer.Write(append(raw, '\n'))
+		}
+	}
+	temporary := self.path + ".tmp"
+	if err := os.WriteFile(temporary, buffer.Bytes(), 0644); err == nil && os.Rename(temporary, self.path) == nil {
+		self.stale = 0
+	}
+}
+
+// Recent are the latest records (up to count of them, oldest first).
+func (self *History) Recent(count int) []HistoryRecord {
+	if self == nil {
+		return nil
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if len(self.records) > count {
+		return append([]HistoryRecord{}, self.records[len(self.records)-count:]...)
+	}
+	return append([]HistoryRecord{}, self.records...)
+}
+
+// Record appends the results of a run to the history file. A nil History records nothing.
+func (self *History) Record(results []Result, git *GitState) {
+	if self == nil || len(results) == 0 {
+		return
+	}
+	record := HistoryRecord{Time: time.Now(), Git: git}
+	for _, result := range results {
+		if len(result.Narrowed) > 0 {
+			continue // not representative of the package's duration
+		}
+		record.Packages = append(record.Packages, HistoryPackage{
+			PackageName: result.PackageName,
+			Status:      result.Status,
+			Duration:    result.Duration,
+			CPUTime:     result.CPUTime,
+			MaxRSS:      result.MaxRSS,
+		})
+	}
+	if len(record.Packages) == 0 {
+		return
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.records = append(self.records, record)
+	self.trim()
+
+	if err := os.MkdirAll(filepath.Dir(self.path), 0755); err != nil {
+		return
+	}
+	if self.stale > historyCompaction {
+		self.save() // (rather than appending, which the file wouldn't hold back)
+		return
+	}
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	file, err := os.OpenFile(self.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	file.Write(append(raw, '\n'))
+}
+
+// Rename carries the history of a package over to the import path it was moved to
+// (rewriting the history file), so that its estimates survive the move.
+func (self *History) Rename(from, to string) {
+	if self == nil || from == to {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	renamed := false
+	for x := range self.records {
+		for y := range self.records[x].Packages {
+			if self.records[x].Packages[y].PackageName == from {
+				self.records[x].Packages[y].PackageName = to
+				renamed = true
+			}
+		}
+	}
+	if !renamed {
+		return
+	}
+	self.save()
+}
+
+// Estimate averages the most recent recorded durations of the package (zero when unknown).
+func (self *History) Estimate(packageName string) time.Duration {
+	if self == nil {
+		return 0
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var total time.Duration
+	samples := 0
+	for x := len(self.records) - 1; x >= 0 && samples < historySamples; x-- {
+		for _, pkg := range self.records[x].Packages {
+			if pkg.PackageName == packageName && pkg.Duration > 0 {
+				total += pkg.Duration
+				samples++
+			}
+		}
+	}
+	if samples == 0 {
+		return 0
+	}
+	return total / time.Duration(samples)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// schedule orders the packages longest-processing-time-first (packages without
+// history go to the front, as they could be anything) and predicts the wall time
+// of running them on the given number of workers.
+func schedule(history *History, executions map[string]bool, workers int) (queue []string, predicted time.Duration) {
+	estimates := map[string]time.Duration{}
+	for packageName := range executions {
+		queue = append(queue, packageName)
+		estimates[packageName] = history.Estimate(packageName)
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		a, b := estimates[queue[i]], estimates[queue[j]]
+		if (a == 0) != (b == 0) {
+			return a == 0
+		}
+		if a != b {
+			return a > b
+		}
+		return queue[i] < queue[j]
+	})
+
+	if workers < 1 {
+		workers = 1
+	}
+	loads := make([]time.Duration, workers)
+	for _, packageName := range queue {
+		if estimates[packageName] == 0 {
+			return queue, 0 // can't predict what we haven't seen.
+		}
+		least := 0
+		for x := range loads {
+			if loads[x] < loads[least] {
+				least = x
+			}
+		}
+		loads[least] += estimates[packageName]
+	}
+	for _, load := range loads {
+		if load > predicted {
+			predicted = load
+		}
+	}
+	return queue, predicted
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const defaultHyperlinkFormat = "file://{host}{path}"
+
+// fileReference matches file:line[:column] references: relative paths of letters
+// (in any script), digits and ._-/ and absolute paths, which may have spaces too
+// (ie. /Users/me/Google Drive/app/main.go:12, in a goroutine dump).
+var fileReference = regexp.MustCompile(`((?:[A-Za-z]:)?[/\\][^\t\n:"'()]*?\.go|[\p{L}\p{N}_./\\-]*[\p{L}\p{N}_]\.go):(\d+)(?::(\d+))?`)
+
+// Hyperlinker turns the file:line references of compile errors and failures into
+// OSC 8 terminal hyperlinks (cmd/ctrl-click opens the file in iTerm2, WezTerm,
+// Windows Terminal, kitty, GNOME Terminal, etc...). The format may use {host},
+// {path}, {line} and {column} (ie. "vscode://file{path}:{line}:{column}"). A nil
+// Hyperlinker leaves the text alone.
+type Hyperlinker struct {
+	format string
+	host   string
+}
+
+// NewHyperlinker is nil when mode is "off", or when it's "auto" and stdout isn't a
+// terminal known to support hyperlinks.
+func NewHyperlinker(mode, format string) (*Hyperlinker, error) {
+	switch mode {
+	case "on":
+	case "off":
+		return nil, nil
+	case "auto":
+		if !supportsHyperlinks() {
+			return nil, nil
+		}
+	default:
+		return nil, fmt.Errorf("Unknown -hyperlinks mode '%s' (expected auto, on or off).", mode)
+	}
+	host, _ := os.Hostname()
+	return &Hyperlinker{format: format, host: host}, nil
+}
+
+func supportsHyperlinks() bool {
+	if _, _, ok := terminalSize(os.Stdout.Fd()); !ok {
+		return false
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm", "vscode", "ghostty":
+		return true
+	}
+	version, _ := strconv.Atoi(os.Getenv("VTE_VERSION")) // GNOME Terminal, Tilix, etc...
+	return os.Getenv("WT_SESSION") != "" || os.Getenv("KITTY_WINDOW_ID") != "" || version >= 5000
+}
+
+// Link links the references in the text to existing files.
+func (self *Hyperlinker) Link(text, packageName string) string {
+	if self == nil {
+		return text
+	}
+	return rewriteReferences(text, packageName, func(reference, path, line, column string) string {
+		return "\033]8;;" + self.target(path, line, column) + "\033\\" + reference + "\033]8;;\033\\"
+	})
+}
+
+// rewriteReferences replaces the file:line[:column] references in the text to files
+// that exist (relative references are looked for in the folder of the package, then
+// in the working directory), given the absolute path of each.
+func rewriteReferences(text, packageName string, rewrite func(reference, path, line, column string) string) string {
+	folders := []string{}
+	if pkg, err := build.Default.Import(packageName, "", build.FindOnly); err == nil {
+		folders = append(folders, pkg.Dir)
+	}
+	if workingDirectory, err := os.Getwd(); err == nil {
+		folders = append(folders, workingDirectory)
+	}
+	return fileReference.ReplaceAllStringFunc(text, func(reference string) string {
+		parts := fileReference.FindStringSubmatch(reference)
+		path := parts[1]
+		if !filepath.IsAbs(path) {
+			path = ""
+			for _, folder := range folders {
+				if candidate := filepath.Join(folder, parts[1]); isFile(candidate) {
+					path = candidate
+					break
+				}
+			}
+		}
+		if path == "" || !isFile(path) {
+			if space := strings.LastIndex(parts[1], " "); space >= 0 { // (text before a reference, rather than a path with spaces)
+				return reference[:space+1] + rewriteReferences(reference[space+1:], packageName, rewrite)
+			}
+			return reference
+		}
+		return rewrite(reference, path, parts[2], parts[3])
+	})
+}
+
+func (self *Hyperlinker) target(path, line, column string) string {
+	slashed := filepath.ToSlash(path)
+	if !strings.HasPrefix(slashed, "/") {
+		slashed = "/" + slashed // C:/... (windows)
+	}
+	if column == "" {
+		column = "1"
+	}
+	return strings.NewReplacer(
+		"{host}", self.host,
+		"{path}", (&url.URL{Path: slashed}).EscapedPath(),
+		"{line}", line,
+		"{column}", column,
+	).Replace(self.format)
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
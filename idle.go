@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// IdleSettings configure the idle mode (the [idle] table of .scantest.toml): after a
+// while without file changes or key presses, scantest scans much less often (or not
+// at all, until a key is pressed), so a forgotten session doesn't keep the machine busy.
+type IdleSettings struct {
+	After        time.Duration // without activity (0 disables the idle mode)
+	ScanInterval time.Duration // the time between scans while idle
+	Pause        bool          // whether to stop scanning altogether while idle
+}
+
+var defaultIdle = IdleSettings{
+	After:        30 * time.Minute,
+	ScanInterval: 10 * time.Second,
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+type Idle struct {
+	config *ConfigWatcher
+	wake   chan struct{}
+
+	mutex sync.Mutex
+	last  time.Time // of the latest activity
+	idle  bool
+}
+
+func NewIdle(config *ConfigWatcher) *Idle {
+	return &Idle{config: config, wake: make(chan struct{}, 1), last: time.Now()}
+}
+
+// Touch records activity (a file change or a key press), ending the idle mode.
+func (self *Idle) Touch() {
+	self.mutex.Lock()
+	self.last = time.Now()
+	idle := self.idle
+	self.idle = false
+	self.mutex.Unlock()
+
+	if !idle {
+		return
+	}
+	logf("Idle: off")
+	select {
+	case self.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Wake records activity and cuts the wait for the next scan short.
+func (self *Idle) Wake() {
+	self.Touch()
+	select {
+	case self.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Last is the time of the latest activity.
+func (self *Idle) Last() time.Time {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.last
+}
+
+// Wait is called between scans: it sleeps for the interval given (or the idle one,
+// if longer) or, when paused, until there's activity.
+func (self *Idle) Wait(interval time.Duration) {
+	settings := self.config.Settings().Idle
+	self.mutex.Lock()
+	inactive := time.Since(self.last)
+	entered := settings.After > 0 && inactive >= settings.After && !self.idle
+	if entered {
+		self.idle = true
+	}
+	idle := self.idle
+	self.mutex.Unlock()
+
+	if entered && settings.Pause {
+		logf("Idle: no activity for %s (scanning is paused until a key is pressed)", settings.After)
+	} else if entered {
+		logf("Idle: no activity for %s (scanning every %s)", settings.After, settings.ScanInterval)
+	}
+	if idle && settings.Pause {
+		<-self.wake
+		return
+	}
+	if idle && settings.ScanInterval > interval {
+		interval = settings.ScanInterval
+	}
+	select {
+	case <-time.After(interval):
+	case <-self.wake:
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) idle(path string, value interface{}, idle *IdleSettings) {
+	for key, value := range self.table(path, value) {
+		switch key {
+		case "after":
+			after, err := time.ParseDuration(self.string(path+"."+key, value))
+			if err != nil || after < 0 {
+				self.fail(path+"."+key, "must be a duration (ie. \"30m\", or \"0\" to never idle).")
+			}
+			idle.After = after
+		case "scan-interval":
+			interval, err := time.ParseDuration(self.string(path+"."+key, value))
+			if err != nil || interval <= 0 {
+				self.fail(path+"."+key, "must be a positive duration (ie. \"10s\").")
+			}
+			idle.ScanInterval = interval
+		case "pause":
+			idle.Pause = self.boolean(path+"."+key, value)
+		default:
+			self.unknown(path+"."+key, key, "after", "scan-interval", "pause")
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	actionRunAll     = "run-all"
+	actionRunPending = "run-pending"
+	actionPause      = "pause"
+	actionQuit       = "quit"
+	actionHelp       = "help"
+	actionSearch     = "search"
+	actionSnapshot   = "snapshot"
+	actionChord      = "chord"
+)
+
+// defaultKeys may be remapped with the [keys] table of .scantest.toml. Keys are
+// named by their character or as enter, space, tab, esc or ctrl-<letter>:
+//
+//	[keys]
+//	run-all = "R"
+//	quit = "ctrl-c"
+//	chord = "x"            # the key that precedes the keys of [commands]
+//
+//	[commands]             # x, then l runs `make lint`
+//	l = "make lint"
+var defaultKeys = map[string]string{
+	actionRunAll:     "enter",
+	actionRunPending: "p",
+	actionPause:      "space",
+	actionQuit:       "q",
+	actionHelp:       "?",
+	actionSearch:     "/",
+	actionSnapshot:   "s",
+	actionChord:      "x",
+}
+
+var actions = []string{actionRunAll, actionRunPending, actionPause, actionQuit, actionHelp, actionSearch, actionSnapshot, actionChord}
+
+func parseKey(name string) (byte, bool) {
+	switch name {
+	case "enter":
+		return '\n', true
+	case "space":
+		return ' ', true
+	case "tab":
+		return '\t', true
+	case "esc":
+		return 27, true
+	}
+	if letter := strings.TrimPrefix(name, "ctrl-"); letter != name && len(letter) == 1 {
+		if c := strings.ToLower(letter)[0]; c >= 'a' && c <= 'z' {
+			return c - 'a' + 1, true
+		}
+		return 0, false
+	}
+	if len(name) == 1 && name[0] > ' ' && name[0] < 127 {
+		return name[0], true
+	}
+	return 0, false
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Input turns keystrokes into commands: running everything again, pausing (and
+// resuming), quitting, listing the bindings, searching the output of the last run (see
+// Search), saving a snapshot of it (see Snapshots), or (after the chord key) running a
+// user-defined shell command.
+// When stdin isn't a terminal, whole lines may be commands as well (see command).
+type Input struct {
+	root      string
+	config    *ConfigWatcher
+	web       bool
+	screen    *Screen
+	idle      *Idle
+	lock      *Lock
+	runner    *Runner
+	pause     *Pause
+	focus     *Focus
+	search    *Search
+	snapshots *Snapshots
+	warmer    *Warmer
+	events    *FileEvents
+	out       chan struct{} // run everything again
+	pending   chan struct{} // run whatever the rerun intervals hold back, right away
+	targets   chan string   // run the tests of the package in this folder (see command)
+
+	mutex     sync.Mutex
+	chorded   bool
+	searching bool   // typing the text to search for
+	query     []byte // (as typed so far)
+	restore   func()
+}
+
+func (self *Input) ListenForever() {
+	restore, cbreak := enterCbreakMode(os.Stdin.Fd())
+	self.restore = restore
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	terminations := make(chan os.Signal, 1)
+	signal.Notify(terminations, syscall.SIGTERM, syscall.SIGHUP) // (ie. another instance taking over, or the terminal closing)
+	go func() {
+		<-terminations
+		self.quit(0)
+	}()
+	go func() {
+		for range interrupts {
+			self.mutex.Lock()
+			self.chorded = false
+			self.mutex.Unlock()
+			if !self.press(3) { // ctrl-c quits, unless bound to something else
+				self.quit(1)
+			}
+		}
+	}()
+
+	if !cbreak { // keys only arrive (followed by enter) once the line is complete, so lines may be commands too.
+		self.readLines(os.Stdin)
+	}
+	for {
+		a := []byte{0}
+		if _, err := os.Stdin.Read(a); err == io.EOF {
+			select {} // nothing more to read, but keep testing
+		} else if err != nil {
+			continue
+		}
+		self.press(a[0])
+	}
+}
+
+// press reports whether the key was bound to anything.
+func (self *Input) press(key byte) bool {
+	self.idle.Touch()
+	settings := self.config.Settings()
+	self.mutex.Lock()
+	chorded := self.chorded
+	self.chorded = false
+	searching := self.searching
+	self.mutex.Unlock()
+
+	if searching {
+		self.typeQuery(key)
+		return true
+	}
+	if self.search.Active() {
+		switch key {
+		case 'n':
+			self.search.Jump(1)
+			return true
+		case 'N':
+			self.search.Jump(-1)
+			return true
+		}
+		self.search.Stop()
+	}
+	if chorded {
+		for name, command := range settings.Commands {
+			if code, _ := parseKey(name); code == key {
+				self.execute(command)
+				return true
+			}
+		}
+		fmt.Fprintf(os.Stderr, "No command is bound to %q (see [commands] in %s).\n", describeKey(key), configFilename)
+		return true
+	}
+
+	return self.perform(bindings(settings)[key], settings)
+}
+
+// perform reports whether the action is one.
+func (self *Input) perform(action string, settings Settings) bool {
+	switch action {
+	case actionRunAll:
+		self.out <- struct{}{}
+	case actionRunPending:
+		self.pending <- struct{}{}
+	case actionPause:
+		if err := self.pause.Toggle(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	case actionQuit:
+		self.quit(0)
+	case actionHelp:
+		self.help(settings)
+	case actionSearch:
+		self.mutex.Lock()
+		self.searching, self.query = true, nil
+		self.mutex.Unlock()
+		fmt.Fprint(os.Stderr, "/")
+	case actionSnapshot:
+		self.snapshot("")
+	case actionChord:
+		self.mutex.Lock()
+		self.chorded = true
+		self.mutex.Unlock()
+	default:
+		return false
+	}
+	return true
+}
+
+// typeQuery edits the text to search for (which enter searches, and esc abandons).
+func (self *Input) typeQuery(key byte) {
+	self.mutex.Lock()
+	query := string(self.query)
+	switch key {
+	case '\n', '\r', 27, 3:
+		self.searching, self.query = false, nil
+	case 127, 8: // backspace
+		if len(self.query) > 0 {
+			self.query = self.query[:len(self.query)-1]
+			fmt.Fprint(os.Stderr, "\b \b")
+		}
+	default:
+		if key >= ' ' {
+			self.query = append(self.query, key)
+			fmt.Fprintf(os.Stderr, "%c", key)
+		}
+	}
+	self.mutex.Unlock()
+
+	switch key {
+	case '\n', '\r':
+		fmt.Fprintln(os.Stderr)
+		self.search.Find(query)
+	case 27, 3:
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// snapshot saves a snapshot of the last run to the file (see Snapshots.Save).
+func (self *Input) snapshot(path string) {
+	paths, err := self.snapshots.Save(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	for x := range paths {
+		paths[x] = relativePath(paths[x])
+	}
+	logf("Snapshot saved to %s", strings.Join(paths, " and "))
+}
+
+func (self *Input) quit(code int) {
+	self.runner.Stop()
+	self.warmer.Stop()
+	self.lock.Release()
+	self.screen.Close()
+	if self.restore != nil {
+		self.restore()
+	}
+	os.Exit(code)
+}
+
+func (self *Input) execute(line string) {
+	logf("Running: %s", line)
+	command := newShellCommand(context.Background(), line)
+	command.Env = self.config.Settings().Environ()
+	command.Stdout, command.Stderr = os.Stdout, os.Stderr
+	if self.web {
+		command.Stdout = os.Stderr // stdout is reserved for JSON
+	}
+	if err := command.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, line+":", err)
+	}
+}
+
+func (self *Input) help(settings Settings) {
+	keys := resolveKeys(settings)
+	fmt.Fprintln(os.Stderr, "Keys:")
+	for _, action := range actions {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", keys[action], action)
+	}
+	names := []string{}
+	for name := range settings.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", keys[actionChord]+" "+name, settings.Commands[name])
+	}
+}
+
+func resolveKeys(settings Settings) map[string]string {
+	keys := map[string]string{}
+	for action, name := range defaultKeys {
+		keys[action] = name
+	}
+	for action, name := range settings.Keys {
+		keys[action] = name
+	}
+	return keys
+}
+
+func bindings(settings Settings) map[byte]string {
+	bound := map[byte]string{}
+	for action, name := range resolveKeys(settings) {
+		if key, ok := parseKey(name); ok {
+			bound[key] = action
+		}
+	}
+	return bound
+}
+
+func describeKey(key byte) string {
+	switch {
+	case key == '\n':
+		return "enter"
+	case key == ' ':
+		return "space"
+	case key == '\t':
+		return "tab"
+	case key == 27:
+		return "esc"
+	case key >= 1 && key <= 26:
+		return "ctrl-" + string('a'+key-1)
+	}
+	return string(key)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) keys(path string, value interface{}) map[string]string {
+	keys := map[string]string{}
+	for action, value := range self.table(path, value) {
+		name := self.string(path+"."+action, value)
+		if _, found := defaultKeys[action]; !found {
+			self.fail(path+"."+action, "isn't an action (expected one of: %s).", strings.Join(actions, ", "))
+			continue
+		}
+		if _, ok := parseKey(name); !ok {
+			self.fail(path+"."+action, "must name a key (a character, enter, space, tab, esc or ctrl-<letter>).")
+			continue
+		}
+		keys[action] = name
+	}
+	bound := map[byte]string{}
+	for action, name := range resolveKeys(Settings{Keys: keys}) {
+		key, _ := parseKey(name)
+		if other, found := bound[key]; found {
+			if _, remapped := keys[action]; !remapped || other > action {
+				action, other = other, action
+			}
+			self.fail(path+"."+action, "uses the same key as %s.", other)
+		}
+		bound[key] = action
+	}
+	return keys
+}
+
+func (self *configDecoder) commands(path string, value interface{}) map[string]string {
+	commands := map[string]string{}
+	for name, value := range self.table(path, value) {
+		if _, ok := parseKey(name); !ok {
+			self.fail(path+"."+name, "must name a key (a character, enter, space, tab, esc or ctrl-<letter>).")
+		}
+		commands[name] = self.string(path+"."+name, value)
+	}
+	return commands
+}
+
+// stopOnSignals stops the runner and gives the tree up before exiting on <ctrl>+c,
+// SIGTERM or SIGHUP, where there's no Input to (with -once).
+func stopOnSignals(runner *Runner, lock *Lock) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	<-signals
+	runner.Stop()
+	lock.Release()
+	os.Exit(1)
+}
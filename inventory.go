@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Inventory is what the first scan found, announced once at startup so that it's
+// plain whether scantest understood the repository before any editing begins.
+type Inventory struct {
+	Root     string
+	Ignore   []string
+	Packages int
+	Tested   int      // packages with test files
+	Modules  []string // "" for packages outside of any module (GOPATH)
+	Tags     []string
+	Estimate time.Duration // the predicted duration of a full run (0: unknown)
+	Workers  int
+}
+
+func takeInventory(root string, all []*Package, settings Settings, history *History) Inventory {
+	inventory := Inventory{Root: root, Ignore: settings.Ignore, Workers: settings.Parallel}
+	modules := map[string]bool{}
+	packages := map[string]bool{}
+	for _, pkg := range all {
+		if len(pkg.Info.GoFiles)+len(pkg.Info.TestGoFiles)+len(pkg.Info.XTestGoFiles) == 0 {
+			continue // (a folder of other files; see AffectsRule)
+		}
+		inventory.Packages++
+		packages[pkg.Info.ImportPath] = true
+		if len(pkg.Info.TestGoFiles)+len(pkg.Info.XTestGoFiles) > 0 {
+			inventory.Tested++
+		}
+		modules[findModule(pkg.Info.Dir)] = true
+	}
+	for module := range modules {
+		inventory.Modules = append(inventory.Modules, module)
+	}
+	sort.Strings(inventory.Modules)
+	inventory.Tags = buildTags(settings)
+	_, inventory.Estimate = schedule(history, packages, settings.Parallel)
+	return inventory
+}
+
+// buildTags gathers the -tags given to go test, by test-args or by GOFLAGS.
+func buildTags(settings Settings) (tags []string) {
+	flags := settings.TestArgs
+	for _, variable := range settings.Environ() {
+		if strings.HasPrefix(variable, "GOFLAGS=") {
+			flags = append(strings.Fields(strings.TrimPrefix(variable, "GOFLAGS=")), flags...)
+		}
+	}
+	for x, flag := range flags {
+		value := ""
+		if strings.HasPrefix(flag, "-tags=") || strings.HasPrefix(flag, "--tags=") {
+			value = flag[strings.Index(flag, "=")+1:]
+		} else if (flag == "-tags" || flag == "--tags") && x+1 < len(flags) {
+			value = flags[x+1]
+		}
+		for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (self Inventory) describe() []string {
+	watching := "Watching " + self.Root
+	if len(self.Ignore) > 0 {
+		watching += fmt.Sprintf(" (ignoring %s)", strings.Join(self.Ignore, ", "))
+	}
+
+	modules := []string{}
+	for _, module := range self.Modules {
+		if module == "" {
+			module = "GOPATH"
+		}
+		modules = append(modules, module)
+	}
+	found := fmt.Sprintf("Found %s (%d with tests)", countPackages(self.Packages), self.Tested)
+	if len(modules) == 1 && self.Modules[0] == "" {
+		found += " in GOPATH mode"
+	} else if len(modules) == 1 {
+		found += " in module " + modules[0]
+	} else if len(modules) > 1 {
+		found += fmt.Sprintf(" in %d modules: %s", len(modules), strings.Join(modules, ", "))
+	}
+
+	tags := "Build tags: none"
+	if len(self.Tags) > 0 {
+		tags = "Build tags: " + strings.Join(self.Tags, ", ")
+	}
+
+	estimate := "A full run: no estimate yet (some packages have no history)"
+	if self.Estimate > 0 {
+		estimate = fmt.Sprintf("A full run: ~%s predicted (%s at once)", self.Estimate.Round(time.Second/10), countPackages(self.Workers))
+	}
+	return []string{watching, found, tags, estimate}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// LastGreen is where a failing package last passed, according to the history: the
+// commit of the latest run it passed in, and how far back that is.
+type LastGreen struct {
+	Commit string `json:"commit"`
+	Dirty  bool   `json:"dirty,omitempty"` // (it passed with local changes on top of the commit)
+	Ago    int    `json:"ago"`             // commits from there to HEAD (-1: not an ancestor of HEAD)
+}
+
+// LastGreen finds the latest recorded run at a commit in which the package passed (nil
+// if the package passes, or never did at a known commit). A nil History knows nothing.
+func (self *History) LastGreen(result Result) *LastGreen {
+	if self == nil || result.Status == TestsPassed {
+		return nil
+	}
+	self.mutex.Lock()
+	var git *GitState
+	for x := len(self.records) - 1; x >= 0 && git == nil; x-- {
+		for _, pkg := range self.records[x].Packages {
+			if pkg.PackageName == result.PackageName && pkg.Status == TestsPassed && self.records[x].Git != nil {
+				git = self.records[x].Git
+				break
+			}
+		}
+	}
+	self.mutex.Unlock()
+	if git == nil {
+		return nil
+	}
+	return &LastGreen{Commit: git.Commit, Dirty: git.Dirty > 0, Ago: commitsAgo(git.Commit)}
+}
+
+// commitsAgo counts the commits from the given one to HEAD (-1 when it isn't an
+// ancestor of HEAD, ie. on another branch, or rebased away).
+func commitsAgo(commit string) int {
+	if exec.Command("git", "merge-base", "--is-ancestor", commit, "HEAD").Run() != nil {
+		return -1
+	}
+	count, err := exec.Command("git", "rev-list", "--count", commit+"..HEAD").Output()
+	if err != nil {
+		return -1
+	}
+	ago, err := strconv.Atoi(strings.TrimSpace(string(count)))
+	if err != nil {
+		return -1
+	}
+	return ago
+}
+
+func describeLastGreen(result Result) string {
+	green := result.LastGreen
+	if green == nil {
+		return ""
+	}
+	description := " [last green at " + shortCommit(green.Commit)
+	if green.Dirty {
+		description += " with local changes"
+	}
+	return description + " (" + describeAgo(green.Ago) + ")]"
+}
+
+func describeAgo(ago int) string {
+	switch {
+	case ago < 0:
+		return "not an ancestor of HEAD"
+	case ago == 0:
+		return "HEAD"
+	case ago == 1:
+		return "1 commit ago"
+	default:
+		return fmt.Sprintf("%d commits ago", ago)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// BisectScripts saves a script for `git bisect run` for each failing package that
+// passed at an earlier commit (see LastGreen), which tests the package (with the
+// [env] and test-args of .scantest.toml) at whatever commit git checks out, so that
+// git finds the commit that broke it. The scripts of packages that pass again are
+// removed. A nil BisectScripts saves nothing.
+type BisectScripts struct {
+	root   string
+	folder string
+	config *ConfigWatcher
+}
+
+func NewBisectScripts(root string, config *ConfigWatcher) *BisectScripts {
+	return &BisectScripts{root: root, folder: filepath.Join(root, stateFolder, "bisect"), config: config}
+}
+
+func (self *BisectScripts) Write(report *Report) error {
+	if self == nil {
+		return nil
+	}
+	settings := self.config.Settings()
+	for _, result := range report.Results {
+		path := filepath.Join(self.folder, strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(result.PackageName)+".sh")
+		green := result.LastGreen
+		if green == nil || green.Ago < 1 {
+			if result.Status == TestsPassed {
+				os.Remove(path)
+			}
+			continue
+		}
+		script := bisectScript(self.root, path, result, settings)
+		if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, script) {
+			continue
+		}
+		if err := os.MkdirAll(self.folder, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, script, 0755); err != nil {
+			return err
+		}
+		logf("Bisect: %s last passed at %s; git bisect start HEAD %s && git bisect run %s", result.PackageName, shortCommit(green.Commit), shortCommit(green.Commit), shellQuote(relativePath(path)))
+	}
+	return nil
+}
+
+func bisectScript(root, path string, result Result, settings Settings) []byte {
+	green := result.LastGreen
+	script := new(bytes.Buffer)
+	fmt.Fprintln(script, "#!/bin/sh")
+	fmt.Fprintf(script, "# %s passed at %s (%s) and fails at HEAD. To find the commit that broke it\n", result.PackageName, green.Commit, describeAgo(green.Ago))
+	fmt.Fprintln(script, "# (with the failure committed, and a clean working tree):")
+	fmt.Fprintln(script, "#")
+	fmt.Fprintf(script, "#   git bisect start HEAD %s && git bisect run %s; git bisect reset\n", green.Commit, shellQuote(path))
+	if green.Dirty {
+		fmt.Fprintln(script, "#")
+		fmt.Fprintln(script, "# (It passed there with local changes, so that commit may not be good itself.)")
+	}
+	fmt.Fprintln(script)
+	names := []string{}
+	for name := range settings.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(script, "export %s=%s\n", name, shellQuote(settings.Env[name]))
+	}
+	fmt.Fprintf(script, "cd %s || exit 125\n", shellQuote(root))
+	command := []string{"go", "test", "-count=1"}
+	for _, argument := range settings.TestArgs {
+		command = append(command, shellQuote(argument))
+	}
+	command = append(command, shellQuote(result.PackageName))
+	fmt.Fprintln(script, strings.Join(command, " "))
+	return script.Bytes()
+}
+
+// shellQuote quotes the argument for sh, unless it's plain.
+func shellQuote(argument string) string {
+	if argument != "" && strings.Trim(argument, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+@") == "" {
+		return argument
+	}
+	return "'" + strings.ReplaceAll(argument, "'", `'\''`) + "'"
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const lockFilename = "scantest.pid"
+
+// Lock keeps a second scantest from running in the same tree (both would fight over
+// runs and double the load): the instance that owns the tree holds an advisory lock
+// on .scantest/scantest.pid (see lockFile), which the system lets go of when the
+// process exits, however it exits, and writes its pid there. So a pid file left
+// behind means nothing, and only the owner of the lock is ever signalled. A nil Lock
+// releases nothing.
+type Lock struct {
+	file *os.File
+}
+
+// acquireLock either refuses to start while another instance owns the tree or, with
+// takeover, stops that instance (as with <ctrl>+c) and takes its place.
+func acquireLock(root string, takeover bool) (*Lock, error) {
+	path := filepath.Join(root, stateFolder, lockFilename)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	asked, previous := false, 0
+	for {
+		if lockFile(file) == nil {
+			if err := writeOwner(file); err != nil {
+				file.Close()
+				return nil, err
+			}
+			return &Lock{file: file}, nil
+		}
+
+		owner := readOwner(path) // (0 until the owner has written its pid)
+		if !takeover {
+			file.Close()
+			if owner <= 0 {
+				return nil, fmt.Errorf("scantest is already running in this folder. Stop it first, or start with -takeover to replace it.")
+			}
+			return nil, fmt.Errorf("scantest is already running in this folder (pid %d). Stop it first, or start with -takeover to replace it.", owner)
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("scantest (pid %d) didn't stop within 5s of being asked to.", owner)
+		}
+		if owner > 0 && owner == previous && owner != os.Getpid() { // (the same pid twice in a row, and not that of an owner gone before the current one wrote its own)
+			if !asked {
+				fmt.Fprintf(os.Stderr, "Taking over from scantest (pid %d)...\n", owner)
+				asked = true
+			}
+			terminate(owner)
+		}
+		previous = owner
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func writeOwner(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+func readOwner(path string) int {
+	raw, _ := os.ReadFile(path)
+	owner, _ := strconv.Atoi(strings.TrimSpace(string(raw)))
+	return owner
+}
+
+// Release gives the tree up. The file stays (emptied): removing it would let an
+// instance that opened it just before lock the removed file, while another creates
+// and locks a new one.
+func (self *Lock) Release() {
+	if self == nil {
+		return
+	}
+	self.file.Truncate(0)
+	unlockFile(self.file)
+	self.file.Close()
+}
@@ -2,20 +2,22 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"go/build"
+	"hash/fnv"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
-	"github.com/smartystreets/gunit/gunit/generate"
+	"github.com/smartystreets/scantest/parser"
 )
 
 //////////////////////////////////////////////////////////////////////////////////////
@@ -23,77 +25,559 @@ import (
 //////////////////////////////////////////////////////////////////////////////////////
 
 func main() {
-	var web bool
+	if len(os.Args) > 1 && os.Args[1] == "graph" {
+		runGraph(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && (os.Args[1] == "pause" || os.Args[1] == "resume") {
+		runPause(os.Args[1])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		runDoctor(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "stress" {
+		runStress(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		runConfig(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		runAgent(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		listHistory(os.Args[2:])
+		return
+	}
+
+	var (
+		web, interrupt, history bool
+		gitignore, throttle     bool
+		status, narrow, once    bool
+		stream, takeover        bool
+		buildUntested, examples bool
+		clear, sticky, fold     bool
+		showVersion, warnSkips  bool
+		quiet, background       bool
+		keepBinaries, bisect    bool
+		raw, plain, bell        bool
+		removeOrphaned          bool
+		skipNoops, warm         bool
+		patchCoverage           bool
+		debug                   bool
+		since, baselineRef      string
+		parallel                int
+		profile                 string
+		filters, processors     PluginList
+		reportHTML, sqlitePath  string
+		sarifPath, traceFolder  string
+		rerunFails              string
+		rerunFailsReport        string
+		fuzz                    time.Duration
+		targetList              string
+		format                  string
+		hyperlinks, linkFormat  string
+		modFix, focusPackages   string
+		serve                   string
+		moduleRoot              string
+		gowork                  bool
+	)
 	flag.BoolVar(&web, "web", false, "Set to true by the scantest-web command (for sending JSON results to a browser via websocketd).")
-	flag.Parse()
+	flag.BoolVar(&interrupt, "interrupt", false, "When true, saving a file during a run cancels the in-flight tests and starts over with the newest state.")
+	flag.IntVar(&parallel, "parallel", runtime.NumCPU(), "The maximum number of packages tested at once (slowest packages, according to history, are started first). Overrides the config file.")
+	flag.StringVar(&policySource, "policy", policySource, "A file or a URL (the default is $SCANTEST_POLICY) with the config an organization mandates (ie. -race for some packages, forbidden-skips, min-coverage), beneath the global config and .scantest.toml.")
+	flag.StringVar(&profile, "profile", "", "The name of the [profiles.<name>] table of .scantest.toml to apply. Overrides the config file.")
+	flag.BoolVar(&history, "history", true, "When true, run results are recorded in .scantest/history.jsonl (used to predict run durations and schedule slow packages first).")
+	flag.BoolVar(&status, "status", true, "When true, the outcome of the latest run is kept in .scantest/status.json (for shell prompts, status bars, etc...).")
+	flag.BoolVar(&narrow, "narrow", true, "When true and only test functions changed in a package (not helpers, imports, etc...), just those tests are run (via -run).")
+	flag.BoolVar(&examples, "examples", false, "When true, Example functions are run along with the tests that -narrow picks (rather than only with the whole package).")
+	flag.BoolVar(&quiet, "quiet", false, "When true, packages are tested without -v (quicker, and quieter), and only those whose tests fail are tested again with -v (and the retry-args of .scantest.toml, ie. -race) for the details. Skipped tests aren't reported for packages that pass.")
+	flag.BoolVar(&keepBinaries, "resident", false, "When true, the compiled test binaries of packages with an expensive TestMain (whose tests take 3s or more, according to the history) are kept and run again (with -test.run, when narrowed) until the code they're built from changes, rather than going through go test every time.")
+	flag.BoolVar(&skipNoops, "skip-noop", false, "When true, edits of go files that leave their code as it was (changes to comments or formatting, renamed local variables, etc...) don't trigger runs.")
+	flag.BoolVar(&warm, "warm", false, "When true, everything is built (go build ./..., at the lowest priority) right after startup, so that the build cache is warm by the first edit. The build gives way to the runs edits trigger.")
+	flag.BoolVar(&debug, "debug", false, "When true, the health of the pipeline (how long each stage takes, the sizes of its batches and how long it waits on the next one; see /metrics of -serve) is logged every 30s.")
+	flag.BoolVar(&patchCoverage, "patch-coverage", false, "When true, packages with lines changed since scantest started (committed or not) are tested with -coverprofile, and the changed lines their tests didn't cover are listed.")
+	flag.BoolVar(&removeOrphaned, "remove-orphaned", false, "When true, a file gunit generated for fixtures that are gone (their files were removed) is removed, rather than reported, so that the package compiles again.")
+	flag.BoolVar(&warnSkips, "warn-skips", false, "When true, skipped tests whose reason matches none of the expected-skips of .scantest.toml (ie. a missing environment variable) are reported as warnings.")
+	flag.BoolVar(&once, "once", false, "When true, scantest runs the tests once (everything, or see -since), prints the results and exits (with 1 if anything failed).")
+	flag.StringVar(&since, "since", "", "A git ref (ie. origin/main): the first run (or the only one, with -once) tests just the packages with files changed since the current branch forked from it, and the packages that depend on them.")
+	flag.StringVar(&rerunFails, "rerun-fails", "", "A file of failed tests, a line for each (the package, a space and the test, as written by -rerun-fails-report or gotestsum's --rerun-fails-report): the first run (or the only one, with -once) tests just those.")
+	flag.StringVar(&rerunFailsReport, "rerun-fails-report", "", "When set, the tests that failed in each run are saved to this file, a line for each (the package, a space and the test, like gotestsum's --rerun-fails-report).")
+	flag.BoolVar(&bisect, "bisect", false, "When true, a script for git bisect run is saved to .scantest/bisect for each failing package that passed at an earlier commit (according to the history), to find the commit that broke it.")
+	flag.StringVar(&baselineRef, "baseline", "", "A git ref (ie. origin/main): the whole suite is run once (in the background, in a temporary worktree) where the current branch forked from it, and each failure is marked as pre-existing on that baseline or introduced by local changes.")
+	flag.BoolVar(&stream, "stream", false, "When true, the output of each package's tests is printed as it comes (each line prefixed with the package), ahead of the usual results (console only).")
+	flag.BoolVar(&buildUntested, "build-untested", false, "When true, packages without test files (commands, tools, etc...) are compiled with `go build` (quicker than `go test`), so that breaking them shows up too.")
+	flag.BoolVar(&takeover, "takeover", false, "When true, a scantest already running in this folder is stopped and replaced (rather than refusing to start). Always the case with -web.")
+	flag.BoolVar(&clear, "clear", false, "When true, the console is cleared at the start of each run.")
+	flag.BoolVar(&raw, "raw", false, "When true, the console output is just what go test printed for each package, in the order they finished (no colors, banners, headers or summaries), for piping into log processors and scripts written for go test.")
+	flag.BoolVar(&plain, "plain", false, "When true, the console output suits screen readers: no colors (or other escape codes), PASS or FAIL before each package, and a sentence rather than a rule at the end of each run.")
+	flag.BoolVar(&bell, "bell", false, "When true, the terminal bell rings when a run has failures (an audible cue, ie. with -plain).")
+	flag.BoolVar(&fold, "fold", true, "When true, the lines of passing tests (=== RUN, --- PASS) in the console output are folded into a count (otherwise they're dimmed), so that failures stand out.")
+	flag.BoolVar(&sticky, "sticky", false, "When true, a one-line summary of the latest run is pinned to the bottom of the console.")
+	flag.Var(&filters, "filter-plugin", "A command (repeatable) that receives the selected packages as JSON on stdin and writes the packages to actually test to stdout.")
+	flag.Var(&processors, "results-plugin", "A command (repeatable) that receives the results as JSON (see -web) on stdin and writes the (altered) results to stdout.")
+	flag.StringVar(&sarifPath, "sarif", "", "When set, the findings of each run (the file:line references of validator problems and compile failures, go vet's among them) are saved to this file as a SARIF 2.1 log, for GitHub code scanning or editors.")
+	flag.StringVar(&traceFolder, "trace", "", "When set, a trace of each run (the scan, the selection and the phases of each package: generate, validate, test, etc...) is saved to this folder in the trace event format of Chrome, for chrome://tracing or Perfetto.")
+	flag.StringVar(&reportHTML, "report-html", "", "When set, a self-contained HTML report of each run is saved to this folder.")
+	flag.StringVar(&sqlitePath, "sqlite", "", "When set, each run is also recorded in this SQLite database (ie. .scantest/history.db), in the runs, package_results and test_cases tables (requires the sqlite3 command).")
+	flag.DurationVar(&fuzz, "fuzz", 0, "When set, the fuzz targets of each modified package are run for this long (each) after its tests pass (ie. -fuzz=10s).")
+	flag.StringVar(&moduleRoot, "module-root", "", "When set, the folder of the module to test (of a repository with several modules, under $GOPATH/src): scantest works from there, as if started there, and leaves the modules nested under it alone.")
+	flag.BoolVar(&gowork, "gowork", true, "When false, go.work files are ignored (GOWORK=off is given to the go commands), so go test builds packages within their own module, and the modules nested under the working directory are left alone.")
+	flag.BoolVar(&gitignore, "gitignore", true, "When true, paths matched by .gitignore files (and .git/info/exclude) aren't scanned.")
+	flag.StringVar(&targetList, "targets", "", "A comma-separated list of GOOS/GOARCH pairs (ie. linux/amd64,windows/amd64) for which the test binaries of tested packages are also compiled (but not run).")
+	flag.BoolVar(&background, "background", false, "When true, the go commands scantest runs (and so the tests) get a lower priority (nice and ionice, or below normal on windows), and perhaps fewer CPUs (see the [background] table of .scantest.toml), so that the editor never stutters.")
+	flag.StringVar(&modFix, "mod-fix", "off", "What to do when a package fails to compile for want of a module (ie. a missing go.sum entry, right after importing a new dependency): off (say what would help), download (run go mod download all) or tidy (run go mod tidy, which edits go.mod and go.sum), then test the package again.")
+	flag.BoolVar(&throttle, "throttle", false, "When true, scantest scans less often and tests fewer packages at once while the machine is busy or on battery power (see the [throttle] table of .scantest.toml).")
+	flag.StringVar(&format, "format", formatStandard, "The console output format: "+strings.Join(formats, ", ")+" (compact: a line per package, with a character per test for dots, followed by the failures in full).")
+	flag.StringVar(&hyperlinks, "hyperlinks", "auto", "Whether file:line references in the console output are terminal hyperlinks (OSC 8): auto (when the terminal is known to support them), on or off.")
+	flag.StringVar(&linkFormat, "hyperlink-format", defaultHyperlinkFormat, "The target of the hyperlinks, with {host}, {path}, {line} and {column} (ie. vscode://file{path}:{line}:{column}).")
+	flag.StringVar(&serve, "serve", "", "An address (ie. :8889) on which the results are served to any number of clients (team members, wall dashboards), as server-sent events at /events and JSON at /latest, each optionally filtered with ?packages=<pattern>,...")
+	flag.StringVar(&focusPackages, "focus", "", "A regular expression: only the packages whose import paths match it are tested (the filter line command changes it). See also scantest run.")
+	flag.BoolVar(&showVersion, "version", false, "When true, scantest prints its version (and the commit it was built from) and exits.")
+	flag.Usage = printUsage
+	command, arguments, known := parseCommand(os.Args[1:])
+	if command == "completion" {
+		runCompletion(arguments) // (once the flags are defined)
+		return
+	}
+	if !known {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'.\n\n", command)
+		printUsage()
+		os.Exit(2)
+	}
+	flag.CommandLine.Parse(arguments)
+	if command == "once" || command == "run" {
+		once = true
+	}
+
+	if showVersion {
+		fmt.Println(describeVersion())
+		return
+	}
+
+	if moduleRoot != "" {
+		if err := pinModuleRoot(moduleRoot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if !gowork {
+		os.Setenv("GOWORK", "off") // (for the go commands: go/build resolves packages the GOPATH way, regardless)
+	}
 
 	workingDirectory, err := os.Getwd()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	if command == "run" {
+		if focusPackages, err = focusPackageArguments(workingDirectory, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if raw && web {
+		fmt.Fprintln(os.Stderr, "-raw and -web both print to stdout, so they can't be combined.")
+		os.Exit(1)
+	}
+
+	if plain || format == formatEmacs { // (compilation-mode shows escape codes as they are)
+		plainOutput()
+		hyperlinks = "off"
+	}
+
+	if !validFormat(format) {
+		fmt.Fprintf(os.Stderr, "Unknown format '%s' (expected one of: %s).\n", format, strings.Join(formats, ", "))
+		os.Exit(1)
+	}
+
+	linker, err := NewHyperlinker(hyperlinks, linkFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	moduleFixer, err := NewModuleFixer(modFix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	targets, err := parseTargets(targetList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	lock, err := acquireLock(workingDirectory, takeover || web) // (a new browser connection replaces the previous one)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	overrides := Settings{Profile: profile}
+	overrides.Throttle.Enabled = throttle
+	overrides.Background.Enabled = background
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "parallel" {
+			overrides.Parallel = parallel
+		}
+	})
+	config := NewConfigWatcher(workingDirectory, overrides)
+	if config.Problem() != nil {
+		lock.Release()
+		os.Exit(1) // (rather than watch with settings other than those intended)
+	}
+	throttler := &Throttle{config: config}
+	idle := NewIdle(config)
+	events := NewFileEvents(workingDirectory, idle)
+	network := NewNetwork(workingDirectory, config)
+
+	var runHistory *History
+	if history {
+		runHistory = NewHistory(workingDirectory)
+	}
+
+	var sinceFiles map[string]bool
+	if since != "" {
+		if sinceFiles, err = changedSince(workingDirectory, since); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			lock.Release()
+			os.Exit(1)
+		}
+	}
+
+	var rerunTests map[string][]string
+	if rerunFails != "" {
+		if rerunTests, err = readRerunFails(rerunFails); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			lock.Release()
+			os.Exit(1)
+		}
+	}
+
+	var testIndex *TestIndex
+	if narrow {
+		testIndex = NewTestIndex()
+	}
+
+	var sqlite *SQLiteSink
+	if sqlitePath != "" {
+		if sqlite, err = NewSQLiteSink(sqlitePath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			lock.Release()
+			os.Exit(1)
+		}
+	}
+
+	var statusFile *StatusFile
+	if status {
+		statusFile = NewStatusFile(workingDirectory)
+	}
+
+	var baseline *Baseline
+	if baselineRef != "" {
+		baseline = NewBaseline(workingDirectory, baselineRef, config)
+		if once {
+			baseline.Collect() // there won't be a later run to mark.
+		} else {
+			go baseline.Collect()
+		}
+	}
+
+	var screen *Screen
+	if !web && !raw && !plain && format != formatEmacs {
+		screen = NewScreen(clear, sticky)
+	}
+
+	snapshots := NewSnapshots(workingDirectory, runHistory)
+	metrics := NewMetrics()
+	if debug {
+		go metrics.LogForever()
+	}
+	if web || serve != "" {
+		var stdout io.Writer
+		if web {
+			stdout = os.Stdout
+		}
+		var hub *Hub
+		if serve != "" {
+			hub = NewHub(events, snapshots, metrics)
+			go hub.ServeForever(serve)
+		}
+		protocol = NewProtocol(stdout, hub)
+		go protocol.HeartbeatForever()
+	}
+
+	var htmlReporter *HTMLReporter
+	if reportHTML != "" {
+		htmlReporter = &HTMLReporter{folder: reportHTML}
+	}
+
+	var rerunReport *RerunFailsReport
+	if rerunFailsReport != "" {
+		rerunReport = &RerunFailsReport{path: rerunFailsReport}
+	}
+
+	var bisectScripts *BisectScripts
+	if bisect && runHistory != nil {
+		bisectScripts = NewBisectScripts(workingDirectory, config)
+	}
+
+	var sarifWriter *SARIFWriter
+	if sarifPath != "" {
+		sarifWriter = &SARIFWriter{path: sarifPath}
+	}
+
+	search := NewSearch()
+	tracer := NewTracer(traceFolder)
+
+	var resident *Resident
+	if keepBinaries {
+		resident = NewResident(workingDirectory, runHistory)
+	}
+	var patchCoverer *PatchCoverer
+	if patchCoverage {
+		if patchCoverer, err = NewPatchCoverer(workingDirectory); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			lock.Release()
+			os.Exit(1)
+		}
+	}
+
+	var warmer *Warmer
+	if warm && !once {
+		warmer = NewWarmer(config.Settings())
+	}
+
+	var multiplexer *Multiplexer
+	if stream && !web {
+		multiplexer = NewMultiplexer(screen)
+	}
+
+	generated := NewGeneratedFiles()
+	focus := NewFocus()
+	if err := focus.Set(focusPackages); err != nil {
+		fmt.Fprintln(os.Stderr, "-focus:", err)
+		lock.Release()
+		os.Exit(1)
+	}
+
+	var pause *Pause
+	if !once {
+		pause = NewPause(workingDirectory)
+	}
 
 	var (
 		inputCommands = make(chan struct{})
+		sweeps        = make(chan struct{})
+		inputTargets  = make(chan string)
 		scannedFiles  = make(chan chan *File)
 		checkedFiles  = make(chan chan *File)
 		packages      = make(chan chan *Package)
-		executions    = make(chan map[string]bool)
-		results       = make(chan []Result)
+		selections    = make(chan *Selection)
+		resumed       = make(chan *Selection)
+		executions    = make(chan *Selection)
+		limited       = make(chan *Selection)
+		pendingNow    = make(chan struct{})
+		reports       = make(chan *Report)
+		results       = make(chan *Report)
 
 		scanner = &FileSystemScanner{
-			root: workingDirectory,
-			out:  scannedFiles,
+			root:      workingDirectory,
+			gitignore: gitignore,
+			modular:   moduleRoot != "" || !gowork,
+			config:    config,
+			throttle:  throttler,
+			network:   network,
+			idle:      idle,
+			tracer:    tracer,
+			metrics:   metrics,
+			out:       scannedFiles,
 		}
 
 		checksummer = &Checksummer{
-			commands: inputCommands,
+			root:      workingDirectory,
+			config:    config,
+			generated: generated,
+			commands:  inputCommands,
+			sweeps:    sweeps,
+			targets:   inputTargets,
+			events:    events,
+			network:   network,
+			idle:      idle,
+			skipNoops: skipNoops,
+			since:     sinceFiles,
+			metrics:   metrics,
 
 			in:  scannedFiles,
 			out: checkedFiles,
 		}
 
 		packager = &Packager{
+			metrics: metrics,
+
 			in:  checkedFiles,
 			out: packages,
 		}
 
 		selector = &PackageSelector{
+			root:      workingDirectory,
+			config:    config,
+			generated: generated,
+			tests:     testIndex,
+			history:   runHistory,
+			tracer:    tracer,
+			metrics:   metrics,
+			rerun:     rerunTests,
+
 			in:  packages,
+			out: selections,
+		}
+
+		pauser = &Pauser{
+			pause: pause,
+
+			in:  selections,
+			out: resumed,
+		}
+
+		filter = &PluginFilter{
+			config:  config,
+			plugins: filters,
+			focus:   focus,
+
+			in:  resumed,
 			out: executions,
 		}
 
-		runner = &Runner{
+		limiter = &RerunLimiter{
+			config: config,
+			now:    pendingNow,
+
 			in:  executions,
+			out: limited,
+		}
+
+		runner = &Runner{
+			interrupt:      interrupt,
+			config:         config,
+			throttle:       throttler,
+			fuzz:           fuzz,
+			targets:        targets,
+			history:        runHistory,
+			sqlite:         sqlite,
+			fingerprints:   NewFingerprints(),
+			status:         statusFile,
+			screen:         screen,
+			stream:         multiplexer,
+			tracer:         tracer,
+			metrics:        metrics,
+			resident:       resident,
+			patch:          patchCoverer,
+			warmer:         warmer,
+			modules:        moduleFixer,
+			quiet:          quiet,
+			removeOrphaned: removeOrphaned,
+			raw:            raw,
+			buildUntested:  buildUntested,
+			examples:       examples,
+			warnSkips:      warnSkips,
+			protocol:       protocol,
+			baseline:       baseline,
+			generated:      generated,
+
+			in:  limited,
+			out: reports,
+		}
+
+		processor = &PluginProcessor{
+			config:  config,
+			plugins: processors,
+
+			in:  reports,
 			out: results,
 		}
 
 		printer = &Printer{
-			in:  results,
-			web: web,
+			config:    config,
+			in:        results,
+			protocol:  protocol,
+			format:    format,
+			html:      htmlReporter,
+			search:    search,
+			snapshots: snapshots,
+			notifier:  NewNotifier(config),
+			sarif:     sarifWriter,
+			rerun:     rerunReport,
+			bisect:    bisectScripts,
+			status:    statusFile,
+			screen:    screen,
+			links:     linker,
+			lock:      lock,
+			once:      once,
+			fold:      fold,
+			raw:       raw,
+			plain:     plain,
+			bell:      bell,
+		}
+
+		input = &Input{
+			root:      workingDirectory,
+			runner:    runner,
+			config:    config,
+			web:       web,
+			screen:    screen,
+			idle:      idle,
+			lock:      lock,
+			pause:     pause,
+			focus:     focus,
+			search:    search,
+			snapshots: snapshots,
+			warmer:    warmer,
+			events:    events,
+			out:       inputCommands,
+			pending:   pendingNow,
+			targets:   inputTargets,
 		}
 	)
 
+	go config.WatchForever()
+	go CheckForUpdatesForever(config)
+	go throttler.MonitorForever()
 	go scanner.ScanForever()
 	go checksummer.RespondForevor()
 	go checksummer.ListenForever()
 	go packager.ListenForever()
 	go selector.ListenForever()
+	go pauser.ListenForever()
+	go filter.ListenForever()
+	go limiter.ListenForever()
 	go runner.ListenForever()
+	go processor.ListenForever()
 	go printer.ListenForever()
-	receiveInput(inputCommands)
+	if once {
+		stopOnSignals(runner, lock) // (the printer exits after the first report)
+	}
+	go NewSweeper(config, idle, sweeps).ScheduleForever()
+	input.ListenForever()
 }
 
 //////////////////////////////////////////////////////////////////////////////////////
 
-func receiveInput(signal chan struct{}) {
-	for {
-		a := []byte{0}
-		os.Stdin.Read(a)
-		if a[0] == 10 { // Enter key
-			signal <- struct{}{}
+// relativePath shortens the path (relative to the working directory) for display.
+func relativePath(path string) string {
+	if workingDirectory, err := os.Getwd(); err == nil {
+		if relative, err := filepath.Rel(workingDirectory, path); err == nil && isWithin(relative) {
+			return relative
 		}
 	}
+	return path
 }
 
 //////////////////////////////////////////////////////////////////////////////////////
@@ -106,7 +590,10 @@ type File struct {
 	IsFolder     bool
 	IsGoFile     bool
 	IsGoTestFile bool
+	IsEmbedded   bool // by a //go:embed directive of the package in ParentFolder (see embeddingFolder)
 	IsModified   bool
+	MovedFrom    string // the previous folder, when the file's folder was just moved (or renamed)
+	Checksum     int64  // the size and modification time (or, in network mode, the contents; see Checksummer)
 }
 
 //////////////////////////////////////////////////////////////////////////////////////
@@ -114,38 +601,91 @@ type File struct {
 //////////////////////////////////////////////////////////////////////////////////////
 
 type FileSystemScanner struct {
-	root string
-	out  chan chan *File
+	root      string
+	gitignore bool
+	modular   bool // the modules nested under the root are left alone (see isNestedModule)
+	config    *ConfigWatcher
+	throttle  *Throttle
+	network   *Network
+	idle      *Idle
+	tracer    *Tracer
+	metrics   *Metrics
+	out       chan chan *File
 }
 
 func (self *FileSystemScanner) ScanForever() {
-	for {
+	for first := true; ; first = false {
 		batch := make(chan *File)
+		handoff := self.metrics.Handoff("scanned-files")
 		self.out <- batch
+		handoff()
+		started := time.Now()
+		folders := self.walk(batch)
+		close(batch)
+		self.tracer.Scanned(started)
+		self.metrics.Since("scan_seconds", started)
+		if first {
+			if limit, ok := watchLimit(); ok {
+				if warning := describeWatchLimit(folders, limit); warning != "" {
+					logf("%s", warning)
+				}
+			}
+		}
+		self.idle.Wait(self.network.ScanInterval(self.throttle.ScanInterval()))
+	}
+}
 
-		filepath.Walk(self.root, func(path string, info os.FileInfo, err error) error { // TODO: handle err of filepath.Walk?
-			if info.IsDir() && (info.Name() == ".git" || info.Name() == ".hg" /* etc... */) {
+// walk sends the files (and folders) of the tree to the batch, and returns how many
+// folders there were.
+func (self *FileSystemScanner) walk(batch chan *File) (folders int) {
+	// rebuilt every pass so that edits to .gitignore files (and the config) are noticed.
+	settings := self.config.Settings()
+	ignore := NewGitIgnore(self.root, self.gitignore, settings.Ignore)
+	maxFileSize := settings.MaxFileSize
+
+	filepath.Walk(self.root, func(path string, info os.FileInfo, err error) error { // TODO: handle err of filepath.Walk?
+		if path != self.root && (info.IsDir() || isSymlink(info)) && skippedFolder(info.Name(), settings.SkipDirs) {
+			if info.IsDir() {
 				return filepath.SkipDir
 			}
-			if info.Name() == generate.GeneratedFilename {
-				return nil
+			return nil
+		}
+		if info.IsDir() && info.Name() == "vendor" && path != self.root {
+			return filepath.SkipDir // vendored packages are dependencies (resolved by linkImports), not code under test.
+		}
+		if info.IsDir() && self.modular && isNestedModule(self.root, path) {
+			return filepath.SkipDir
+		}
+		if isGeneratedFile(info.Name()) {
+			return nil
+		}
+		if !info.IsDir() && maxFileSize > 0 && info.Size() > maxFileSize {
+			return nil // artifacts, databases, media, etc... aren't worth keeping track of.
+		}
+		if path != self.root && ignore.Ignored(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
+		}
+		if info.IsDir() {
+			ignore.Enter(path)
+			folders++
+		}
 
-			batch <- &File{
-				Path:         path,
-				ParentFolder: filepath.Dir(path), // does this get the parent of a dir?
-				IsFolder:     info.IsDir(),
-				Size:         info.Size(),
-				Modified:     info.ModTime().Unix(),
-				IsGoFile:     strings.HasSuffix(path, ".go"),
-				IsGoTestFile: strings.HasSuffix(path, "_test.go"),
-			}
+		batch <- &File{
+			Path:         path,
+			ParentFolder: filepath.Dir(path), // does this get the parent of a dir?
+			IsFolder:     info.IsDir(),
+			Size:         info.Size(),
+			Modified:     info.ModTime().Unix(),
+			IsGoFile:     strings.HasSuffix(path, ".go"),
+			IsGoTestFile: strings.HasSuffix(path, "_test.go"),
+		}
 
-			return nil
-		})
-		close(batch)
-		time.Sleep(time.Millisecond * 250)
-	}
+		return nil
+	})
+	return folders
 }
 
 //////////////////////////////////////////////////////////////////////////////////////
@@ -153,60 +693,198 @@ func (self *FileSystemScanner) ScanForever() {
 //////////////////////////////////////////////////////////////////////////////////////
 
 type Checksummer struct {
-	commands chan struct{}
-	reset    bool
+	root      string
+	config    *ConfigWatcher
+	generated *GeneratedFiles
+	commands  chan struct{}
+	sweeps    chan struct{} // see Sweeper
+	targets   chan string   // folders whose tests are to be run (see Input.command)
+	events    *FileEvents
+	network   *Network
+	idle      *Idle
+	metrics   *Metrics
+	reset     bool
+	sweeping  bool            // the reset is a sweep's (which isn't activity, see Idle)
+	skipNoops bool            // -skip-noop (see semanticChange)
+	since     map[string]bool // when set, only these files (or files in these folders) count as modified on the first pass
 
 	in  chan chan *File
 	out chan chan *File
 
-	state   int64
-	goFiles map[string]int64
+	state        int64
+	goFiles      map[string]int64
+	hints        map[string]int64               // the other files under the sources of [affects] rules, and the embedded ones
+	embeds       map[string]embedDirectives     // key: go file
+	fingerprints map[string]semanticFingerprint // key: go file (with -skip-noop)
+	stamps       map[string]int64               // the sizes and modification times of the go files and hints (see unsettled)
+	unsettled    int                            // the passes let go in a row, waiting for files to settle
+	mutex        sync.Mutex
+	requested    map[string]bool // folders (see targets)
 }
 
 func (self *Checksummer) RespondForevor() {
 	for {
-		<-self.commands
-		self.reset = true
+		select {
+		case <-self.commands:
+			self.reset = true
+		case <-self.sweeps:
+			self.reset, self.sweeping = true, true
+		case folder := <-self.targets:
+			self.mutex.Lock()
+			if self.requested == nil {
+				self.requested = map[string]bool{}
+			}
+			self.requested[folder] = true
+			self.mutex.Unlock()
+		}
 		time.Sleep(time.Millisecond)
 	}
 }
 
 func (self *Checksummer) ListenForever() {
 	self.goFiles = map[string]int64{}
+	self.hints = map[string]int64{}
 
 	for {
 		state := int64(0)
 		incoming := <-self.in
 		outgoing := []*File{}
 		goFiles := map[string]int64{}
-
+		hints := map[string]int64{}
+		fingerprints := map[string]semanticFingerprint{}
+		settings := self.config.Settings()
+		rules := settings.Affects
+		modified, generated, noop := false, false, false
+		contents := self.network.Enabled()
+		self.mutex.Lock()
+		requested := self.requested
+		self.requested = nil
+		self.mutex.Unlock()
+		injected := self.events.take()
+
+		track := func(file *File, previous, tracked map[string]int64) {
+			stamp := file.Size + file.Modified
+			fileChecksum := stamp
+			if contents { // (modification times can't be relied on)
+				fileChecksum = contentChecksum(file.Path)
+			}
+			state += fileChecksum
+			file.Checksum = fileChecksum
+			semantic := true
+			if self.skipNoops && file.IsGoFile {
+				semantic = self.semanticChange(file, fingerprints)
+			}
+			if self.since != nil {
+				file.IsModified = self.since[file.Path] || self.since[file.ParentFolder]
+			} else if checksum, found := previous[file.Path]; !found || checksum != fileChecksum {
+				file.IsModified = true
+				if found && !semantic && !self.reset {
+					logf("Skipped: %s changed, but not its code (-skip-noop).", relativePath(file.Path))
+					file.IsModified, noop = false, true
+				}
+			} else if self.reset { // the user has requested a re-run of all packages, so fake a modification.
+				file.IsModified = true
+			} else if requested[file.ParentFolder] && file.IsGoTestFile { // (just the tests, so nothing cascades)
+				file.IsModified = true
+			} else if injected[file.Path] {
+				file.IsModified = true
+			}
+			if file.IsModified && !self.reset && self.generated.Consume(file.Path, stamp) {
+				file.IsModified, generated = false, true // (written by go generate, during the last run)
+			}
+			modified = modified || file.IsModified
+			tracked[file.Path] = fileChecksum
+			outgoing = append(outgoing, file)
+		}
+		tracked, others := []*File{}, []*File{}
+		stamps := map[string]int64{}
+		changed := []*File{}                 // (see unsettled)
+		scanned, busy := 0, time.Duration(0) // (the scan streams its files, so the time between them is the scanner's)
 		for file := range incoming {
-			if !file.IsFolder && file.IsGoFile {
-				fileChecksum := file.Size + file.Modified
-				state += fileChecksum
-				if checksum, found := self.goFiles[file.Path]; !found || checksum != fileChecksum {
-					file.IsModified = true
-				} else if self.reset { // the user has requested a re-run of all packages, so fake a modification.
-					file.IsModified = true
+			scanned++
+			if file.IsFolder {
+				continue
+			}
+			began := time.Now()
+			if file.IsGoFile || isHint(rules, self.root, file.Path) {
+				tracked = append(tracked, file)
+				stamps[file.Path] = file.Size + file.Modified
+				if stamp, found := self.stamps[file.Path]; len(self.stamps) > 0 && (!found || stamp != stamps[file.Path]) {
+					changed = append(changed, file)
 				}
-				goFiles[file.Path] = fileChecksum
-				outgoing = append(outgoing, file)
+			} else {
+				others = append(others, file)
+			}
+			busy += time.Since(began)
+		}
+		if file := unsettled(changed, settings.Settle); file != nil && self.unsettled < maxUnsettledPasses {
+			self.unsettled++
+			logf("Waiting for %s to settle (it's still being written).", relativePath(file.Path))
+			self.mutex.Lock()
+			for folder := range requested {
+				if self.requested == nil {
+					self.requested = map[string]bool{}
+				}
+				self.requested[folder] = true
+			}
+			self.mutex.Unlock()
+			self.events.requeue(injected)
+			continue // (before anything is tracked, so that the next scan has another look)
+		} else if file != nil {
+			logf("%s keeps changing; testing it as it is.", relativePath(file.Path))
+		}
+		self.unsettled = 0
+		self.stamps = stamps
+
+		began := time.Now()
+		for _, file := range tracked {
+			if file.IsGoFile {
+				track(file, self.goFiles, goFiles)
+			} else {
+				track(file, self.hints, hints)
 			}
 		}
+		embedded := self.embedPatterns(outgoing)
+		for _, file := range others { // (once the patterns of every folder are known)
+			if folder := embeddingFolder(self.root, embedded, file.Path); folder != "" {
+				file.ParentFolder, file.IsEmbedded = folder, true
+				track(file, self.hints, hints)
+			}
+		}
+		moves := detectMoves(folderSignatures(self.goFiles), folderSignatures(goFiles))
+		for _, file := range outgoing {
+			file.MovedFrom = moves[file.ParentFolder]
+		}
 		self.goFiles = goFiles
+		self.hints = hints
+		self.fingerprints = fingerprints
+		self.since = nil
 
-		if state != self.state || self.reset {
-			fmt.Println("Running tests...")
+		if generated && !modified && len(moves) == 0 {
+			self.state = state // nothing changed but the output of go generate, which was just tested.
+		}
+		if noop && !modified && len(moves) == 0 {
+			self.state = state // (see semanticChange)
+		}
+		self.metrics.Observe("scan_files", float64(scanned))
+		self.metrics.Observe("checksum_seconds", (busy + time.Since(began)).Seconds())
+		if state != self.state || self.reset || len(requested) > 0 || len(injected) > 0 || len(moves) > 0 { // (moving files doesn't change the state)
+			if swept := self.sweeping && state == self.state && len(requested)+len(injected)+len(moves) == 0; !swept {
+				self.idle.Touch() // (a sweep alone isn't activity)
+			}
 			self.state = state
 			out := make(chan *File)
+			handoff := self.metrics.Handoff("checked-files")
 			self.out <- out
+			handoff()
+			self.metrics.Observe("checksum_files", float64(len(outgoing)))
 			for _, file := range outgoing {
 				out <- file
 			}
 			close(out)
 
 			if self.reset {
-				self.reset = false
+				self.reset, self.sweeping = false, false
 			}
 		}
 	}
@@ -220,6 +898,8 @@ type Package struct {
 	Info           *build.Package
 	IsModifiedTest bool
 	IsModifiedCode bool
+	ModifiedFiles  []string
+	MovedFrom      string // the previous folder of a package that was just moved (or renamed)
 	// arguments string
 }
 
@@ -228,36 +908,57 @@ type Package struct {
 //////////////////////////////////////////////////////////////////////////////////////
 
 type Packager struct {
+	metrics *Metrics
+
 	in  chan chan *File
 	out chan chan *Package
+
+	imported map[string]importedFolder // key: folder (see importDir)
+}
+
+// importedFolder is what build.ImportDir made of a folder, when its files had the
+// signature (see importDir).
+type importedFolder struct {
+	signature uint64
+	info      *build.Package
+	err       error
 }
 
 func (self *Packager) ListenForever() {
 	for {
 		incoming := <-self.in
 		packages := map[string]*Package{} // key: Folder path
-
+		folders := map[string][]*File{}
 		for file := range incoming {
-			pkg, found := packages[file.ParentFolder]
-			if !found {
-				pkg = &Package{}
-				var err error
-				pkg.Info, err = build.ImportDir(file.ParentFolder, build.AllowBinary)
-				if err != nil {
-					// TODO: Need to handle this. It happens when a .go file is blank (and doesn't have a package declaration)...
-					continue
-				}
-				packages[file.ParentFolder] = pkg
+			folders[file.ParentFolder] = append(folders[file.ParentFolder], file)
+		}
+		imported := map[string]importedFolder{}
+		started := time.Now()
+
+		for folder, files := range folders {
+			imported[folder] = self.importDir(folder, files)
+			info, err := imported[folder].info, imported[folder].err
+			if _, empty := err.(*build.NoGoError); empty && !hasGoFile(files) {
+				err = nil // a folder of other files (see AffectsRule), which is never tested itself.
 			}
-			if file.IsModified && file.IsGoTestFile {
-				pkg.IsModifiedTest = true
-			} else if file.IsModified && !file.IsGoTestFile && file.IsGoFile {
-				pkg.IsModifiedCode = true
+			if err != nil {
+				// TODO: Need to handle this. It happens when a .go file is blank (and doesn't have a package declaration)...
+				continue
+			}
+			pkg := &Package{Info: info}
+			packages[folder] = pkg
+			for _, file := range files {
+				self.add(pkg, file)
 			}
 		}
+		self.imported = imported
+		self.metrics.Since("package_seconds", started)
+		self.metrics.Observe("package_count", float64(len(packages)))
 
 		outgoing := make(chan *Package)
+		handoff := self.metrics.Handoff("packages")
 		self.out <- outgoing
+		handoff()
 		for _, pkg := range packages {
 			outgoing <- pkg
 		}
@@ -265,6 +966,43 @@ func (self *Packager) ListenForever() {
 	}
 }
 
+// importDir imports the folder, unless none of its files (or their checksums)
+// changed since the last batch, in which case the previous import still holds (and
+// the files aren't parsed all over again).
+func (self *Packager) importDir(folder string, files []*File) importedFolder {
+	hash := fnv.New64a()
+	for _, file := range files {
+		fmt.Fprintln(hash, file.Path, file.Checksum)
+	}
+	signature := hash.Sum64()
+	if previous, found := self.imported[folder]; found && previous.signature == signature {
+		return previous
+	}
+	info, err := build.ImportDir(folder, build.AllowBinary)
+	return importedFolder{signature: signature, info: info, err: err}
+}
+
+func hasGoFile(files []*File) bool {
+	for _, file := range files {
+		if file.IsGoFile {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Packager) add(pkg *Package, file *File) {
+	if file.IsModified && file.IsGoTestFile {
+		pkg.IsModifiedTest = true
+	} else if file.IsModified && !file.IsGoTestFile && (file.IsGoFile || file.IsEmbedded) {
+		pkg.IsModifiedCode = true
+	}
+	if file.IsModified {
+		pkg.ModifiedFiles = append(pkg.ModifiedFiles, file.Path)
+	}
+	pkg.MovedFrom = file.MovedFrom
+}
+
 //////////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////
@@ -278,40 +1016,97 @@ type Execution struct {
 //////////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////
 
+// Selection is the set of packages (by import path) to be tested, along with any
+// problems noticed while selecting them.
+type Selection struct {
+	Packages    map[string]bool
+	Modified    map[string]bool     // the packages with changed files (the rest were selected because they depend on those)
+	Tests       map[string][]string // the test functions to run, for packages where only they changed (the rest run everything)
+	Moved       map[string]string   // key: previous import path, value: current import path (of packages just moved or renamed)
+	Pending     []Pending           // packages held back by their rerun interval (see RerunLimiter)
+	Platforms   map[string][]Target // the other platforms to compile packages for, as files excluded here by build constraints changed
+	Triggers    []Trigger
+	Diagnostics []string
+	Selected    time.Time // when the selector made it (see Metrics)
+}
+
+// Trigger is a modified file (and its package) that caused a run.
+type Trigger struct {
+	File    string `json:"file"` // relative to the working directory, where possible
+	Package string `json:"package"`
+}
+
+func describeTriggers(triggers []Trigger) string {
+	const limit = 5
+	described := []string{}
+	for x, trigger := range triggers {
+		if x == limit {
+			described = append(described, fmt.Sprintf("and %d more", len(triggers)-limit))
+			break
+		}
+		described = append(described, fmt.Sprintf("%s (%s)", trigger.File, trigger.Package))
+	}
+	return strings.Join(described, ", ")
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
 type PackageSelector struct {
+	root      string
+	config    *ConfigWatcher
+	generated *GeneratedFiles
+	tests     *TestIndex
+	history   *History
+	tracer    *Tracer
+	metrics   *Metrics
+	rerun     map[string][]string // key: package, value: tests (see -rerun-fails; the first pass only)
+
 	in  chan chan *Package
-	out chan map[string]bool
+	out chan *Selection
+
+	problems    map[string]string // key: offending import (or cycle), value: problem (already reported)
+	inventoried bool
 }
 
 func (self *PackageSelector) ListenForever() {
+	self.problems = map[string]string{}
+
 	for {
 		incoming := <-self.in
+		started := time.Now()
 		executions := map[string]bool{}
+		modified := map[string]bool{}
 		cascade := map[string][]string{}
+		imports := map[string][]string{}
+		problems := map[string]string{}
 		all := []*Package{}
 
+		triggers := []Trigger{}
+
+		moved := map[string]string{}
+
 		for pkg := range incoming {
 			all = append(all, pkg)
-
-			for _, _import := range append(pkg.Info.Imports, pkg.Info.TestImports...) {
-				imported, err := build.Default.Import(_import, "", build.AllowBinary)
-				if err != nil || imported.Goroot {
-					continue
+			if pkg.MovedFrom != "" {
+				if previous := importPathOf(pkg.MovedFrom); previous != "" && previous != pkg.Info.ImportPath {
+					moved[previous] = pkg.Info.ImportPath
 				}
-				found := false
-				for _, already := range cascade[_import] {
-					if already == pkg.Info.ImportPath {
-						found = true
-					}
-				}
-				if !found {
-					cascade[_import] = append(cascade[_import], pkg.Info.ImportPath)
+				self.tests.Move(pkg.MovedFrom, pkg.Info.Dir)
+				triggers = append(triggers, Trigger{File: relativePath(pkg.MovedFrom) + " -> " + relativePath(pkg.Info.Dir), Package: pkg.Info.ImportPath})
+			} else {
+				for _, file := range pkg.ModifiedFiles {
+					triggers = append(triggers, Trigger{File: relativePath(file), Package: pkg.Info.ImportPath})
 				}
 			}
 
+			linkImports(pkg, cascade, imports, problems)
+
 			for _, pkg := range all {
 				if pkg.IsModifiedCode || pkg.IsModifiedTest {
 					executions[pkg.Info.ImportPath] = true
+					modified[pkg.Info.ImportPath] = true
 					if pkg.IsModifiedCode {
 						for _, upstream := range cascade[pkg.Info.ImportPath] {
 							executions[upstream] = true
@@ -321,8 +1116,179 @@ func (self *PackageSelector) ListenForever() {
 			}
 		}
 
-		self.out <- executions
+		if !self.inventoried {
+			for _, line := range takeInventory(self.root, all, self.config.Settings(), self.history).describe() {
+				logf("%s", line)
+			}
+			self.inventoried = true
+		}
+
+		mergeAffects(self.config.Settings().Affects, self.root, all, cascade)
+		for _, pkg := range all {
+			if pkg.IsModifiedCode || modifiedHints(pkg) {
+				for _, upstream := range cascade[pkg.Info.ImportPath] {
+					executions[upstream] = true
+				}
+			}
+		}
+
+		for _, cycle := range findImportCycles(imports) {
+			path := strings.Join(cycle, " -> ")
+			problems[path] = "Import cycle: " + path
+		}
+
+		sort.Slice(triggers, func(i, j int) bool { return triggers[i].File < triggers[j].File })
+		platforms := map[string][]Target{}
+		for _, pkg := range all {
+			targets, unmatched := excludedPlatforms(pkg)
+			if len(targets) > 0 {
+				platforms[pkg.Info.ImportPath] = targets
+			}
+			for _, path := range unmatched {
+				problems[path] = describeUnmatched(path)
+			}
+		}
+
+		selection := &Selection{Packages: executions, Modified: modified, Tests: self.narrow(all, cascade), Moved: moved, Triggers: triggers, Platforms: platforms}
+		if self.rerun != nil {
+			self.targetRerun(selection, all)
+		}
+		self.regenerateMocks(selection, all, cascade)
+		for key, problem := range problems {
+			if self.problems[key] != problem {
+				selection.Diagnostics = append(selection.Diagnostics, problem)
+			}
+		}
+		sort.Strings(selection.Diagnostics)
+		self.problems = problems
+
+		self.tracer.Selected(started)
+		self.metrics.Since("selection_seconds", started)
+		self.metrics.Observe("selection_packages", float64(len(selection.Packages)))
+		selection.Selected = time.Now()
+		handoff := self.metrics.Handoff("selections")
+		self.out <- selection
+		handoff()
+	}
+}
+
+// narrow finds the packages where nothing but test functions changed (and which
+// aren't tested anyway because a package they import changed).
+func (self *PackageSelector) narrow(all []*Package, cascade map[string][]string) map[string][]string {
+	cascaded := map[string]bool{}
+	for _, pkg := range all {
+		if pkg.IsModifiedCode {
+			for _, upstream := range cascade[pkg.Info.ImportPath] {
+				cascaded[upstream] = true
+			}
+		}
+	}
+	tests := map[string][]string{}
+	for _, pkg := range all {
+		narrowed, narrowable := []string{}, pkg.IsModifiedTest && !pkg.IsModifiedCode
+		for _, file := range pkg.ModifiedFiles {
+			if strings.HasSuffix(file, "_test.go") {
+				names, ok := self.tests.Changed(file) // always, to keep the index current
+				narrowed = append(narrowed, names...)
+				narrowable = narrowable && ok
+			}
+		}
+		if narrowable && len(narrowed) > 0 && !cascaded[pkg.Info.ImportPath] {
+			sort.Strings(narrowed)
+			tests[pkg.Info.ImportPath] = narrowed
+		}
+	}
+	return tests
+}
+
+// linkImports records the (non-standard library) imports of the package in both
+// directions, noting any import that can't be resolved in problems. Imports are
+// resolved from the package's folder, so that vendored copies are found as the go
+// command finds them in GOPATH mode (the vendor folders up the tree, within
+// $GOPATH/src). Module vendoring (-mod=vendor, vendor/modules.txt) isn't honoured:
+// with AllowBinary, go/build resolves imports the GOPATH way and never asks go list.
+func linkImports(pkg *Package, cascade, imports map[string][]string, problems map[string]string) {
+	for _, _import := range append(pkg.Info.Imports, pkg.Info.TestImports...) {
+		imported, err := build.Default.Import(_import, pkg.Info.Dir, build.AllowBinary)
+		if err != nil {
+			// Keep the edge anyway--the importing package still depends on whatever ends up at that path.
+			if _, found := problems[_import]; !found {
+				problems[_import] = fmt.Sprintf("Could not resolve %s (imported by %s): %s", _import, pkg.Info.ImportPath, err)
+			}
+		} else if imported.Goroot {
+			continue
+		}
+		imports[pkg.Info.ImportPath] = append(imports[pkg.Info.ImportPath], _import)
+		found := false
+		for _, already := range cascade[_import] {
+			if already == pkg.Info.ImportPath {
+				found = true
+			}
+		}
+		if !found {
+			cascade[_import] = append(cascade[_import], pkg.Info.ImportPath)
+		}
+	}
+}
+
+// findImportCycles reports each cycle in the graph once, rotated to start at its
+// smallest member (and closed by repeating it) so that it reads the same every time.
+func findImportCycles(imports map[string][]string) (cycles [][]string) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	stack := []string{}
+	seen := map[string]bool{}
+
+	var visit func(string)
+	visit = func(node string) {
+		state[node] = visiting
+		stack = append(stack, node)
+		for _, next := range imports[node] {
+			if _, local := imports[next]; !local {
+				continue
+			}
+			switch state[next] {
+			case unvisited:
+				visit(next)
+			case visiting:
+				start := len(stack) - 1
+				for stack[start] != next {
+					start--
+				}
+				cycle := append([]string{}, stack[start:]...)
+				smallest := 0
+				for x := range cycle {
+					if cycle[x] < cycle[smallest] {
+						smallest = x
+					}
+				}
+				cycle = append(cycle[smallest:], cycle[:smallest]...)
+				cycle = append(cycle, cycle[0])
+				if key := strings.Join(cycle, " "); !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, cycle)
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[node] = visited
+	}
+
+	nodes := []string{}
+	for node := range imports {
+		nodes = append(nodes, node)
 	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		if state[node] == unvisited {
+			visit(node)
+		}
+	}
+	return cycles
 }
 
 //////////////////////////////////////////////////////////////////////////////////////
@@ -330,16 +1296,37 @@ func (self *PackageSelector) ListenForever() {
 //////////////////////////////////////////////////////////////////////////////////////
 
 type Result struct {
-	PackageName string
-	Status      PackageStatus
-	Output      string
-	Failures    []string
+	PackageName   string
+	Module        string `json:",omitempty"`
+	Status        PackageStatus
+	Output        string
+	OutputFile    string `json:",omitempty"` // the whole output, when it was too large to keep (see OutputBuffer)
+	Failures      []string
+	Crashers      []string       `json:",omitempty"`
+	FailedTargets []string       `json:",omitempty"` // GOOS/GOARCH pairs (see -targets)
+	Narrowed      []string       `json:",omitempty"` // the only test functions run (see -narrow)
+	Baseline      string         `json:",omitempty"` // for failures: pre-existing or introduced (see -baseline)
+	LastGreen     *LastGreen     `json:",omitempty"` // for failures: where the package last passed
+	Hints         []string       `json:",omitempty"` // for failures: see HintRule
+	PatchCoverage *PatchCoverage `json:",omitempty"` // of the lines changed since scantest started (see -patch-coverage)
+	NewFailures   []string       `json:",omitempty"` // the failed tests that didn't fail (that way) on the previous run
+	StillFailing  []string       `json:",omitempty"` // the failed tests that failed the very same way on the previous run
+	Fixed         []string       `json:",omitempty"` // the tests that failed on the previous run, but passed this time
+	Duration      time.Duration
+	Finished      time.Time
+	CPUTime       time.Duration  `json:",omitempty"` // of the test process (and the processes it waited for)
+	MaxRSS        int64          `json:",omitempty"` // in bytes, the largest resident set of those processes (not reported on windows)
+	Generated     []string       `json:",omitempty"` // the files go generate changed (relative to the working directory, where possible)
+	Skipped       []parser.Skip  `json:",omitempty"`
+	NotRun        []parser.Skip  `json:",omitempty"` // the tests the run left out (see unexercisedTests)
+	Matrix        []MatrixResult `json:",omitempty"` // the outcome of each entry (see MatrixRule)
 }
 
 type PackageStatus int
 
 const (
-	GenerateFailed PackageStatus = iota
+	StaleGenerated PackageStatus = iota - 1 // the code gunit generated is out of date (see GeneratedFiles.Stale)
+	GenerateFailed
 	CompileFailed
 	TestsFailed
 	TestsPassed
@@ -347,110 +1334,592 @@ const (
 
 //////////////////////////////////////////////////////////////////////////////////////
 
-// ResultSet implements sort.Interface for []Person based on the result status and package name.
-type ResultSet []Result
+// ResultSet implements sort.Interface for []Result based on the keys of the sort
+// setting (see sortKeys), and then the package name.
+type ResultSet struct {
+	results []Result
+	order   []string
+}
 
-func (self ResultSet) Len() int      { return len(self) }
-func (self ResultSet) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self ResultSet) Len() int { return len(self.results) }
+func (self ResultSet) Swap(i, j int) {
+	self.results[i], self.results[j] = self.results[j], self.results[i]
+}
 func (self ResultSet) Less(i, j int) bool {
-	if self[i].Status == self[j].Status {
-		return self[i].PackageName[0] < self[j].PackageName[0]
+	for _, key := range self.order {
+		if comparison := compareResults(key, self.results[i], self.results[j]); comparison != 0 {
+			return comparison < 0
+		}
 	}
-	return self[i].Status < self[j].Status
+	return self.results[i].PackageName < self.results[j].PackageName
 }
 
 //////////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////
 
+// Report is the outcome of a run: a result per tested package and any diagnostics
+// that came up along the way.
+type Report struct {
+	Results     []Result
+	Triggers    []Trigger
+	Diagnostics []string
+	Git         *GitState // nil outside of a git repository
+	Pending     []Pending
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const stopTimeout = 3 * time.Second // see Runner.Stop
+
 type Runner struct {
-	in  chan map[string]bool
-	out chan []Result
+	interrupt      bool
+	config         *ConfigWatcher
+	throttle       *Throttle
+	fuzz           time.Duration
+	targets        []Target
+	history        *History
+	sqlite         *SQLiteSink
+	fingerprints   *Fingerprints
+	status         *StatusFile
+	screen         *Screen
+	stream         *Multiplexer // -stream
+	tracer         *Tracer      // -trace
+	metrics        *Metrics
+	resident       *Resident     // -resident
+	patch          *PatchCoverer // -patch-coverage
+	warmer         *Warmer       // -warm
+	modules        *ModuleFixer  // -mod-fix
+	quiet          bool          // see retry
+	removeOrphaned bool          // see removeOrphaned
+	raw            bool          // -raw (no banner)
+	buildUntested  bool
+	examples       bool
+	warnSkips      bool
+	protocol       *Protocol // -web
+	baseline       *Baseline
+	generated      *GeneratedFiles
+
+	in  chan *Selection
+	out chan *Report
+
+	mutex   sync.Mutex
+	cancel  context.CancelFunc // of the run in flight (see Stop)
+	stopped bool
+	running sync.WaitGroup
 }
 
 func (self *Runner) ListenForever() {
+	if !self.interrupt {
+		for selection := range self.in {
+			self.migrate(selection)
+			self.cooldown(selection)
+			git := currentGitState()
+			ctx, finish := self.begin()
+			results := self.run(ctx, selection)
+			finish()
+			if err := self.tracer.Write(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			self.fingerprints.Compare(results)
+			self.history.Record(results, git)
+			self.sqlite.Record(results, git)
+			handoff := self.metrics.Handoff("reports")
+			self.out <- &Report{Results: results, Triggers: selection.Triggers, Diagnostics: selection.Diagnostics, Git: git, Pending: selection.Pending}
+			handoff()
+		}
+		return
+	}
+
+	pending := <-self.in
+	self.migrate(pending)
 	for {
-		results := []Result{}
-		for packageName, _ := range <-self.in {
-			result := Result{PackageName: packageName}
-			generate := exec.Command("go", "generate", "-x", packageName)
-			output, err := generate.CombinedOutput()
-			if !generate.ProcessState.Success() {
-				result.Status = GenerateFailed
-				result.Output = string(output) + "\n" + err.Error()
-				results = append(results, result)
+		ctx, finish := self.begin()
+		git := currentGitState()
+		done := make(chan []Result, 1)
+		self.cooldown(pending)
+		go func(selection *Selection) {
+			results := self.run(ctx, selection)
+			finish()
+			done <- results
+		}(pending)
+
+		select {
+		case results := <-done:
+			if err := self.tracer.Write(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			self.fingerprints.Compare(results)
+			self.history.Record(results, git)
+			self.sqlite.Record(results, git)
+			handoff := self.metrics.Handoff("reports")
+			self.out <- &Report{Results: results, Triggers: pending.Triggers, Diagnostics: pending.Diagnostics, Git: git, Pending: pending.Pending}
+			handoff()
+			pending = <-self.in
+			self.migrate(pending)
+		case newer := <-self.in: // the in-flight run is obsolete, so kill it and start over (including whatever it didn't finish).
+			self.mutex.Lock()
+			self.cancel()
+			self.mutex.Unlock()
+			<-done
+			self.migrate(newer)
+			mergeSelection(pending, newer)
+		}
+	}
+}
+
+// begin gives a run its context, which Stop (or, with -interrupt, a newer selection)
+// cancels; finish is called once the run is over.
+func (self *Runner) begin() (ctx context.Context, finish func()) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	self.cancel = cancel
+	if self.stopped {
+		cancel()
+		return ctx, func() {}
+	}
+	self.running.Add(1)
+	return ctx, func() {
+		cancel()
+		self.running.Done()
+	}
+}
+
+// Stop cancels the run in flight, which kills the process groups of its commands (see
+// newCommand: they aren't in the terminal's, so they'd outlive scantest otherwise),
+// and waits for it to wind down (for a few seconds at most). No run starts after
+// that. A nil Runner stops nothing.
+func (self *Runner) Stop() {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	self.stopped = true
+	if self.cancel != nil {
+		self.cancel()
+	}
+	self.mutex.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		self.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(stopTimeout):
+	}
+}
+
+// mergeSelection folds the newer selection into the pending one (which then covers both).
+func mergeSelection(pending, newer *Selection) {
+	pending.Tests = mergeTests(pending, newer)
+	for previous := range newer.Moved { // gone, so there's nothing left to test there.
+		delete(pending.Packages, previous)
+		delete(pending.Modified, previous)
+		delete(pending.Tests, previous)
+	}
+	for packageName := range newer.Packages {
+		pending.Packages[packageName] = true
+	}
+	for packageName := range newer.Modified {
+		pending.Modified[packageName] = true
+	}
+	for packageName, targets := range newer.Platforms {
+		if pending.Platforms == nil {
+			pending.Platforms = map[string][]Target{}
+		}
+		pending.Platforms[packageName] = mergeTargets(pending.Platforms[packageName], targets)
+	}
+	for previous, current := range newer.Moved {
+		if pending.Moved == nil {
+			pending.Moved = map[string]string{}
+		}
+		pending.Moved[previous] = current
+	}
+	pending.Triggers = append(pending.Triggers, newer.Triggers...)
+	pending.Diagnostics = append(pending.Diagnostics, newer.Diagnostics...)
+	pending.Pending = newer.Pending
+}
+
+// cooldown leaves out the packages that failed the very same way in the last runs
+// (see the cooldown setting) and were selected only because of their dependencies,
+// to save the time of a known-broken (and long) suite while working elsewhere.
+// Changing the package's own files (or running everything) tests it again.
+func (self *Runner) cooldown(selection *Selection) {
+	limit := self.config.Settings().Cooldown
+	if limit == 0 {
+		return
+	}
+	cooling := []string{}
+	for packageName := range selection.Packages {
+		if streak := self.fingerprints.Streak(packageName); streak >= limit && !selection.Modified[packageName] {
+			delete(selection.Packages, packageName)
+			cooling = append(cooling, packageName)
+		}
+	}
+	if len(cooling) > 0 {
+		sort.Strings(cooling)
+		selection.Diagnostics = append(selection.Diagnostics, fmt.Sprintf(
+			"Cooling down (failed the very same way %d+ runs in a row; tested again once their own files change): %s", limit, strings.Join(cooling, ", ")))
+	}
+}
+
+// migrate carries the history of moved packages over to their new import paths.
+func (self *Runner) migrate(selection *Selection) {
+	for previous, current := range selection.Moved {
+		self.history.Rename(previous, current)
+	}
+}
+
+// mergeTests combines the narrowed tests of the selections (a package narrowed in
+// one but tested in full by the other is tested in full).
+func mergeTests(selections ...*Selection) map[string][]string {
+	full := map[string]bool{}
+	narrowed := map[string]map[string]bool{}
+	for _, selection := range selections {
+		for packageName := range selection.Packages {
+			if len(selection.Tests[packageName]) == 0 {
+				full[packageName] = true
 				continue
 			}
+			if narrowed[packageName] == nil {
+				narrowed[packageName] = map[string]bool{}
+			}
+			for _, test := range selection.Tests[packageName] {
+				narrowed[packageName][test] = true
+			}
+		}
+	}
+	merged := map[string][]string{}
+	for packageName, tests := range narrowed {
+		if full[packageName] {
+			continue
+		}
+		for test := range tests {
+			merged[packageName] = append(merged[packageName], test)
+		}
+		sort.Strings(merged[packageName])
+	}
+	return merged
+}
 
-			var missingDirective bool
-			pkg, err := build.Default.Import(packageName, "", build.AllowBinary)
-			for _, i := range pkg.TestImports {
-				if i == "github.com/smartystreets/gunit" && !strings.Contains(string(output), "gunit") {
-					result.Status = GenerateFailed
-					result.Output = packageName + " imports gunit but is missing a go generate directive to invoke the gunit command (`//go:generate gunit`)..."
+func (self *Runner) run(ctx context.Context, selection *Selection) []Result {
+	self.warmer.RunStarted()
+	if !selection.Selected.IsZero() {
+		self.metrics.Since("dispatch_seconds", selection.Selected)
+	}
+	started := time.Now()
+	settings := self.config.Settings()
+	settings.Parallel = self.throttle.Parallel(settings.Parallel)
+	queue, predicted := schedule(self.history, selection.Packages, settings.Parallel)
+	if err := settings.Remote.syncFor(ctx, queue); err != nil {
+		logf("Remote: couldn't sync, so this run is tested here: %s", err)
+		settings.Remote = RemoteSettings{}
+	}
+	details := []string{}
+	if predicted > 0 {
+		details = append(details, fmt.Sprintf("%s, ~%s predicted", countPackages(len(queue)), predicted.Round(time.Second/10)))
+	}
+	if state := self.throttle.State(); state != "" {
+		details = append(details, "throttled: "+state)
+	}
+	self.screen.Start(len(queue))
+	self.stream.Start(queue)
+	self.tracer.Begin()
+	banner := "Running tests..."
+	if len(details) > 0 {
+		banner += " (" + strings.Join(details, "; ") + ")"
+	}
+	if len(selection.Triggers) > 0 {
+		banner += " triggered by: " + describeTriggers(selection.Triggers)
+	}
+	self.protocol.Send(Message{Type: messageRunStart, Banner: banner, Packages: queue, Predicted: predicted, Triggers: selection.Triggers})
+	if self.protocol.Console() && !self.raw {
+		fmt.Println(banner)
+	}
+	progress := NewProgress(self.history, queue, settings.Parallel)
+	self.status.Running(progress.Current())
+	report := func(current RunProgress) {
+		self.screen.Progress(current)
+		self.status.Progress(current)
+	}
+	finished := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-finished:
+				return
+			case <-ticker.C:
+				report(progress.Current())
+			}
+		}
+	}()
+	defer close(finished)
+
+	var (
+		results = []Result{}
+		mutex   sync.Mutex
+		waiter  sync.WaitGroup
+		jobs    = make(chan string)
+	)
+	redactor := NewRedactor(settings.Redact)
+	workers := settings.Parallel
+	if len(queue) < workers {
+		workers = len(queue)
+	}
+	for x := 0; x < workers || x == 0; x++ {
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			for packageName := range jobs {
+				settings := settings.tune(packageName, workers)
+				testArgs := settings.TestArgs
+				tests := selection.Tests[packageName]
+				if len(tests) > 0 && !hasArgument(testArgs, "run") {
+					testArgs = append(append([]string{}, testArgs...), narrowedRun(tests, self.examples))
+				} else {
+					tests = nil
+				}
+				progress.Start(packageName)
+				self.metrics.Since("queue_wait_seconds", started)
+				var result Result
+				var ok bool
+				if entries := settings.matrix(packageName); len(entries) > 0 {
+					result, ok = self.testMatrix(ctx, packageName, selection.Modified[packageName], selection.Platforms[packageName], testArgs, settings, entries)
+				} else {
+					result, ok = self.test(ctx, packageName, selection.Modified[packageName], selection.Platforms[packageName], testArgs, settings)
+				}
+				if ok {
+					result = redactor.result(result)
+					result.Narrowed = tests
+					result.Baseline = self.baseline.Compare(result)
+					result.LastGreen = self.history.LastGreen(result)
+					result.Hints = settings.hints(result)
+					self.protocol.Send(Message{Type: messagePackageResult, Result: &result})
+					current := progress.Done(packageName)
+					self.protocol.Send(Message{Type: messageProgress, Progress: &current, Text: current.String()})
+					report(current)
+					mutex.Lock()
 					results = append(results, result)
-					missingDirective = true
+					mutex.Unlock()
 				}
 			}
-			if missingDirective {
-				continue
-			}
+		}()
+	}
+	for _, packageName := range queue {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- packageName
+	}
+	close(jobs)
+	waiter.Wait()
+	return results
+}
+
+// test generates, validates and tests a single package (fuzzing it too, if enabled and the package was modified).
+// It reports false if ctx was cancelled in the meantime.
+func (self *Runner) test(ctx context.Context, packageName string, modified bool, platforms []Target, testArgs []string, settings Settings) (result Result, ok bool) {
+	started := time.Now()
+	defer func() {
+		result.Finished = time.Now()
+		result.Duration = result.Finished.Sub(started)
+	}()
+
+	result.PackageName = packageName
+	folder := ""
+	if found, err := build.Default.Import(packageName, "", build.FindOnly); err == nil {
+		result.Module = findModule(found.Dir)
+		folder = found.Dir
+	}
+	environment := settings.Environ()
+	generateOutput, cached := self.generated.Cached(folder)
+	if cached && self.generated.Stale(folder) != "" {
+		cached = false // (gunit has to run again)
+	}
+	if !cached {
+		phase := time.Now()
+		generate := settings.Background.apply(newCommand(ctx, "go", "generate", "-x", packageName))
+		generate.Env = environment
+		var output []byte
+		generated, err := self.generated.Generate(folder, func() (err error) {
+			output, err = generate.CombinedOutput()
+			return err
+		})
+		if ctx.Err() != nil {
+			return result, false
+		}
+		self.tracer.Span(packageName, "generate", phase)
+		for _, path := range generated {
+			result.Generated = append(result.Generated, relativePath(path))
+		}
+		if !generate.ProcessState.Success() {
+			result.Status = GenerateFailed
+			result.Output = string(output) + "\n" + err.Error()
+			return result, true
+		}
+		generateOutput = string(output)
+		self.generated.Remember(folder, generateOutput)
+	}
+	if problem := self.generated.Stale(folder); problem != "" {
+		result.Status = StaleGenerated
+		result.Output = problem
+		return result, true
+	}
+	if problem := removeOrphaned(folder, self.removeOrphaned); problem != "" {
+		result.Status = StaleGenerated
+		result.Output = problem
+		return result, true
+	}
 
-			command := exec.Command("go", "test", "-v", packageName) // TODO: profiles
-			output, err = command.CombinedOutput()
-			result.Output = string(output)
+	pkg, _ := build.Default.Import(packageName, "", build.AllowBinary)
+	for _, rule := range settings.Validators {
+		phase := time.Now()
+		problem := rule.validator(environment).Validate(ctx, pkg, generateOutput)
+		if ctx.Err() != nil {
+			return result, false
+		}
+		self.tracer.Span(packageName, "validate", phase)
+		if problem != "" {
+			result.Status = GenerateFailed
+			result.Output = problem
+			return result, true
+		}
+	}
 
-			// http://stackoverflow.com/questions/10385551/get-exit-code-go
-			if err == nil { // if exit code is 0: the tests executed and passed.
-				result.Status = TestsPassed
-			} else if exit, ok := err.(*exec.ExitError); ok {
-				if status, ok := exit.Sys().(syscall.WaitStatus); ok {
+	if self.buildUntested && pkg != nil && len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) == 0 {
+		return self.build(ctx, packageName, environment, result)
+	}
 
-					if status.ExitStatus() == 1 { // if exit code is 1: we tests failed or panicked.
-						result.Status = TestsFailed
-						result.Failures = parseFailures(result)
-					} else if status.ExitStatus() > 1 { // if exit code is > 1: we failed to build and tests were not run.
-						result.Status = CompileFailed
-					}
-				}
+	phase := time.Now()
+	if len(result.Generated) > 0 && settings.Remote.covers(packageName) {
+		if err := settings.Remote.sync(ctx); err != nil { // (for the files go generate just wrote)
+			logf("Remote: couldn't sync the generated files: %s", err)
+		}
+	}
+	changes, profile := self.patch.Changes(folder), ""
+	if len(changes) > 0 && !settings.Remote.covers(packageName) && !hasArgument(testArgs, "coverprofile") {
+		profile = self.patch.Profile(packageName)
+		testArgs = append(append([]string{}, testArgs...), "-coverprofile="+profile)
+	}
+	command := settings.testCommand(ctx, packageName, testArgs, !self.quiet)
+	var resident *exec.Cmd
+	if !settings.Remote.covers(packageName) { // (the binaries are kept here)
+		resident = self.resident.Command(ctx, pkg, testArgs, !self.quiet, settings)
+	}
+	if resident != nil {
+		command = resident
+	}
+	command.Env = environment
+	output, spilled, err := self.combinedOutput(command, packageName)
+	result.OutputFile = spilled
+	if ctx.Err() != nil {
+		return result, false
+	}
+	if resident != nil {
+		output = self.resident.Summarize(output, packageName, time.Since(phase), err)
+	}
+	self.tracer.Span(packageName, "test", phase)
+	measureUsage(&result, command.ProcessState)
+	if self.quiet && exitCode(err) == 1 {
+		phase = time.Now()
+		if output, err = self.retry(ctx, packageName, testArgs, settings, output, err, &result); ctx.Err() != nil {
+			return result, false
+		}
+		self.tracer.Span(packageName, "retry", phase)
+	}
+	result.Output = string(output)
+	if profile != "" {
+		result.PatchCoverage = self.patch.Measure(profile, folder, changes)
+	}
+	result.Skipped = parser.Skips(result.Output)
+	if self.warnSkips {
+		markUnexpectedSkips(result.Skipped, settings.ExpectedSkips)
+	}
+	if err == nil || exitCode(err) == 1 {
+		result.NotRun = unexercisedTests(pkg, testArgs, settings, environment, result.Skipped)
+	}
+
+	// http://stackoverflow.com/questions/10385551/get-exit-code-go
+	code := exitCode(err)
+	if resident != nil {
+		code = self.resident.ExitCode(code, output)
+	}
+	_, exited := err.(*exec.ExitError)
+	if err == nil { // if exit code is 0: the tests executed and passed.
+		result.Status = TestsPassed
+	} else if exited {
+		if code == 1 { // if exit code is 1: we tests failed or panicked.
+			result.Status = TestsFailed
+			result.Failures = []string{}
+			for _, failure := range parser.Parse(result.Output) {
+				result.Failures = append(result.Failures, describeFailure(failure))
 			}
+		} else if code > 1 { // if exit code is > 1: we failed to build and tests were not run.
+			result.Status = CompileFailed
+		}
+	}
+	settings.packageRule(packageName).classify(&result, code, err == nil || exited)
 
-			results = append(results, result)
+	if result.Status == CompileFailed || result.Status == TestsFailed { // (go test exits 1 when the setup fails)
+		note, retry := self.modules.Fix(ctx, packageName, folder, result.Output, settings)
+		if ctx.Err() != nil {
+			return result, false
+		}
+		if retry {
+			result, ok = self.test(ctx, packageName, modified, platforms, testArgs, settings)
+		}
+		result.Output = note + result.Output
+		if retry {
+			return result, ok
 		}
-		self.out <- results
 	}
-}
 
-func parseFailures(result Result) []string {
-	failures := []string{}
-	if result.Status != TestsFailed {
-		return failures
+	enforcePolicy(&result, settings)
+
+	if targets := mergeTargets(self.targets, platforms); result.Status >= TestsFailed && len(targets) > 0 {
+		phase = time.Now()
+		if !self.crossCompile(ctx, packageName, targets, &result) {
+			return result, false
+		}
+		self.tracer.Span(packageName, "cross-compile", phase)
 	}
-	buffer := new(bytes.Buffer)
-	reader := strings.NewReader(result.Output)
-	scanner := bufio.NewScanner(reader)
-	var passed bool
 
-	for scanner.Scan() {
-		line := scanner.Text() + "\n"
-		if strings.HasPrefix(line, "=== RUN Test") {
-			if buffer.Len() > 0 && !passed {
-				failures = append(failures, buffer.String())
-			}
-			buffer = new(bytes.Buffer)
-			buffer.WriteString(line)
-		} else if strings.HasPrefix(line, "FAIL") { // the package report at the end
-			failures = append(failures, buffer.String())
-		} else if strings.HasPrefix(line, "--- PASS: Test") {
-			passed = true
-		} else if strings.HasPrefix(line, "--- FAIL: Test") {
-			buffer.WriteString(line)
-			passed = false
-		} else {
-			buffer.WriteString(line)
+	if result.Status == TestsPassed && self.fuzz > 0 && modified {
+		phase = time.Now()
+		if !self.fuzzPackage(ctx, pkg.Dir, &result) {
+			return result, false
 		}
+		self.tracer.Span(packageName, "fuzz", phase)
 	}
-	return failures
+
+	return result, true
+}
+
+// build compiles a package without tests (ie. a command or a tool) in place of testing
+// it, so that breaking it shows up as well (see -build-untested).
+func (self *Runner) build(ctx context.Context, packageName string, environment []string, result Result) (Result, bool) {
+	defer self.tracer.Span(packageName, "build", time.Now())
+	command := self.config.Settings().Background.apply(newCommand(ctx, "go", "build", "-o", os.DevNull, packageName))
+	command.Env = environment
+	output, err := command.CombinedOutput()
+	if ctx.Err() != nil {
+		return result, false
+	}
+	measureUsage(&result, command.ProcessState)
+	if err != nil {
+		result.Status = CompileFailed
+		result.Output = string(output) + "\n" + err.Error()
+	} else {
+		result.Status = TestsPassed
+		result.Output = fmt.Sprintf("ok  \t%s\t[built, no test files]\n", packageName)
+	}
+	return result, true
 }
 
 //////////////////////////////////////////////////////////////////////////////////////
@@ -458,44 +1927,203 @@ func parseFailures(result Result) []string {
 //////////////////////////////////////////////////////////////////////////////////////
 
 type Printer struct {
-	web bool
-	in  chan []Result
+	config    *ConfigWatcher
+	protocol  *Protocol // -web
+	format    string
+	html      *HTMLReporter
+	sarif     *SARIFWriter
+	rerun     *RerunFailsReport
+	bisect    *BisectScripts
+	search    *Search
+	snapshots *Snapshots
+	notifier  *Notifier
+	status    *StatusFile
+	screen    *Screen
+	links     *Hyperlinker
+	lock      *Lock
+	once      bool
+	fold      bool // see highlight
+	raw       bool // -raw
+	plain     bool // -plain
+	bell      bool // -bell
+	in        chan *Report
 }
 
 func (self *Printer) ListenForever() {
-	for resultSet := range self.in {
-		sort.Sort(ResultSet(resultSet))
-		if self.web {
-			self.json(resultSet)
-		} else {
-			self.console(resultSet)
+	for report := range self.in {
+		if self.raw && self.protocol.Console() {
+			self.rawOutput(report) // (in the order the packages finished, so before sorting)
+		}
+		sort.Sort(ResultSet{results: report.Results, order: self.config.Settings().Sort})
+		self.json(report)
+		if self.protocol.Console() && !self.raw {
+			self.console(report)
+		}
+		if self.bell {
+			ring(report.Results)
+		}
+		self.status.Finished(report.Results)
+		self.screen.Finish(report.Results)
+		self.search.Remember(report.Results)
+		self.notifier.Notify(report)
+		if self.html != nil {
+			if err := self.html.Write(report); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		if self.sarif != nil {
+			if err := self.sarif.Write(report); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		if self.rerun != nil {
+			if err := self.rerun.Write(report); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		if err := self.bisect.Write(report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if self.once {
+			self.notifier.Wait()
+			self.lock.Release()
+			self.screen.Close()
+			os.Exit(exitStatus(report.Results))
 		}
 	}
 }
 
-func (self *Printer) console(resultSet []Result) {
-	const (
-		red   = "\033[31m"
-		green = "\033[32m"
-		reset = "\033[0m"
-	)
+func exitStatus(results []Result) int {
+	for _, result := range results {
+		if result.Status != TestsPassed {
+			return 1
+		}
+	}
+	return 0
+}
+
+// rawOutput prints the output of each package as go test printed it (see -raw).
+func (self *Printer) rawOutput(report *Report) {
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+	for _, result := range report.Results {
+		if result.OutputFile != "" {
+			if err := copyOutput(writer, result); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			continue
+		}
+		output := result.Output
+		if output != "" && !strings.HasSuffix(output, "\n") {
+			output += "\n"
+		}
+		fmt.Fprint(writer, output)
+	}
+}
+
+func (self *Printer) console(report *Report) {
+	resultSet := report.Results
 	writer := bufio.NewWriter(os.Stdout)
 	defer writer.Flush()
 
 	failed := false
+	printResults := func(module string, grouped bool) {
+		for x := len(resultSet) - 1; x >= 0; x-- {
+			result := resultSet[x]
+			if grouped && result.Module != module {
+				continue
+			}
+			base := ""
+			if result.Status < TestsPassed {
+				failed, base = true, red
+				fmt.Fprint(writer, red)
+			}
+			if self.plain {
+				fmt.Fprint(writer, describeVerdict(result)+" ")
+			}
+			if len(result.Narrowed) > 0 {
+				fmt.Fprintf(writer, "%s (only %s)%s%s%s%s\n", result.PackageName, strings.Join(result.Narrowed, ", "), describeTiming(result), describeTrend(result), describeBaseline(result), describeLastGreen(result))
+			} else {
+				fmt.Fprintln(writer, result.PackageName+describeTiming(result)+describeTrend(result)+describeBaseline(result)+describeLastGreen(result))
+			}
+			if len(result.Generated) > 0 {
+				fmt.Fprintln(writer, "go generate changed:", strings.Join(result.Generated, ", "))
+			}
+			for _, line := range describeMatrix(result.Matrix) {
+				fmt.Fprintln(writer, "  "+line)
+			}
+			if coverage := result.PatchCoverage.String(); coverage != "" {
+				fmt.Fprintln(writer, coverage)
+			}
+			fmt.Fprintln(writer, highlight(self.links.Link(result.Output, result.PackageName), base, self.fold))
+			printHints(writer, result, base)
+			if len(result.Skipped) > 0 {
+				fmt.Fprintln(writer, dim+describeSkips(result.Skipped)+reset+base)
+			}
+			for _, skip := range result.Skipped {
+				if skip.Unexpected {
+					fmt.Fprintf(writer, "%sUnexpected skip: %s (%s)%s%s\n", yellow, skip.Test, skip.Reason, reset, base)
+				}
+			}
+			if len(result.NotRun) > 0 {
+				fmt.Fprintln(writer, dim+describeNotRun(result.NotRun)+reset+base)
+			}
+			fmt.Fprintln(writer, reset)
+			fmt.Fprintln(writer)
+		}
+	}
 
-	for x := len(resultSet) - 1; x >= 0; x-- {
-		result := resultSet[x]
-		if result.Status < TestsPassed {
-			failed = true
+	modules := summarizeModules(resultSet)
+	if self.format == formatDots || self.format == formatPkgname {
+		failed = self.compact(writer, report)
+		modules = nil
+	} else if self.format == formatEmacs {
+		failed = self.emacs(writer, report)
+		modules = nil
+	} else if len(modules) == 0 {
+		printResults("", false)
+	}
+	for _, module := range modules {
+		if module.Failed > 0 {
 			fmt.Fprint(writer, red)
+		} else {
+			fmt.Fprint(writer, green)
 		}
-		fmt.Fprintln(writer, result.PackageName)
-		fmt.Fprintln(writer, result.Output)
+		fmt.Fprintf(writer, "=== MODULE %s (%d passed, %d failed)\n", module.Module, module.Passed, module.Failed)
 		fmt.Fprintln(writer, reset)
-		fmt.Fprintln(writer)
+		printResults(module.Module, true)
 	}
 
+	if len(report.Diagnostics) > 0 {
+		fmt.Fprint(writer, yellow)
+		fmt.Fprintln(writer, "Diagnostics:")
+		for _, diagnostic := range report.Diagnostics {
+			fmt.Fprintln(writer, "  "+diagnostic)
+		}
+		fmt.Fprintln(writer, reset)
+	}
+
+	if len(report.Pending) > 0 {
+		fmt.Fprint(writer, yellow)
+		fmt.Fprintln(writer, "Pending (rerun interval):", describePending(report.Pending))
+		fmt.Fprintln(writer, reset)
+	}
+
+	if summary := summarizeTrends(resultSet); summary != "" {
+		fmt.Fprintln(writer, summary)
+	}
+	if summary := summarizeSkips(resultSet); summary != "" {
+		fmt.Fprintln(writer, summary)
+	}
+	if summary := summarizeNotRun(resultSet); summary != "" {
+		fmt.Fprintln(writer, summary)
+	}
+
+	if self.plain || self.format == formatEmacs {
+		fmt.Fprintln(writer, summarizeRun(resultSet))
+		fmt.Fprintln(writer)
+		return
+	}
 	if failed {
 		fmt.Fprint(writer, red)
 	} else {
@@ -506,20 +2134,32 @@ func (self *Printer) console(resultSet []Result) {
 }
 
 type JSONResult struct {
-	Packages []Result `json:"packages"`
+	Packages    []Result        `json:"packages"`
+	Modules     []ModuleSummary `json:"modules,omitempty"` // only when the results span several modules
+	Triggers    []Trigger       `json:"triggers,omitempty"`
+	Diagnostics []string        `json:"diagnostics,omitempty"`
+	Git         *GitState       `json:"git,omitempty"` // the state of the code that was tested
+	Pending     []Pending       `json:"pending,omitempty"`
 }
 
-func (self *Printer) json(resultSet []Result) {
-	result := JSONResult{Packages: resultSet}
-	raw, err := json.Marshal(result)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1) // TODO: maybe send a web socket message that indicates the UI of the crash...
-	} else {
-		fmt.Println(string(raw))
+// newJSONResult is the run of the run-end message (and what results plugins receive).
+func newJSONResult(report *Report) JSONResult {
+	return JSONResult{
+		Packages:    report.Results,
+		Modules:     summarizeModules(report.Results),
+		Triggers:    report.Triggers,
+		Diagnostics: report.Diagnostics,
+		Git:         report.Git,
+		Pending:     report.Pending,
 	}
 }
 
+func (self *Printer) json(report *Report) {
+	result := newJSONResult(report)
+	self.snapshots.Remember(result)
+	self.protocol.Send(Message{Type: messageRunEnd, Run: &result})
+}
+
 //////////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////
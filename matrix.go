@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// MatrixRule has the packages matching a pattern (an import path, where "..."
+// matches anything) tested once per combination of the values of its variables
+// (the [matrix.<pattern>] tables of .scantest.toml), ie. a data-store package
+// against every backend. Variables go to the environment, except for those named
+// like flags, which are given to go test (ie. test seeds):
+//
+//	[matrix."example.com/app/store/..."]
+//	DB = ["postgres", "mysql"]
+//	-shuffle = ["1", "2"]
+type MatrixRule struct {
+	Pattern   string
+	Variables map[string][]string
+}
+
+type MatrixEntry struct {
+	Label string // ie. "DB=postgres -shuffle=1"
+	Env   map[string]string
+	Args  []string
+}
+
+// matrix lists the entries the package is tested with (none when no rule matches
+// it), the most specific rule winning.
+func (self Settings) matrix(packageName string) (entries []MatrixEntry) {
+	rule := MatrixRule{}
+	for _, candidate := range self.Matrix {
+		if len(candidate.Pattern) > len(rule.Pattern) && matchesPattern(candidate.Pattern, packageName) {
+			rule = candidate
+		}
+	}
+	names := []string{}
+	for name := range rule.Variables {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { // (flags last)
+		if flag := strings.HasPrefix(names[i], "-"); flag != strings.HasPrefix(names[j], "-") {
+			return !flag
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		if len(rule.Variables[name]) == 0 {
+			continue
+		}
+		if len(entries) == 0 {
+			entries = []MatrixEntry{{}}
+		}
+		combined := []MatrixEntry{}
+		for _, entry := range entries {
+			for _, value := range rule.Variables[name] {
+				combined = append(combined, entry.with(name, value))
+			}
+		}
+		entries = combined
+	}
+	return entries
+}
+
+func (self MatrixEntry) with(name, value string) MatrixEntry {
+	entry := MatrixEntry{Label: strings.TrimSpace(self.Label + " " + name + "=" + value), Env: map[string]string{}, Args: append([]string{}, self.Args...)}
+	for key, value := range self.Env {
+		entry.Env[key] = value
+	}
+	if strings.HasPrefix(name, "-") {
+		entry.Args = append(entry.Args, name+"="+value)
+	} else {
+		entry.Env[name] = value
+	}
+	return entry
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+type MatrixResult struct {
+	Entry    string
+	Status   PackageStatus
+	Failures int
+	Duration time.Duration
+}
+
+// testMatrix tests the package once per entry (one after the other, as the entries
+// likely share whatever they stand for), combining the outcomes in a single result
+// (the worst status, the output and failures of every entry, labeled). Cross-compiling
+// and fuzzing happen just once. It reports false if ctx was cancelled in the meantime.
+func (self *Runner) testMatrix(ctx context.Context, packageName string, modified bool, platforms []Target, testArgs []string, settings Settings, entries []MatrixEntry) (combined Result, ok bool) {
+	for x, entry := range entries {
+		variant := settings
+		variant.Env = map[string]string{}
+		for key, value := range settings.Env {
+			variant.Env[key] = value
+		}
+		for key, value := range entry.Env {
+			variant.Env[key] = value
+		}
+		args := append(append([]string{}, testArgs...), entry.Args...)
+		if x > 0 {
+			platforms, modified = nil, false
+		}
+		result, ok := self.test(ctx, packageName, modified, platforms, args, variant)
+		if !ok {
+			return result, false
+		}
+		combined = mergeMatrixResult(combined, result, entry.Label, x == 0)
+	}
+	return combined, true
+}
+
+func mergeMatrixResult(combined, result Result, label string, first bool) Result {
+	combined.Matrix = append(combined.Matrix, MatrixResult{Entry: label, Status: result.Status, Failures: len(result.Failures), Duration: result.Duration})
+	if first {
+		combined.PackageName, combined.Module, combined.Status = result.PackageName, result.Module, result.Status
+		combined.Failures = []string{}
+	}
+	if result.Status < combined.Status {
+		combined.Status = result.Status
+	}
+	combined.Output += fmt.Sprintf("=== MATRIX %s\n%s\n", label, strings.TrimRight(result.Output, "\n"))
+	for _, failure := range result.Failures {
+		combined.Failures = append(combined.Failures, "["+label+"] "+failure)
+	}
+	if combined.PatchCoverage == nil {
+		combined.PatchCoverage = result.PatchCoverage
+	}
+	combined.Crashers = append(combined.Crashers, result.Crashers...)
+	combined.FailedTargets = append(combined.FailedTargets, result.FailedTargets...)
+	combined.Generated = append(combined.Generated, result.Generated...)
+	combined.Skipped = append(combined.Skipped, result.Skipped...)
+	if first {
+		combined.NotRun = result.NotRun
+	} else {
+		combined.NotRun = intersectNotRun(combined.NotRun, result.NotRun)
+	}
+	combined.Duration += result.Duration
+	combined.Finished = result.Finished
+	combined.CPUTime += result.CPUTime
+	if result.MaxRSS > combined.MaxRSS {
+		combined.MaxRSS = result.MaxRSS
+	}
+	return combined
+}
+
+// describeMatrix lists the outcome of each entry, ie. "DB=postgres: PASS (1.2s)".
+func describeMatrix(matrix []MatrixResult) (lines []string) {
+	for _, entry := range matrix {
+		line := fmt.Sprintf("%s: %s (%s)", entry.Entry, statusLabels[entry.Status], entry.Duration.Round(time.Millisecond*10))
+		if entry.Failures > 0 {
+			line += fmt.Sprintf(", %d failed", entry.Failures)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) matrix(path string, value interface{}) (rules []MatrixRule) {
+	for pattern, table := range self.table(path, value) {
+		rule := MatrixRule{Pattern: pattern, Variables: map[string][]string{}}
+		for name, values := range self.table(path+"."+pattern, table) {
+			rule.Variables[name] = self.strings(path+"."+pattern+"."+name, values)
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Pattern < rules[j].Pattern })
+	return rules
+}
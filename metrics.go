@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const metricsInterval = 30 * time.Second
+
+// metricHelp describes the metrics of the pipeline, in the order they're logged.
+var metricHelp = []struct{ name, help string }{
+	{"scan_seconds", "The duration of a scan of the file system (including handing its files over)."},
+	{"scan_files", "The files of a scan (the batch handed to the checksummer)."},
+	{"checksum_seconds", "The time the checksummer spends on the files of a scan."},
+	{"checksum_files", "The files the checksummer passes on (when something changed)."},
+	{"package_seconds", "The time the packager spends importing the folders of a batch."},
+	{"package_count", "The packages of a batch (handed to the selector)."},
+	{"selection_seconds", "The time the selector spends picking the packages to test."},
+	{"selection_packages", "The packages of a selection."},
+	{"dispatch_seconds", "The time from a selection to the start of its run (pausing, plugins, rerun intervals, the previous run)."},
+	{"queue_wait_seconds", "The time from the start of a run to the start of each package's tests."},
+	{"handoff_seconds", "The time a stage waits for the next one to take its work over (by channel)."},
+}
+
+// Metrics measure the health of the pipeline (see metricHelp): how long each stage
+// takes, how large its batches are, and how long it waits on the next one, so that
+// stalls (ie. a downstream stage that doesn't take anything) can be told from slow
+// stages. They're logged every 30s with -debug, and served at /metrics by -serve (in
+// the Prometheus text format). The channels of the pipeline aren't buffered, so their
+// depth is the number of stages blocked on them (handing work over). A nil Metrics
+// measures nothing.
+type Metrics struct {
+	mutex   sync.Mutex
+	samples map[metricKey]*metricSample
+	waiting map[string]int // key: channel, value: the stages blocked on it
+}
+
+type metricKey struct {
+	name    string
+	channel string // (handoff_seconds only)
+}
+
+type metricSample struct {
+	count          int64
+	sum, last, max float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{samples: map[metricKey]*metricSample{}, waiting: map[string]int{}}
+}
+
+// Observe records a value (seconds, or a count) of a metric.
+func (self *Metrics) Observe(name string, value float64) {
+	self.observe(metricKey{name: name}, value)
+}
+
+// Since records the seconds elapsed since started.
+func (self *Metrics) Since(name string, started time.Time) {
+	self.Observe(name, time.Since(started).Seconds())
+}
+
+func (self *Metrics) observe(key metricKey, value float64) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	sample := self.samples[key]
+	if sample == nil {
+		sample = &metricSample{}
+		self.samples[key] = sample
+	}
+	sample.count++
+	sample.sum += value
+	sample.last = value
+	if value > sample.max {
+		sample.max = value
+	}
+}
+
+// Handoff is called before sending on a channel of the pipeline; the function it
+// returns, once the next stage took what was sent.
+func (self *Metrics) Handoff(channel string) func() {
+	if self == nil {
+		return func() {}
+	}
+	started := time.Now()
+	self.mutex.Lock()
+	self.waiting[channel]++
+	self.mutex.Unlock()
+	return func() {
+		self.mutex.Lock()
+		self.waiting[channel]--
+		self.mutex.Unlock()
+		self.observe(metricKey{name: "handoff_seconds", channel: channel}, time.Since(started).Seconds())
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *Metrics) LogForever() {
+	for {
+		time.Sleep(metricsInterval)
+		for _, line := range self.describe() {
+			logf("Debug: %s", line)
+		}
+	}
+}
+
+func (self *Metrics) describe() (lines []string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for _, metric := range metricHelp {
+		for _, key := range self.keys(metric.name) {
+			sample := self.samples[key]
+			name := key.name
+			if key.channel != "" {
+				name += "[" + key.channel + "]"
+			}
+			lines = append(lines, fmt.Sprintf("%s: last %s, avg %s, max %s (%d samples)", name,
+				formatMetric(key.name, sample.last), formatMetric(key.name, sample.sum/float64(sample.count)), formatMetric(key.name, sample.max), sample.count))
+		}
+	}
+	blocked := []string{}
+	for _, channel := range self.channels() {
+		if self.waiting[channel] > 0 {
+			blocked = append(blocked, fmt.Sprintf("%s (%d)", channel, self.waiting[channel]))
+		}
+	}
+	if len(blocked) > 0 {
+		lines = append(lines, "blocked handing work over on: "+strings.Join(blocked, ", "))
+	}
+	return lines
+}
+
+func formatMetric(name string, value float64) string {
+	if strings.HasSuffix(name, "_seconds") {
+		return time.Duration(value * float64(time.Second)).Round(time.Millisecond / 10).String()
+	}
+	return fmt.Sprintf("%.1f", value)
+}
+
+// keys are those of the samples of the metric, by channel.
+func (self *Metrics) keys(name string) (keys []metricKey) {
+	for key := range self.samples {
+		if key.name == name {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].channel < keys[j].channel })
+	return keys
+}
+
+func (self *Metrics) channels() (channels []string) {
+	for channel := range self.waiting {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// serveMetrics answers GET /metrics with the metrics in the Prometheus text format
+// (a summary for each, along with its last and largest values).
+func (self *Hub) serveMetrics(response http.ResponseWriter, request *http.Request) {
+	metrics := self.metrics
+	if metrics == nil {
+		http.NotFound(response, request)
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	response.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, metric := range metricHelp {
+		keys := metrics.keys(metric.name)
+		if len(keys) == 0 {
+			continue
+		}
+		name := "scantest_" + metric.name
+		fmt.Fprintf(response, "# HELP %s %s\n# TYPE %s summary\n", name, metric.help, name)
+		for _, key := range keys {
+			fmt.Fprintf(response, "%s_sum%s %g\n%s_count%s %d\n", name, key.labels(), metrics.samples[key].sum, name, key.labels(), metrics.samples[key].count)
+		}
+		for _, suffix := range []string{"last", "max"} {
+			fmt.Fprintf(response, "# TYPE %s_%s gauge\n", name, suffix)
+			for _, key := range keys {
+				value := metrics.samples[key].last
+				if suffix == "max" {
+					value = metrics.samples[key].max
+				}
+				fmt.Fprintf(response, "%s_%s%s %g\n", name, suffix, key.labels(), value)
+			}
+		}
+	}
+	fmt.Fprintf(response, "# HELP scantest_handoff_waiting The stages blocked handing work over (by channel).\n# TYPE scantest_handoff_waiting gauge\n")
+	for _, channel := range metrics.channels() {
+		fmt.Fprintf(response, "scantest_handoff_waiting{channel=%q} %d\n", channel, metrics.waiting[channel])
+	}
+}
+
+func (self metricKey) labels() string {
+	if self.channel == "" {
+		return ""
+	}
+	return fmt.Sprintf("{channel=%q}", self.channel)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// MockRule ties the files declaring interfaces to the folder whose go:generate
+// directives (mockgen, counterfeiter, etc...) regenerate the mocks of those
+// interfaces (the [mocks.<name>] tables of .scantest.toml):
+//
+//	[mocks.store]
+//	sources = ["store/interfaces.go"] # gitignore-style patterns
+//	generate = "store/mocks"         # folder, relative to the working directory
+type MockRule struct {
+	Name     string
+	Sources  []string
+	Generate string
+}
+
+func (self MockRule) matches(relative string) bool {
+	for _, rule := range parseIgnoreLines(self.Sources) {
+		if rule.pattern.MatchString(filepath.ToSlash(relative)) {
+			return true
+		}
+	}
+	return false
+}
+
+// regenerateMocks runs the go:generate directives of each mock folder whose sources
+// were modified, before anything is tested, and selects the mock package (and the
+// packages that import it) for testing. Failures are reported as diagnostics.
+func (self *PackageSelector) regenerateMocks(selection *Selection, all []*Package, cascade map[string][]string) {
+	root, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	for _, rule := range self.config.Settings().Mocks {
+		changed := []string{}
+		for _, trigger := range selection.Triggers {
+			if rule.matches(trigger.File) {
+				changed = append(changed, trigger.File)
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		folder := filepath.Join(root, rule.Generate)
+		settings := self.config.Settings()
+		command := settings.Background.apply(exec.Command("go", "generate", "."))
+		command.Dir = folder
+		command.Env = settings.Environ()
+		var output []byte
+		_, err := self.generated.Generate(folder, func() (err error) {
+			output, err = command.CombinedOutput()
+			return err
+		})
+		if err != nil {
+			selection.Diagnostics = append(selection.Diagnostics, fmt.Sprintf(
+				"Could not regenerate the '%s' mocks in %s (%s changed): %s\n%s", rule.Name, rule.Generate, strings.Join(changed, ", "), err, output))
+			continue
+		}
+		logf("Regenerated the '%s' mocks in %s (%s changed).", rule.Name, rule.Generate, strings.Join(changed, ", "))
+
+		for _, pkg := range all { // (a brand new mock package won't be among them until the next scan notices it)
+			if pkg.Info.Dir != folder {
+				continue
+			}
+			selection.Packages[pkg.Info.ImportPath] = true
+			for _, upstream := range cascade[pkg.Info.ImportPath] {
+				selection.Packages[upstream] = true
+			}
+		}
+	}
+}
+
+func (self *configDecoder) mocks(path string, value interface{}) (rules []MockRule) {
+	for name, table := range self.table(path, value) {
+		rule := MockRule{Name: name}
+		for key, value := range self.table(path+"."+name, table) {
+			switch key {
+			case "sources":
+				rule.Sources = self.strings(path+"."+name+"."+key, value)
+			case "generate":
+				rule.Generate = self.string(path+"."+name+"."+key, value)
+			default:
+				self.unknown(path+"."+name+"."+key, key, "sources", "generate")
+			}
+		}
+		if len(rule.Sources) == 0 || rule.Generate == "" {
+			self.fail(path+"."+name, "needs both sources and generate.")
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+var moduleCache = struct {
+	sync.Mutex
+	paths map[string]string // key: folder, value: module path
+}{paths: map[string]string{}}
+
+// findModule returns the path declared by the nearest go.mod at or above the folder
+// (or "" when the folder isn't part of a module).
+func findModule(folder string) string {
+	if folder == "" {
+		return ""
+	}
+	moduleCache.Lock()
+	defer moduleCache.Unlock()
+
+	visited := []string{}
+	module := ""
+	for current := folder; ; current = filepath.Dir(current) {
+		if cached, found := moduleCache.paths[current]; found {
+			module = cached
+			break
+		}
+		visited = append(visited, current)
+		if raw, err := os.ReadFile(filepath.Join(current, "go.mod")); err == nil {
+			module = parseModulePath(string(raw))
+			break
+		}
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	for _, path := range visited {
+		moduleCache.paths[path] = module
+	}
+	return module
+}
+
+// pinModuleRoot makes the folder (-module-root) the working directory, provided it's
+// the root of a module, and inside a GOPATH src folder (see checkWorkspace): packages
+// are still named (and linked) by their GOPATH import paths.
+func pinModuleRoot(folder string) error {
+	gomod := filepath.Join(folder, "go.mod")
+	if !isFile(gomod) {
+		return fmt.Errorf("%s isn't the root of a module (it has no go.mod).", folder)
+	}
+	absolute, err := filepath.Abs(folder)
+	if err != nil {
+		return err
+	}
+	if problem := describeOutsideGOPATH(absolute, "module mode ("+filepath.Join(absolute, "go.mod")+")"); problem != "" {
+		return fmt.Errorf("%s %s", problem, outsideGOPATHFix)
+	}
+	return os.Chdir(absolute)
+}
+
+// isNestedModule reports whether the folder (under the root) is the root of a module
+// of its own, which can't be tested from the root's without a go.work file.
+func isNestedModule(root, folder string) bool {
+	return folder != root && isFile(filepath.Join(folder, "go.mod"))
+}
+
+func parseModulePath(gomod string) string {
+	for _, line := range strings.Split(gomod, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "module") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module")), `"`)
+		}
+	}
+	return ""
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+type ModuleSummary struct {
+	Module   string   `json:"module"`
+	Packages []string `json:"packages"` // the import paths of its results (as ordered among the packages)
+	Passed   int      `json:"passed"`
+	Failed   int      `json:"failed"`
+}
+
+// summarizeModules groups and tallies the results per module, listing modules with
+// failures last, so that consumers of the JSON needn't group the packages themselves.
+// It returns nothing unless the results span more than one module.
+func summarizeModules(results []Result) []ModuleSummary {
+	summaries := map[string]*ModuleSummary{}
+	for _, result := range results {
+		summary, found := summaries[result.Module]
+		if !found {
+			summary = &ModuleSummary{Module: result.Module}
+			summaries[result.Module] = summary
+		}
+		summary.Packages = append(summary.Packages, result.PackageName)
+		if result.Status == TestsPassed {
+			summary.Passed++
+		} else {
+			summary.Failed++
+		}
+	}
+	if len(summaries) < 2 {
+		return nil
+	}
+
+	ordered := []ModuleSummary{}
+	for _, summary := range summaries {
+		ordered = append(ordered, *summary)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if (ordered[i].Failed > 0) != (ordered[j].Failed > 0) {
+			return ordered[j].Failed > 0
+		}
+		return ordered[i].Module < ordered[j].Module
+	})
+	return ordered
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// folderSignatures describes the .go files of each folder by name, size and
+// modification time (all of which survive renaming or moving the folder).
+func folderSignatures(goFiles map[string]int64) map[string]string {
+	entries := map[string][]string{}
+	for path, checksum := range goFiles {
+		folder := filepath.Dir(path)
+		entries[folder] = append(entries[folder], fmt.Sprintf("%s:%d", filepath.Base(path), checksum))
+	}
+	signatures := map[string]string{}
+	for folder, files := range entries {
+		sort.Strings(files)
+		signatures[folder] = strings.Join(files, "|")
+	}
+	return signatures
+}
+
+// detectMoves pairs each folder that vanished with the folder that appeared with
+// the very same files (key: new folder, value: old folder). Ambiguous pairs (two
+// vanished folders with the same files) aren't considered moves.
+func detectMoves(previous, current map[string]string) map[string]string {
+	vanished := map[string][]string{} // key: signature
+	for folder, signature := range previous {
+		if _, found := current[folder]; !found {
+			vanished[signature] = append(vanished[signature], folder)
+		}
+	}
+	moves := map[string]string{}
+	for folder, signature := range current {
+		if _, found := previous[folder]; found {
+			continue
+		}
+		if candidates := vanished[signature]; len(candidates) == 1 {
+			moves[folder] = candidates[0]
+		}
+	}
+	return moves
+}
+
+// importPathOf derives the import path a folder has (or had, if it's gone) from
+// its location under GOPATH.
+func importPathOf(folder string) string {
+	for _, source := range build.Default.SrcDirs() {
+		if relative, err := filepath.Rel(source, folder); err == nil && relative != "." && isWithin(relative) {
+			return filepath.ToSlash(relative)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha1"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// TestIndex remembers the TestXxx functions of each _test.go file (by a hash of
+// their source) so that, when only test code changed, just the added or edited
+// test functions need to be run.
+type TestIndex struct {
+	files map[string]testFile // key: path
+}
+
+type testFile struct {
+	tests map[string][sha1.Size]byte // key: test function name
+	rest  [sha1.Size]byte            // everything else (imports, helpers, fixtures, etc...)
+}
+
+func NewTestIndex() *TestIndex {
+	return &TestIndex{files: map[string]testFile{}}
+}
+
+// Changed lists the test functions of the file that were added or edited since it
+// was last indexed. It reports false when anything but test functions changed (or
+// the file is new, or unparseable), meaning every test of the package should run.
+// A nil TestIndex never narrows anything.
+func (self *TestIndex) Changed(path string) (names []string, ok bool) {
+	if self == nil {
+		return nil, false
+	}
+	current, parsed := indexTestFile(path)
+	previous, found := self.files[path]
+	if !parsed {
+		delete(self.files, path)
+		return nil, false
+	}
+	self.files[path] = current
+	if !found || current.rest != previous.rest {
+		return nil, false
+	}
+	for name, hash := range current.tests {
+		if previous.tests[name] != hash {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// Move re-keys the files indexed in a folder that was moved (or renamed).
+func (self *TestIndex) Move(from, to string) {
+	if self == nil {
+		return
+	}
+	for path, indexed := range self.files {
+		if filepath.Dir(path) == from {
+			delete(self.files, path)
+			self.files[filepath.Join(to, filepath.Base(path))] = indexed
+		}
+	}
+}
+
+func indexTestFile(path string) (indexed testFile, ok bool) {
+	fileset := token.NewFileSet()
+	file, err := parser.ParseFile(fileset, path, nil, 0)
+	if err != nil {
+		return indexed, false
+	}
+	indexed.tests = map[string][sha1.Size]byte{}
+	rest := new(strings.Builder)
+	rest.WriteString(file.Name.Name)
+	for _, declaration := range file.Decls {
+		source := new(strings.Builder)
+		printer.Fprint(source, fileset, declaration)
+		if function, ok := declaration.(*ast.FuncDecl); ok && isTestFunction(function) {
+			indexed.tests[function.Name.Name] = sha1.Sum([]byte(source.String()))
+		} else {
+			rest.WriteString("\n" + source.String())
+		}
+	}
+	indexed.rest = sha1.Sum([]byte(rest.String()))
+	return indexed, true
+}
+
+func isTestFunction(function *ast.FuncDecl) bool {
+	if function.Recv != nil || !strings.HasPrefix(function.Name.Name, "Test") || function.Name.Name == "TestMain" {
+		return false
+	}
+	params := function.Type.Params.List
+	if len(params) != 1 {
+		return false
+	}
+	star, ok := params[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	selector, ok := star.X.(*ast.SelectorExpr)
+	return ok && selector.Sel.Name == "T"
+}
+
+// narrowedRun builds the -run pattern for the named test functions (and, with
+// examples, every Example function, since doc examples break silently otherwise).
+func narrowedRun(names []string, examples bool) string {
+	if examples {
+		names = append(append([]string{}, names...), "Example.*")
+	}
+	return "-run=^(" + strings.Join(names, "|") + ")$"
+}
+
+func hasArgument(testArgs []string, name string) bool {
+	for _, argument := range testArgs {
+		if argument == "-"+name || argument == "--"+name || strings.HasPrefix(argument, "-"+name+"=") || strings.HasPrefix(argument, "--"+name+"=") {
+			return true
+		}
+	}
+	return false
+}
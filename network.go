@@ -0,0 +1,115 @@
+package main
+
+import (
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// NetworkSettings configure the network mode (the [network] table of .scantest.toml),
+// for repositories on NFS or SMB mounts (or shared with a VM or a container), whose
+// modification times can't be relied on: files are told apart by their contents
+// instead (so changes aren't missed, and touches don't trigger phantom runs), and
+// less often, as reading them over the network is slow. The mode is "auto" (when
+// the file system of the root looks like a network one), "on" or "off".
+//
+//	[network]
+//	mode = "auto"
+//	scan-interval = "3s"
+type NetworkSettings struct {
+	Mode         string
+	ScanInterval time.Duration // the time between scans in network mode
+}
+
+var defaultNetwork = NetworkSettings{
+	Mode:         "auto",
+	ScanInterval: 3 * time.Second,
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Network tells whether to scan in network mode (logging the mode whenever it's
+// selected, or when the network file system detected is scanned as a local one).
+type Network struct {
+	config *ConfigWatcher
+	fstype string // of the root ("" for local file systems)
+
+	mutex    sync.Mutex
+	enabled  bool
+	selected bool // whether the mode was logged yet
+}
+
+func NewNetwork(root string, config *ConfigWatcher) *Network {
+	return &Network{config: config, fstype: networkFileSystem(root)}
+}
+
+func (self *Network) Enabled() bool {
+	settings := self.config.Settings().Network
+	enabled := settings.Mode == "on" || (settings.Mode == "auto" && self.fstype != "")
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.selected && enabled == self.enabled {
+		return enabled
+	}
+	switch {
+	case enabled && self.fstype != "":
+		logf("Network mode: the root is on a network file system (%s), so files are compared by contents (scanning every %s)", self.fstype, settings.ScanInterval)
+	case enabled:
+		logf("Network mode: files are compared by contents (scanning every %s)", settings.ScanInterval)
+	case self.fstype != "":
+		logf("Warning: the root is on a network file system (%s), but the network mode is off, so changes may be missed (or phantom runs triggered).", self.fstype)
+	case self.selected:
+		logf("Network mode: off")
+	}
+	self.enabled, self.selected = enabled, true
+	return enabled
+}
+
+// ScanInterval is the interval given (or the one of the network mode, if longer).
+func (self *Network) ScanInterval(interval time.Duration) time.Duration {
+	if settings := self.config.Settings().Network; self.Enabled() && settings.ScanInterval > interval {
+		return settings.ScanInterval
+	}
+	return interval
+}
+
+// contentChecksum stands in for the size and modification time of a file in
+// network mode.
+func contentChecksum(path string) int64 {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	hash := fnv.New64a()
+	hash.Write(raw)
+	return int64(hash.Sum64())
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) network(path string, value interface{}, network *NetworkSettings) {
+	for key, value := range self.table(path, value) {
+		switch key {
+		case "mode":
+			mode := self.string(path+"."+key, value)
+			if mode != "auto" && mode != "on" && mode != "off" {
+				self.fail(path+"."+key, "must be \"auto\", \"on\" or \"off\".")
+			}
+			network.Mode = mode
+		case "scan-interval":
+			interval, err := time.ParseDuration(self.string(path+"."+key, value))
+			if err != nil || interval <= 0 {
+				self.fail(path+"."+key, "must be a positive duration (ie. \"3s\").")
+			}
+			network.ScanInterval = interval
+		default:
+			self.unknown(path+"."+key, key, "mode", "scan-interval")
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const notifyTimeout = 10 * time.Second
+
+// NotifySettings post the packages that start failing (and those fixed since) to
+// webhooks (the [notify] table of .scantest.toml), ie. Slack incoming webhooks for an
+// instance that watches a shared repository. Like CODEOWNERS, the owners table routes
+// the packages matching a pattern (see matchesPattern) to the webhook of the team that
+// owns them (the most specific pattern wins), and the webhook gets the packages no
+// one owns (none are posted when it's empty).
+//
+//	[notify]
+//	webhook = "https://hooks.slack.com/services/T000/B000/XXXX"
+//
+//	[notify.owners]
+//	"example.com/app/billing/..." = "https://hooks.slack.com/services/T000/B111/YYYY"
+type NotifySettings struct {
+	Webhook string
+	Owners  map[string]string // key: pattern, value: webhook
+}
+
+// route is the webhook of the package's owner (or the default one).
+func (self NotifySettings) route(packageName string) string {
+	webhook, longest := self.Webhook, -1
+	for pattern, owner := range self.Owners {
+		if len(pattern) > longest && matchesPattern(pattern, packageName) {
+			webhook, longest = owner, len(pattern)
+		}
+	}
+	return webhook
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Notifier remembers which packages fail, so that each webhook hears about a package
+// when it starts failing and when it's fixed, rather than after every run. A nil
+// Notifier posts nothing.
+type Notifier struct {
+	config  *ConfigWatcher
+	client  *http.Client
+	pending sync.WaitGroup
+
+	mutex   sync.Mutex
+	failing map[string]bool // key: package
+}
+
+func NewNotifier(config *ConfigWatcher) *Notifier {
+	return &Notifier{config: config, client: &http.Client{Timeout: notifyTimeout}, failing: map[string]bool{}}
+}
+
+func (self *Notifier) Notify(report *Report) {
+	if self == nil {
+		return
+	}
+	settings := self.config.Settings().Notify
+	failed := map[string][]Result{} // key: webhook
+	fixed := map[string][]Result{}
+	self.mutex.Lock()
+	for _, result := range report.Results {
+		failing := result.Status != TestsPassed
+		if failing == self.failing[result.PackageName] {
+			continue
+		}
+		self.failing[result.PackageName] = failing
+		webhook := settings.route(result.PackageName)
+		if webhook == "" {
+			continue
+		} else if failing {
+			failed[webhook] = append(failed[webhook], result)
+		} else {
+			fixed[webhook] = append(fixed[webhook], result)
+		}
+	}
+	self.mutex.Unlock()
+
+	webhooks := map[string]bool{}
+	for webhook := range failed {
+		webhooks[webhook] = true
+	}
+	for webhook := range fixed {
+		webhooks[webhook] = true
+	}
+	for webhook := range webhooks {
+		self.pending.Add(1)
+		go self.post(webhook, describeNotification(failed[webhook], fixed[webhook], report.Git))
+	}
+}
+
+// Wait lets the posts in flight finish (before exiting, with -once).
+func (self *Notifier) Wait() {
+	if self != nil {
+		self.pending.Wait()
+	}
+}
+
+// post sends the text as a Slack message (other webhooks get the same JSON).
+func (self *Notifier) post(webhook, text string) {
+	defer self.pending.Done()
+	raw, _ := json.Marshal(map[string]string{"text": text})
+	response, err := self.client.Post(webhook, "application/json", bytes.NewReader(raw))
+	if failure, ok := err.(*url.Error); ok {
+		fmt.Fprintf(os.Stderr, "Notify: %s: %s\n", redactWebhook(webhook), failure.Err) // (the error would give the whole URL away)
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Notify:", err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		fmt.Fprintf(os.Stderr, "Notify: %s answered %s.\n", redactWebhook(webhook), response.Status)
+	}
+}
+
+func describeNotification(failed, fixed []Result, git *GitState) string {
+	lines := []string{}
+	heading := "scantest"
+	if git != nil {
+		heading += " (" + git.String() + ")"
+	}
+	if len(failed) > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %s started failing:", heading, countPackages(len(failed))))
+		for _, result := range failed {
+			line := "• " + result.PackageName + ": " + strings.ToLower(statusLabels[result.Status])
+			if tests := failedTests(result); len(tests) > 0 {
+				line += " (" + strings.Join(tests, ", ") + ")"
+			}
+			lines = append(lines, line)
+		}
+	}
+	if len(fixed) > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %s fixed:", heading, countPackages(len(fixed))))
+		for _, result := range fixed {
+			lines = append(lines, "• "+result.PackageName)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func failedTests(result Result) (tests []string) {
+	seen := map[string]bool{}
+	for _, failure := range parser.Parse(result.Output) {
+		if failure.Test != "" && !seen[failure.Test] {
+			seen[failure.Test] = true
+			tests = append(tests, failure.Test)
+		}
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+func countPackages(count int) string {
+	if count == 1 {
+		return "1 package"
+	}
+	return fmt.Sprintf("%d packages", count)
+}
+
+// redactWebhook leaves the secret part of a webhook (its path) out of the messages.
+func redactWebhook(webhook string) string {
+	if parts := strings.SplitN(webhook, "/", 4); len(parts) == 4 {
+		return strings.Join(parts[:3], "/") + "/..."
+	}
+	return webhook
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) notify(path string, value interface{}, notify *NotifySettings) {
+	for key, value := range self.table(path, value) {
+		switch key {
+		case "webhook":
+			notify.Webhook = self.webhook(path+"."+key, value)
+		case "owners":
+			notify.Owners = map[string]string{}
+			for pattern, value := range self.table(path+"."+key, value) {
+				notify.Owners[pattern] = self.webhook(path+"."+key+"."+pattern, value)
+			}
+		default:
+			self.unknown(path+"."+key, key, "webhook", "owners")
+		}
+	}
+}
+
+func (self *configDecoder) webhook(path string, value interface{}) string {
+	webhook := self.string(path, value)
+	if webhook != "" && !strings.HasPrefix(webhook, "https://") && !strings.HasPrefix(webhook, "http://") {
+		self.fail(path, "must be the URL of a webhook (ie. \"https://hooks.slack.com/services/...\").")
+	}
+	return webhook
+}
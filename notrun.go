@@ -0,0 +1,184 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	scanparser "github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// unexercisedTests finds the test functions of the package that a run (with these
+// test arguments and environment) leaves out without saying so, so that a green
+// package whose integration tests never ran doesn't pass for a tested one: those of
+// the _test.go files that build constraints exclude here (ie. //go:build integration,
+// unless -tags has it), those that check testing.Short() when the tests run with
+// -short, and those that read an environment variable that isn't set and may skip
+// without it. The tests already among the reported skips are left out (as are all
+// of them when the run was narrowed with -run).
+func unexercisedTests(pkg *build.Package, testArgs []string, settings Settings, environment []string, skipped []scanparser.Skip) (tests []scanparser.Skip) {
+	if pkg == nil || hasArgument(testArgs, "run") {
+		return nil
+	}
+	reported := map[string]bool{}
+	for _, skip := range skipped {
+		reported[strings.SplitN(skip.Test, "/", 2)[0]] = true
+	}
+	add := func(test, reason string) {
+		if !reported[test] {
+			reported[test] = true
+			tests = append(tests, scanparser.Skip{Test: test, Reason: reason})
+		}
+	}
+
+	context := build.Default
+	context.BuildTags = buildTags(settings)
+	files := token.NewFileSet()
+	for _, name := range pkg.IgnoredGoFiles {
+		if !strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := context.MatchFile(pkg.Dir, name); err != nil || ok {
+			continue // (included by -tags after all)
+		}
+		file, err := parser.ParseFile(files, filepath.Join(pkg.Dir, name), nil, parser.ParseComments)
+		if err != nil {
+			continue
+		}
+		reason := name + ": " + buildConstraint(file)
+		for _, function := range testFunctions(file) {
+			add(function.Name.Name, reason)
+		}
+	}
+
+	short := shortMode(testArgs, environment)
+	variables := map[string]string{}
+	for _, variable := range environment {
+		if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 {
+			variables[parts[0]] = parts[1]
+		}
+	}
+	for _, name := range append(append([]string{}, pkg.TestGoFiles...), pkg.XTestGoFiles...) {
+		file, err := parser.ParseFile(files, filepath.Join(pkg.Dir, name), nil, 0)
+		if err != nil {
+			continue
+		}
+		for _, function := range testFunctions(file) {
+			checksShort, skips, unset := inspectTest(function, variables)
+			if short && checksShort {
+				add(function.Name.Name, "testing.Short(), with -short")
+			} else if skips && len(unset) > 0 {
+				verb := " isn't set"
+				if len(unset) > 1 {
+					verb = " aren't set"
+				}
+				add(function.Name.Name, "may skip: $"+strings.Join(unset, ", $")+verb)
+			}
+		}
+	}
+	sort.Slice(tests, func(i, j int) bool { return tests[i].Test < tests[j].Test })
+	return tests
+}
+
+// buildConstraint is the //go:build line of the file (or // +build lines, for older
+// files), or else the platform its name (ie. _windows_test.go) doesn't match.
+func buildConstraint(file *ast.File) string {
+	legacy := []string{}
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "//go:build ") {
+				return comment.Text
+			} else if strings.HasPrefix(comment.Text, "// +build ") {
+				legacy = append(legacy, comment.Text)
+			}
+		}
+	}
+	if len(legacy) > 0 {
+		return strings.Join(legacy, " ")
+	}
+	return "not for " + build.Default.GOOS + "/" + build.Default.GOARCH
+}
+
+// shortMode reports whether the tests run with -short (by test-args or by GOFLAGS).
+func shortMode(testArgs, environment []string) bool {
+	for _, variable := range environment {
+		if strings.HasPrefix(variable, "GOFLAGS=") && hasArgument(strings.Fields(strings.TrimPrefix(variable, "GOFLAGS=")), "short") {
+			return true
+		}
+	}
+	for _, argument := range testArgs {
+		if argument == "-short" || argument == "--short" || argument == "-short=true" || argument == "--short=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// testFunctions are the TestXxx(t *testing.T) functions of the file (but TestMain).
+func testFunctions(file *ast.File) (functions []*ast.FuncDecl) {
+	for _, declaration := range file.Decls {
+		function, ok := declaration.(*ast.FuncDecl)
+		if !ok || function.Recv != nil || function.Body == nil || function.Name.Name == "TestMain" {
+			continue
+		}
+		name := function.Name.Name
+		if !strings.HasPrefix(name, "Test") || function.Type.Params.NumFields() != 1 {
+			continue
+		}
+		if next, _ := utf8.DecodeRuneInString(name[len("Test"):]); len(name) > len("Test") && unicode.IsLower(next) {
+			continue
+		}
+		functions = append(functions, function)
+	}
+	return functions
+}
+
+// inspectTest reports whether the test checks testing.Short(), whether it skips
+// (t.Skip, t.Skipf or t.SkipNow), and which of the environment variables it reads
+// (by os.Getenv or os.LookupEnv, with a literal name) aren't set.
+func inspectTest(function *ast.FuncDecl, variables map[string]string) (checksShort, skips bool, unset []string) {
+	seen := map[string]bool{}
+	ast.Inspect(function.Body, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		receiver, _ := selector.X.(*ast.Ident)
+		switch {
+		case receiver != nil && receiver.Name == "testing" && selector.Sel.Name == "Short":
+			checksShort = true
+		case selector.Sel.Name == "Skip" || selector.Sel.Name == "Skipf" || selector.Sel.Name == "SkipNow":
+			skips = true
+		case receiver != nil && receiver.Name == "os" && (selector.Sel.Name == "Getenv" || selector.Sel.Name == "LookupEnv") && len(call.Args) == 1:
+			literal, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || literal.Kind != token.STRING {
+				break
+			}
+			name, err := strconv.Unquote(literal.Value)
+			if err == nil && variables[name] == "" && !seen[name] {
+				seen[name] = true
+				unset = append(unset, name)
+			}
+		}
+		return true
+	})
+	return checksShort, skips, unset
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// outcomes are the values of the exit-codes and outcomes of a PackageRule, for
+// commands that don't exit like `go test` (or whose output tells better):
+//
+//	[packages."example.com/app/integration/..."]
+//	command = "./scripts/with-fixtures.sh go test"
+//	exit-codes = { "2" = "failed", "3" = "passed" }   # 3: nothing to test in this environment
+//	outcomes = { 'fixtures: timed out' = "failed" }   # regular expressions matching the output
+var outcomes = map[string]PackageStatus{
+	"passed":         TestsPassed,
+	"failed":         TestsFailed,
+	"compile-failed": CompileFailed,
+}
+
+// OutcomePattern classifies the output matching a regular expression.
+type OutcomePattern struct {
+	Pattern string
+	Status  PackageStatus
+}
+
+// classify overrides the status the exit code of a package's tests maps to (see
+// Runner.test) with the rule's: by the exit code first, and then by the output (when
+// several patterns match, the worst outcome wins).
+func (self PackageRule) classify(result *Result, code int, started bool) {
+	status, found := self.ExitCodes[code]
+	if !started {
+		found = false // (no exit code to go by)
+	}
+	matched := false
+	for _, outcome := range self.Outcomes {
+		pattern, err := regexp.Compile(outcome.Pattern) // (validated by the config decoder)
+		if err != nil || !pattern.MatchString(result.Output) {
+			continue
+		}
+		if !matched || outcome.Status < status {
+			status = outcome.Status
+		}
+		found, matched = true, true
+	}
+	if !found || status == result.Status {
+		return
+	}
+	result.Status = status
+	result.Failures = nil
+	if status == TestsFailed {
+		result.Failures = []string{}
+		for _, failure := range parser.Parse(result.Output) {
+			result.Failures = append(result.Failures, describeFailure(failure))
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) outcome(path string, value interface{}) PackageStatus {
+	status, found := outcomes[self.string(path, value)]
+	if !found {
+		self.fail(path, "must be one of: passed, failed, compile-failed.")
+	}
+	return status
+}
+
+func (self *configDecoder) exitCodes(path string, value interface{}) map[int]PackageStatus {
+	codes := map[int]PackageStatus{}
+	for key, value := range self.table(path, value) {
+		code, err := strconv.Atoi(key)
+		if err != nil || code < 0 || code > 255 {
+			self.fail(path+"."+key, "isn't an exit code (0-255).")
+			continue
+		}
+		codes[code] = self.outcome(path+"."+key, value)
+	}
+	return codes
+}
+
+func (self *configDecoder) outcomePatterns(path string, value interface{}) (patterns []OutcomePattern) {
+	for pattern, value := range self.table(path, value) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			self.fail(path+"."+pattern, "isn't a valid regular expression (%s).", err)
+			continue
+		}
+		patterns = append(patterns, OutcomePattern{Pattern: pattern, Status: self.outcome(path+"."+pattern, value)})
+	}
+	sort.Slice(patterns, func(i, j int) bool { return patterns[i].Pattern < patterns[j].Pattern })
+	return patterns
+}
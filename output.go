@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	defaultMaxOutput = 16 << 20 // bytes
+	outputFolder     = "output" // under .scantest
+)
+
+// OutputBuffer collects the combined output of a command in memory, up to the
+// max-output of .scantest.toml. Past that, all of it goes to a file under
+// .scantest/output (redacted, a line at a time), and only its head and tail (half of
+// max-output each) are kept in memory, so a test that logs gigabytes doesn't take the
+// watcher down with it. The failures are parsed from what's kept (the tail holds the
+// summary, and any panic), and the file is there for the rest (see Result.OutputFile,
+// -raw, and GET /output of -serve).
+type OutputBuffer struct {
+	limit    int64 // (0: no limit)
+	path     string
+	redactor Redactor
+
+	memory  bytes.Buffer // everything, and then the head
+	tail    []byte
+	total   int64
+	file    *os.File
+	partial []byte // (of the line being written to the file)
+	err     error
+}
+
+func NewOutputBuffer(limit int64, name string, redactor Redactor) *OutputBuffer {
+	path := filepath.Join(stateFolder, outputFolder, outputFilename(name))
+	os.Remove(path) // (the output of a previous run)
+	return &OutputBuffer{limit: limit, path: path, redactor: redactor}
+}
+
+func outputFilename(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(name) + ".log"
+}
+
+func (self *OutputBuffer) Write(p []byte) (int, error) {
+	self.total += int64(len(p))
+	if self.file == nil && (self.limit == 0 || int64(self.memory.Len()+len(p)) <= self.limit) {
+		return self.memory.Write(p)
+	}
+	if self.file == nil {
+		self.spill()
+	}
+	self.write(p)
+	self.tail = append(self.tail, p...)
+	if extra := len(self.tail) - int(self.limit/2); extra > 0 {
+		self.tail = append(self.tail[:0], self.tail[extra:]...)
+	}
+	return len(p), nil
+}
+
+// spill moves what's in memory to the file, keeping the head.
+func (self *OutputBuffer) spill() {
+	if err := os.MkdirAll(filepath.Dir(self.path), 0755); err != nil {
+		self.err = err
+	} else if self.file, err = os.Create(self.path); err != nil {
+		self.err = err
+	}
+	held := self.memory.Bytes()
+	self.write(held)
+	half := int(self.limit / 2)
+	if len(held) > half {
+		self.tail = append([]byte{}, held[len(held)-half:]...)
+		self.memory.Truncate(half)
+	} else {
+		self.tail = append([]byte{}, held...)
+	}
+}
+
+// write appends the whole lines to the file (redacted, like the output kept in memory).
+func (self *OutputBuffer) write(p []byte) {
+	if self.file == nil || self.err != nil {
+		return
+	}
+	self.partial = append(self.partial, p...)
+	end := bytes.LastIndexByte(self.partial, '\n')
+	if end < 0 {
+		return
+	}
+	lines := self.partial[:end+1]
+	if len(self.redactor) > 0 {
+		lines = []byte(self.redactor.Redact(string(lines)))
+	}
+	_, self.err = self.file.Write(lines)
+	self.partial = append(self.partial[:0], self.partial[end+1:]...)
+}
+
+// Close flushes the last line to the file (if any), and reports the file.
+func (self *OutputBuffer) Close() (spilled string, err error) {
+	if self.file == nil {
+		return "", nil
+	}
+	if len(self.partial) > 0 {
+		self.write([]byte("\n"))
+	}
+	if err := self.file.Close(); err != nil && self.err == nil {
+		self.err = err
+	}
+	return self.path, self.err
+}
+
+// Bytes is the output, or its head and tail once it was spilled to the file.
+func (self *OutputBuffer) Bytes() []byte {
+	if self.file == nil {
+		return self.memory.Bytes()
+	}
+	head, tail := self.memory.Bytes(), self.tail
+	if end := bytes.LastIndexByte(head, '\n'); end >= 0 {
+		head = head[:end+1] // (whole lines)
+	}
+	if start := bytes.IndexByte(tail, '\n'); start >= 0 {
+		tail = tail[start+1:]
+	}
+	elided := self.total - int64(len(head)+len(tail))
+	marker := fmt.Sprintf("\n... (%s of output left out; the whole %s is in %s) ...\n\n", describeSize(elided), describeSize(self.total), self.path)
+	return append(append(append([]byte{}, head...), marker...), tail...)
+}
+
+func describeSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", size)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// combinedOutput is command.CombinedOutput() (bounded by max-output, see OutputBuffer),
+// streaming the output as it comes (with -stream). It reports the file the output
+// spilled to, if it did: one for each package and command (so that retries and the
+// entries of a matrix keep theirs).
+func (self *Runner) combinedOutput(command *exec.Cmd, packageName string) ([]byte, string, error) {
+	settings := self.config.Settings()
+	hash := fnv.New32a()
+	fmt.Fprintln(hash, command.Args, command.Env)
+	name := fmt.Sprintf("%s-%08x", packageName, hash.Sum32())
+	output := NewOutputBuffer(settings.MaxOutput, name, NewRedactor(settings.Redact))
+	command.Stdout = output
+	var live *streamWriter
+	if self.stream != nil {
+		live = self.stream.writer(packageName, NewRedactor(settings.Redact))
+		command.Stdout = io.MultiWriter(output, live)
+	}
+	command.Stderr = command.Stdout
+	err := command.Run()
+	if live != nil {
+		live.Flush()
+	}
+	spilled, spillErr := output.Close()
+	if spillErr != nil {
+		logf("Couldn't keep all of the output of %s in %s: %s", packageName, output.path, spillErr)
+	}
+	return output.Bytes(), spilled, err
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// copyOutput writes the whole output of the result: its file, when it was spilled
+// (see OutputBuffer), or else what's in memory.
+func copyOutput(writer io.Writer, result Result) error {
+	if result.OutputFile == "" {
+		_, err := io.WriteString(writer, result.Output)
+		return err
+	}
+	file, err := os.Open(result.OutputFile)
+	if err != nil {
+		_, err = io.WriteString(writer, result.Output)
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// serveOutput answers GET /output?package=<import path> with the whole output of the
+// package in the last run (streamed from its file, when it was spilled).
+func (self *Hub) serveOutput(response http.ResponseWriter, request *http.Request) {
+	self.mutex.Lock()
+	latest := self.latest
+	self.mutex.Unlock()
+	packageName := request.URL.Query().Get("package")
+	if latest != nil && latest.Run != nil {
+		for _, result := range latest.Run.Packages {
+			if result.PackageName == packageName {
+				response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				response.Header().Set("Access-Control-Allow-Origin", "*")
+				copyOutput(response, result)
+				return
+			}
+		}
+	}
+	http.Error(response, "no output of "+packageName+" in the last run", http.StatusNotFound)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"go/build"
+	"os/exec"
+	"sort"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// PackageRule changes how the packages matching a pattern (an import path, where
+// "..." matches anything) are tested (the [packages.<pattern>] tables of
+// .scantest.toml): from another working directory ({package} being the folder of
+// the package), and/or with a command in place of `go test` (given the same
+// arguments, ie. -v <test-args> <package> (without -v, with -quiet), and expected
+// to exit like it: 0 when the tests pass, 1 when they fail and 2 when the package
+// doesn't build, unless exit-codes or outcomes say otherwise; see outcomes), and/or
+// with more test-args and a min-coverage (see enforcePolicy):
+//
+//	[packages."example.com/app/integration/..."]
+//	dir = "{package}"
+//	command = "./scripts/with-fixtures.sh go test"
+//	test-args = ["-race"]
+type PackageRule struct {
+	Pattern     string
+	Dir         string
+	Command     string
+	TestArgs    []string
+	MinCoverage float64
+	ExitCodes   map[int]PackageStatus
+	Outcomes    []OutcomePattern
+}
+
+const packageFolder = "{package}"
+
+// testCommand builds the command that tests a package (`go test`, unless overridden).
+func (self Settings) testCommand(ctx context.Context, packageName string, testArgs []string, verbose bool) *exec.Cmd {
+	arguments := append(append([]string{}, testArgs...), packageName)
+	if verbose {
+		arguments = append([]string{"-v"}, arguments...)
+	}
+	rule := self.packageRule(packageName)
+	var command *exec.Cmd
+	if rule.Command != "" {
+		command = newWrapperCommand(ctx, rule.Command, arguments...)
+	} else {
+		command = newCommand(ctx, "go", append([]string{"test"}, arguments...)...)
+	}
+	if rule.Dir == packageFolder {
+		if pkg, err := build.Default.Import(packageName, "", build.FindOnly); err == nil {
+			command.Dir = pkg.Dir
+		}
+	} else if rule.Dir != "" {
+		command.Dir = rule.Dir // (relative to the working directory)
+	}
+	if self.Remote.covers(packageName) {
+		command = self.Remote.command(ctx, command, self.Env)
+	}
+	return self.Background.apply(command)
+}
+
+// packageRule is the most specific rule matching the package (if any).
+func (self Settings) packageRule(packageName string) (rule PackageRule) {
+	for _, candidate := range self.Packages {
+		if len(candidate.Pattern) > len(rule.Pattern) && matchesPattern(candidate.Pattern, packageName) {
+			rule = candidate
+		}
+	}
+	return rule
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) packages(path string, value interface{}) (rules []PackageRule) {
+	for pattern, table := range self.table(path, value) {
+		rule := PackageRule{Pattern: pattern}
+		for key, value := range self.table(path+"."+pattern, table) {
+			switch key {
+			case "dir":
+				rule.Dir = self.string(path+"."+pattern+"."+key, value)
+			case "command":
+				rule.Command = self.string(path+"."+pattern+"."+key, value)
+			case "test-args":
+				rule.TestArgs = self.strings(path+"."+pattern+"."+key, value)
+			case "min-coverage":
+				rule.MinCoverage = self.percentage(path+"."+pattern+"."+key, value)
+			case "exit-codes":
+				rule.ExitCodes = self.exitCodes(path+"."+pattern+"."+key, value)
+			case "outcomes":
+				rule.Outcomes = self.outcomePatterns(path+"."+pattern+"."+key, value)
+			default:
+				self.unknown(path+"."+pattern+"."+key, key, "dir", "command", "test-args", "min-coverage", "exit-codes", "outcomes")
+			}
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Pattern < rules[j].Pattern })
+	return rules
+}
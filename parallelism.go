@@ -0,0 +1,160 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// heavilyParallel is the number of t.Parallel() calls in the tests of a package
+// from which it's considered heavily parallel.
+const heavilyParallel = 4
+
+// ParallelismSettings keep packages tested at once (-parallel, or the parallel
+// setting) from each running as many parallel tests as there are CPUs (the default
+// of `go test -parallel`), which oversubscribes the machine (the [parallelism]
+// table of .scantest.toml):
+//
+//	[parallelism]
+//	cap = 8 # parallel tests across all the packages tested at once (default: the number of CPUs)
+//
+//	[parallelism.packages]
+//	"example.com/app/integration/..." = { parallel = 2, gomaxprocs = 2 }
+//
+// Heavily parallel packages get an equal share of the cap as their -parallel and
+// GOMAXPROCS. The packages matching a pattern get the values given instead.
+type ParallelismSettings struct {
+	Cap   int
+	Rules []ParallelismRule
+}
+
+type ParallelismRule struct {
+	Pattern    string // an import path, where "..." matches anything
+	Parallel   int
+	GOMAXPROCS int
+}
+
+// tune returns the settings for testing a package while workers packages are being
+// tested at once, with -parallel added to the test arguments and GOMAXPROCS to the
+// environment, as needed (unless the test arguments already have -parallel).
+func (self Settings) tune(packageName string, workers int) Settings {
+	parallel, gomaxprocs := self.Parallelism.limits(packageName, workers)
+	gomaxprocs = self.Background.limit(gomaxprocs)
+	if rule := self.packageRule(packageName); len(rule.TestArgs) > 0 {
+		self.TestArgs = append(append([]string{}, self.TestArgs...), rule.TestArgs...)
+	}
+	if self.minCoverage(packageName) > 0 && !hasArgument(self.TestArgs, "cover") && !hasArgument(self.TestArgs, "coverprofile") {
+		self.TestArgs = append(append([]string{}, self.TestArgs...), "-cover")
+	}
+	if parallel > 0 && !hasArgument(self.TestArgs, "parallel") {
+		self.TestArgs = append(append([]string{}, self.TestArgs...), "-parallel="+strconv.Itoa(parallel))
+	}
+	if gomaxprocs > 0 {
+		env := map[string]string{}
+		for name, value := range self.Env {
+			env[name] = value
+		}
+		env["GOMAXPROCS"] = strconv.Itoa(gomaxprocs)
+		self.Env = env
+	}
+	return self
+}
+
+func (self ParallelismSettings) limits(packageName string, workers int) (parallel, gomaxprocs int) {
+	matched := ""
+	for _, rule := range self.Rules {
+		if len(rule.Pattern) > len(matched) && matchesPattern(rule.Pattern, packageName) { // the most specific one wins
+			matched, parallel, gomaxprocs = rule.Pattern, rule.Parallel, rule.GOMAXPROCS
+		}
+	}
+	if matched != "" {
+		return parallel, gomaxprocs
+	}
+	if countParallelTests(packageName) < heavilyParallel {
+		return 0, 0 // -parallel doesn't matter much, and GOMAXPROCS is best left alone.
+	}
+	limit := self.Cap
+	if limit == 0 {
+		limit = runtime.NumCPU()
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	share := limit / workers
+	if share < 1 {
+		share = 1
+	}
+	return share, share
+}
+
+// countParallelTests counts the t.Parallel() calls in the test files of a package.
+func countParallelTests(packageName string) (count int) {
+	pkg, err := build.Default.Import(packageName, "", 0)
+	if err != nil {
+		return 0
+	}
+	files := token.NewFileSet()
+	for _, name := range append(append([]string{}, pkg.TestGoFiles...), pkg.XTestGoFiles...) {
+		parsed, err := parser.ParseFile(files, filepath.Join(pkg.Dir, name), nil, 0)
+		if err != nil {
+			continue
+		}
+		ast.Inspect(parsed, func(node ast.Node) bool {
+			if call, ok := node.(*ast.CallExpr); ok && len(call.Args) == 0 {
+				if selector, ok := call.Fun.(*ast.SelectorExpr); ok && selector.Sel.Name == "Parallel" {
+					count++
+				}
+			}
+			return true
+		})
+	}
+	return count
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) parallelism(path string, value interface{}) (settings ParallelismSettings) {
+	for key, value := range self.table(path, value) {
+		switch key {
+		case "cap":
+			settings.Cap = self.positive(path+"."+key, value)
+		case "packages":
+			for pattern, value := range self.table(path+"."+key, value) {
+				rule := ParallelismRule{Pattern: pattern}
+				for name, value := range self.table(path+"."+key+"."+pattern, value) {
+					switch name {
+					case "parallel":
+						rule.Parallel = self.positive(path+"."+key+"."+pattern+"."+name, value)
+					case "gomaxprocs":
+						rule.GOMAXPROCS = self.positive(path+"."+key+"."+pattern+"."+name, value)
+					default:
+						self.unknown(path+"."+key+"."+pattern+"."+name, name, "parallel", "gomaxprocs")
+					}
+				}
+				settings.Rules = append(settings.Rules, rule)
+			}
+		default:
+			self.unknown(path+"."+key, key, "cap", "packages")
+		}
+	}
+	sort.Slice(settings.Rules, func(i, j int) bool { return settings.Rules[i].Pattern < settings.Rules[j].Pattern })
+	return settings
+}
+
+func (self *configDecoder) positive(path string, value interface{}) int {
+	number, ok := value.(int64)
+	if !ok || number <= 0 {
+		self.fail(path, "must be a positive integer.")
+		return 0
+	}
+	return int(number)
+}
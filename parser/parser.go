@@ -0,0 +1,262 @@
+// Package parser extracts structured failures from the output of `go test -v`.
+package parser
+
+import (
+	"bufio"
+	"strings"
+	"time"
+)
+
+// Failure is the output attributed to a single failed test (or, for a package
+// that didn't build, to the package as a whole, in which case Test is blank).
+type Failure struct {
+	Test   string // the full name, including any subtest path (ie. TestThing/case_1)
+	Output string // the lines logged by (or attributed to) the test, including its --- FAIL line
+	Panic  bool   // the test panicked (so the output ends with the goroutine dump)
+
+	// For an Example function whose output didn't match its // Output: comment:
+	Example bool
+	Got     string
+	Want    string
+}
+
+// Parse attributes the lines of raw `go test -v` output to the tests that produced
+// them (following the === RUN/PAUSE/CONT/NAME markers of parallel tests) and
+// returns the failed tests in the order in which they started. A parent test that
+// failed only because of its subtests is left out in favor of those subtests.
+func Parse(output string) []Failure {
+	parser := &outputParser{tests: map[string]*test{}}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		parser.line(scanner.Text())
+	}
+	return parser.failures(output)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+type test struct {
+	name     string
+	lines    []string
+	failed   bool
+	skipped  bool
+	panicked bool
+	children int // failing subtests
+}
+
+type outputParser struct {
+	tests    map[string]*test
+	order    []*test
+	current  *test
+	panicked bool // everything after a panic belongs to the test that panicked
+	ran      bool
+}
+
+func (self *outputParser) find(name string) *test {
+	found, ok := self.tests[name]
+	if !ok {
+		found = &test{name: name}
+		self.tests[name] = found
+		self.order = append(self.order, found)
+	}
+	return found
+}
+
+func (self *outputParser) line(line string) {
+	trimmed := strings.TrimSpace(line)
+
+	if self.panicked {
+		if !isPackageSummary(trimmed) {
+			self.current.lines = append(self.current.lines, line)
+		}
+		return
+	}
+
+	for _, marker := range []string{"=== RUN", "=== CONT", "=== NAME", "=== PAUSE"} {
+		if strings.HasPrefix(trimmed, marker) {
+			self.ran = true
+			self.current = self.find(strings.TrimSpace(strings.TrimPrefix(trimmed, marker)))
+			return
+		}
+	}
+
+	if strings.HasPrefix(trimmed, "--- ") {
+		status, name := parseResultLine(trimmed)
+		if name != "" {
+			self.ran = true
+			self.current = self.find(name)
+			self.current.skipped = status == "SKIP"
+			if status == "FAIL" {
+				self.current.failed = true
+				self.current.lines = append(self.current.lines, line)
+				if parent := parentOf(name); parent != "" {
+					self.find(parent).children++
+				}
+			}
+			return
+		}
+	}
+
+	if strings.HasPrefix(trimmed, "panic: ") && self.current != nil {
+		self.panicked = true
+		self.current.failed = true
+		self.current.panicked = true
+		self.current.lines = append(self.current.lines, line)
+		return
+	}
+
+	if self.current != nil && !isPackageSummary(trimmed) && trimmed != "" {
+		self.current.lines = append(self.current.lines, line)
+	}
+}
+
+func (self *outputParser) failures(output string) (failures []Failure) {
+	if !self.ran {
+		if strings.Contains(output, "[build failed]") || strings.Contains(output, "[setup failed]") || strings.HasPrefix(output, "# ") {
+			return []Failure{{Output: output}}
+		}
+		return nil
+	}
+	for _, test := range self.order {
+		if !test.failed {
+			continue
+		}
+		if test.children > 0 && !test.panicked && len(test.lines) == 1 { // only its own --- FAIL line
+			continue
+		}
+		failure := Failure{
+			Test:   test.name,
+			Output: strings.Join(test.lines, "\n") + "\n",
+			Panic:  test.panicked,
+		}
+		if strings.HasPrefix(test.name, "Example") && !test.panicked {
+			failure.Example = true
+			failure.Got, failure.Want = parseExample(test.lines)
+		}
+		failures = append(failures, failure)
+	}
+	return failures
+}
+
+// parseExample separates the got: and want: sections that `go test` prints for an
+// Example function whose output didn't match.
+func parseExample(lines []string) (got, want string) {
+	var section *[]string
+	gotLines, wantLines := []string{}, []string{}
+	for _, line := range lines {
+		switch trimmed := strings.TrimSpace(line); {
+		case trimmed == "got:":
+			section = &gotLines
+		case trimmed == "want:" || trimmed == "want (unordered):":
+			section = &wantLines
+		case section != nil:
+			*section = append(*section, line)
+		}
+	}
+	return strings.Join(gotLines, "\n"), strings.Join(wantLines, "\n")
+}
+
+// Skip is a skipped test (or subtest), with the last line it logged (usually the
+// message given to t.Skip) as the reason.
+type Skip struct {
+	Test       string
+	Reason     string `json:",omitempty"`
+	Unexpected bool   `json:",omitempty"` // (set by the caller) the reason isn't among those expected
+}
+
+// Skips lists the skipped tests in raw `go test -v` output, in the order in which they started.
+func Skips(output string) (skips []Skip) {
+	parser := &outputParser{tests: map[string]*test{}}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		parser.line(scanner.Text())
+	}
+	for _, test := range parser.order {
+		if !test.skipped {
+			continue
+		}
+		skip := Skip{Test: test.name}
+		if len(test.lines) > 0 {
+			skip.Reason = trimLocation(strings.TrimSpace(test.lines[len(test.lines)-1]))
+		}
+		skips = append(skips, skip)
+	}
+	return skips
+}
+
+// trimLocation drops the "file_test.go:12: " that t.Log (and t.Skip) prefix lines with.
+func trimLocation(line string) string {
+	if colon := strings.Index(line, ".go:"); colon >= 0 && !strings.Contains(line[:colon], " ") {
+		if rest := line[colon+len(".go:"):]; strings.Contains(rest, ": ") {
+			return rest[strings.Index(rest, ": ")+2:]
+		}
+	}
+	return line
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// parseResultLine splits "--- FAIL: TestThing (0.01s)" into "FAIL" and "TestThing".
+func parseResultLine(line string) (status, name string) {
+	line = strings.TrimPrefix(line, "--- ")
+	colon := strings.Index(line, ": ")
+	if colon < 0 {
+		return "", ""
+	}
+	status, name = line[:colon], line[colon+2:]
+	if open := strings.LastIndex(name, " ("); open >= 0 {
+		name = name[:open]
+	}
+	return status, strings.TrimSpace(name)
+}
+
+// parseResultDuration extracts the 0.01s of "--- FAIL: TestThing (0.01s)".
+func parseResultDuration(line string) time.Duration {
+	open, close := strings.LastIndex(line, " ("), strings.LastIndex(line, ")")
+	if open < 0 || close < open {
+		return 0
+	}
+	duration, _ := time.ParseDuration(line[open+2 : close])
+	return duration
+}
+
+func parentOf(name string) string {
+	if slash := strings.LastIndex(name, "/"); slash >= 0 {
+		return name[:slash]
+	}
+	return ""
+}
+
+// isPackageSummary recognizes the lines written by `go test` itself once the tests are over.
+func isPackageSummary(line string) bool {
+	return line == "PASS" || line == "FAIL" ||
+		strings.HasPrefix(line, "FAIL\t") || strings.HasPrefix(line, "ok  \t") ||
+		strings.HasPrefix(line, "exit status ") || strings.HasPrefix(line, "coverage: ") // (-cover)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Test is the outcome of a single test (or subtest), as reported by its --- line.
+type Test struct {
+	Name     string
+	Status   string // PASS, FAIL or SKIP
+	Duration time.Duration
+}
+
+// Tests lists the outcome of every test in raw `go test -v` output, in the order reported.
+func Tests(output string) (tests []Test) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		trimmed := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(trimmed, "--- ") {
+			continue
+		}
+		if status, name := parseResultLine(trimmed); name != "" {
+			tests = append(tests, Test{Name: name, Status: status, Duration: parseResultDuration(trimmed)})
+		}
+	}
+	return tests
+}
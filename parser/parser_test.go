@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the .golden files with what the parser makes of the inputs.")
+
+// TestGolden runs each testdata/*.txt (raw `go test -v` output) through Parse, Skips
+// and Tests, and compares what they make of it with the .golden file next to it (go
+// test ./parser -update rewrites them).
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("no inputs in testdata (%v)", err)
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".txt")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := string(raw)
+			actual, err := json.MarshalIndent(struct {
+				Failures []Failure
+				Skips    []Skip
+				Tests    []Test
+			}{Parse(output), Skips(output), Tests(output)}, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual = append(actual, '\n')
+
+			golden := strings.TrimSuffix(input, ".txt") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, actual, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s (run go test ./parser -update to create it)", err)
+			}
+			if string(actual) != string(expected) {
+				t.Errorf("%s differs from what the parser made of %s:\n%s", golden, input, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const coverageFolder = "coverage" // under .scantest
+
+// PatchCoverage is the coverage of the lines changed in a package during the session
+// (see PatchCoverer): of those with statements, the ones the tests that just ran
+// covered, and the ones they didn't.
+type PatchCoverage struct {
+	Lines     int      `json:"lines"` // changed lines with statements
+	Covered   int      `json:"covered"`
+	Uncovered []string `json:"uncovered,omitempty"` // file:line (or file:first-last), relative to the working directory
+}
+
+func (self *PatchCoverage) String() string {
+	if self == nil || self.Lines == 0 {
+		return ""
+	}
+	described := fmt.Sprintf("Patch coverage: %d of %d changed lines covered (%.0f%%)", self.Covered, self.Lines, float64(self.Covered)*100/float64(self.Lines))
+	if len(self.Uncovered) > 0 {
+		described += "; not covered: " + strings.Join(self.Uncovered, ", ")
+	}
+	return described
+}
+
+// PatchCoverer measures the coverage of the lines changed since scantest started
+// (-patch-coverage): those that differ from the commit HEAD was at then (committed
+// since or not), along with the lines of new files. The packages with such lines are
+// tested with -coverprofile, and their profiles matched against the lines. A nil
+// PatchCoverer measures nothing.
+type PatchCoverer struct {
+	top    string // of the repository
+	base   string // the commit
+	folder string // of the profiles
+}
+
+func NewPatchCoverer(root string) (*PatchCoverer, error) {
+	top, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, errors.New("-patch-coverage: this isn't a git repository.")
+	}
+	base, err := exec.Command("git", "rev-parse", "--verify", "--quiet", "HEAD").Output()
+	if err != nil {
+		return nil, errors.New("-patch-coverage: there's no commit to compare with yet.")
+	}
+	return &PatchCoverer{
+		top:    strings.TrimSpace(string(top)),
+		base:   strings.TrimSpace(string(base)),
+		folder: filepath.Join(root, stateFolder, coverageFolder),
+	}, nil
+}
+
+// Changes are the lines changed in the (non-test) go files of the folder, by file
+// name.
+func (self *PatchCoverer) Changes(folder string) map[string]map[int]bool {
+	if self == nil || folder == "" {
+		return nil
+	}
+	resolved, err := filepath.EvalSymlinks(folder) // (as git has it)
+	if err != nil {
+		return nil
+	}
+	changes := map[string]map[int]bool{}
+	command := exec.Command("git", "diff", "-U0", "--no-color", "--no-ext-diff", "--dst-prefix=b/", self.base, "--", ".")
+	command.Dir = folder
+	if raw, err := command.Output(); err == nil {
+		file := ""
+		scanner := bufio.NewScanner(bytes.NewReader(raw))
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "+++ ") {
+				file = ""
+				if strings.HasPrefix(line, "+++ b/") {
+					file = filepath.Join(self.top, filepath.FromSlash(strings.TrimPrefix(line, "+++ b/")))
+				}
+			} else if match := diffHunk.FindStringSubmatch(line); match != nil && isPatchFile(resolved, file) {
+				first, _ := strconv.Atoi(match[1])
+				count := 1
+				if match[2] != "" {
+					count, _ = strconv.Atoi(match[2])
+				}
+				for number := first; number < first+count; number++ {
+					addLine(changes, filepath.Base(file), number)
+				}
+			}
+		}
+	}
+	untracked := exec.Command("git", "ls-files", "--others", "--exclude-standard", "-z", "--", ".")
+	untracked.Dir = folder
+	if raw, err := untracked.Output(); err == nil {
+		for _, name := range strings.Split(string(raw), "\x00") {
+			if file := filepath.Join(folder, filepath.FromSlash(name)); name != "" && isPatchFile(folder, file) {
+				content, _ := os.ReadFile(file)
+				for number := 1; number <= bytes.Count(content, []byte("\n"))+1; number++ {
+					addLine(changes, name, number)
+				}
+			}
+		}
+	}
+	return changes
+}
+
+var diffHunk = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// isPatchFile reports whether the file is one of the folder's go files (but its tests).
+func isPatchFile(folder, file string) bool {
+	return file != "" && filepath.Dir(file) == folder && strings.HasSuffix(file, ".go") && !strings.HasSuffix(file, "_test.go")
+}
+
+func addLine(changes map[string]map[int]bool, file string, number int) {
+	if changes[file] == nil {
+		changes[file] = map[int]bool{}
+	}
+	changes[file][number] = true
+}
+
+// Profile is the file of the package's coverage profile.
+func (self *PatchCoverer) Profile(packageName string) string {
+	os.MkdirAll(self.folder, 0755)
+	return filepath.Join(self.folder, strings.TrimSuffix(outputFilename(packageName), ".log")+".out")
+}
+
+// Measure matches the changed lines of the folder against the profile (which is
+// removed once read).
+func (self *PatchCoverer) Measure(profile, folder string, changes map[string]map[int]bool) *PatchCoverage {
+	raw, err := os.ReadFile(profile)
+	if err != nil {
+		return nil
+	}
+	os.Remove(profile)
+	statements := map[string]map[int]bool{} // key: file name, value: (key: line, value: covered)
+	for _, line := range strings.Split(string(raw), "\n") {
+		match := profileBlock.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		file := filepath.Base(match[1])
+		first, _ := strconv.Atoi(match[2])
+		last, _ := strconv.Atoi(match[3])
+		count, _ := strconv.Atoi(match[4])
+		if statements[file] == nil {
+			statements[file] = map[int]bool{}
+		}
+		for number := first; number <= last; number++ {
+			statements[file][number] = statements[file][number] || count > 0
+		}
+	}
+	coverage := &PatchCoverage{}
+	files := []string{}
+	for file := range changes {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		uncovered := []int{}
+		for number := range changes[file] {
+			covered, found := statements[file][number]
+			if !found {
+				continue // (no statement there)
+			}
+			coverage.Lines++
+			if covered {
+				coverage.Covered++
+			} else {
+				uncovered = append(uncovered, number)
+			}
+		}
+		coverage.Uncovered = append(coverage.Uncovered, describeLines(relativePath(filepath.Join(folder, file)), uncovered)...)
+	}
+	return coverage
+}
+
+// profileBlock is a line of a coverage profile: file:line.column,line.column statements count.
+var profileBlock = regexp.MustCompile(`^(.+\.go):(\d+)\.\d+,(\d+)\.\d+ \d+ (\d+)$`)
+
+// describeLines lists the lines as ranges, ie. "a.go:12-14".
+func describeLines(file string, numbers []int) (ranges []string) {
+	sort.Ints(numbers)
+	for x := 0; x < len(numbers); {
+		y := x
+		for y+1 < len(numbers) && numbers[y+1] == numbers[y]+1 {
+			y++
+		}
+		if x == y {
+			ranges = append(ranges, fmt.Sprintf("%s:%d", file, numbers[x]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%s:%d-%d", file, numbers[x], numbers[y]))
+		}
+		x = y + 1
+	}
+	return ranges
+}
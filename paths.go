@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// diskPath is the (existing) path as the file system lists it, so that it compares
+// equal to the paths found by the scanner: macOS may list names with their accents
+// decomposed (NFD), where git, terminals and the clipboard compose them (NFC), and
+// both open the same file. The names that are listed as given are left alone.
+func diskPath(path string) string {
+	target, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	folder, name := filepath.Split(filepath.Clean(path))
+	if name == "" || folder == "" {
+		return path
+	}
+	folder = diskPath(filepath.Clean(folder))
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return filepath.Join(folder, name)
+	}
+	for _, entry := range entries {
+		if entry.Name() == name {
+			return filepath.Join(folder, name)
+		}
+	}
+	for _, entry := range entries {
+		if info, err := os.Stat(filepath.Join(folder, entry.Name())); err == nil && os.SameFile(info, target) {
+			return filepath.Join(folder, entry.Name())
+		}
+	}
+	return filepath.Join(folder, name)
+}
+
+// isWithin reports whether the relative path (see filepath.Rel) stays within the
+// folder it's relative to (a folder may well be named "..hidden").
+func isWithin(relative string) bool {
+	return relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// splitCommandLine splits a command (of a plugin) into its arguments, at the spaces
+// outside of quotes ('single' or "double"), so that `"/Users/me/Google Drive/x.sh" -v`
+// works. A command that names an existing file, spaces and all, is left whole.
+func splitCommandLine(line string) (fields []string) {
+	line = strings.TrimSpace(line)
+	if isFile(line) {
+		return []string{line}
+	}
+	field, quote, started := new(strings.Builder), rune(0), false
+	for _, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote, started = c, true
+		case c == ' ' || c == '\t':
+			if started {
+				fields = append(fields, field.String())
+				field.Reset()
+				started = false
+			}
+		default:
+			field.WriteRune(c)
+			started = true
+		}
+	}
+	if started {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
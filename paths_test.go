@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	folder := filepath.Join(t.TempDir(), "Google Drive")
+	if err := os.Mkdir(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(folder, "notify me.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		line     string
+		expected []string
+	}{
+		{"notify -v", []string{"notify", "-v"}},
+		{"  notify   -v\t--all ", []string{"notify", "-v", "--all"}},
+		{`"/Users/me/Google Drive/x.sh" -v`, []string{"/Users/me/Google Drive/x.sh", "-v"}},
+		{`'/Users/me/Google Drive/x.sh' "two words" ''`, []string{"/Users/me/Google Drive/x.sh", "two words", ""}},
+		{`/opt/x"y z"w`, []string{"/opt/xy zw"}},
+		{"/Users/me/Google Drive/x.sh -v", []string{"/Users/me/Google", "Drive/x.sh", "-v"}}, // (no such file, so split)
+		{script, []string{script}},
+		{" " + script + " ", []string{script}},
+		{"/Users/me/Café/x.sh -v", []string{"/Users/me/Café/x.sh", "-v"}},
+		{"", nil},
+	} {
+		if actual := splitCommandLine(test.line); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("splitCommandLine(%q) = %q, want %q", test.line, actual, test.expected)
+		}
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	separator := string(filepath.Separator)
+	for _, test := range []struct {
+		relative string
+		expected bool
+	}{
+		{".", true},
+		{"a", true},
+		{"a" + separator + "b", true},
+		{"..hidden", true},
+		{"..hidden" + separator + "a.go", true},
+		{"...", true},
+		{"a" + separator + "..", true}, // (as filepath.Rel never has it, but harmless)
+		{"..", false},
+		{".." + separator + "a", false},
+		{".." + separator + ".." + separator + "a", false},
+	} {
+		if actual := isWithin(test.relative); actual != test.expected {
+			t.Errorf("isWithin(%q) = %t, want %t", test.relative, actual, test.expected)
+		}
+	}
+}
+
+func TestFileReference(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		expected []string // path, line, column
+	}{
+		{"a_test.go:12: wrong", []string{"a_test.go", "12", ""}},
+		{"./store/store.go:3:28: undefined: x", []string{"./store/store.go", "3", "28"}},
+		{"\t/Users/me/Google Drive/app/main.go:12 +0x53", []string{"/Users/me/Google Drive/app/main.go", "12", ""}},
+		{"\t/home/me/my app/src/a b/x_test.go:7 +0x1d", []string{"/home/me/my app/src/a b/x_test.go", "7", ""}},
+		{`C:\Users\me\My Projects\app\main.go:4:2: undefined`, []string{`C:\Users\me\My Projects\app\main.go`, "4", "2"}},
+		{"café/crème_test.go:9: mauvais", []string{"café/crème_test.go", "9", ""}},
+		{"    日本語/テスト_test.go:15: 失敗", []string{"日本語/テスト_test.go", "15", ""}},
+		{"données/ωmega.go:1:1: expected 'package'", []string{"données/ωmega.go", "1", "1"}},
+		{"no reference here: main.go", nil},
+	} {
+		match := fileReference.FindStringSubmatch(test.text)
+		var actual []string
+		if match != nil {
+			actual = match[1:]
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("fileReference in %q: %q, want %q", test.text, actual, test.expected)
+		}
+	}
+}
+
+func TestDiskPath(t *testing.T) {
+	folder, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		composed   = "caf\u00e9"  // NFC (as git, terminals and the clipboard have it)
+		decomposed = "cafe\u0301" // NFD (as macOS may list it)
+	)
+	if err := os.MkdirAll(filepath.Join(folder, decomposed, "sub dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	listed := filepath.Join(folder, decomposed, "sub dir", "a.go")
+	if err := os.WriteFile(listed, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := diskPath(listed); actual != listed {
+		t.Errorf("diskPath(%q) = %q, want it as listed", listed, actual)
+	}
+	if missing := filepath.Join(folder, "missing.go"); diskPath(missing) != missing {
+		t.Errorf("diskPath(%q) = %q, want it left alone", missing, diskPath(missing))
+	}
+	given := filepath.Join(folder, composed, "sub dir", "a.go")
+	expected := given // (another file, or none, where names aren't normalized)
+	if _, err := os.Stat(given); err == nil {
+		expected = listed // (the same file, where they are: ie. on macOS)
+	}
+	if actual := diskPath(given); actual != expected {
+		t.Errorf("diskPath(%q) = %q, want %q", given, actual, expected)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const pauseFilename = "paused"
+
+// Pause is the switch that holds back runs (for when you're mid-refactor and don't
+// want a red waterfall for every intermediate save). It's the presence of
+// .scantest/paused, so that it can be flipped from outside (`scantest pause` and
+// `scantest resume`, an editor, a script, etc...) as well as with the pause key.
+// A nil Pause is never paused.
+type Pause struct {
+	path    string
+	changed chan struct{}
+}
+
+func NewPause(root string) *Pause {
+	return &Pause{path: filepath.Join(root, stateFolder, pauseFilename), changed: make(chan struct{}, 1)}
+}
+
+func (self *Pause) Paused() bool {
+	if self == nil {
+		return false
+	}
+	_, err := os.Stat(self.path)
+	return err == nil
+}
+
+func (self *Pause) Set(paused bool) error {
+	if self == nil {
+		return nil
+	}
+	var err error
+	if paused {
+		if err = os.MkdirAll(filepath.Dir(self.path), 0755); err == nil {
+			err = os.WriteFile(self.path, nil, 0644)
+		}
+	} else if err = os.Remove(self.path); os.IsNotExist(err) {
+		err = nil
+	}
+	select {
+	case self.changed <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+func (self *Pause) Toggle() error {
+	return self.Set(!self.Paused())
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Pauser holds the selections back while paused (the scan carries on, so nothing is
+// missed), merging them into a single run that's sent along on resume.
+type Pauser struct {
+	pause *Pause
+
+	in  chan *Selection
+	out chan *Selection
+}
+
+func (self *Pauser) ListenForever() {
+	ticker := time.NewTicker(time.Second) // (noticing `scantest pause` and `scantest resume`)
+	defer ticker.Stop()
+
+	var held *Selection
+	var changed chan struct{}
+	if self.pause != nil {
+		changed = self.pause.changed
+	}
+	paused := false
+	for {
+		select {
+		case selection := <-self.in:
+			if held != nil {
+				mergeSelection(held, selection)
+				selection = held
+			}
+			held = nil
+			if self.pause.Paused() {
+				held = selection
+				paused = self.announce(paused, held)
+				continue
+			}
+			self.out <- selection
+			continue
+		case <-ticker.C:
+		case <-changed:
+		}
+		paused = self.announce(paused, held)
+		if !paused && held != nil {
+			self.out <- held
+			held = nil
+		}
+	}
+}
+
+// announce logs the transitions between paused and running.
+func (self *Pauser) announce(was bool, held *Selection) (paused bool) {
+	paused = self.pause.Paused()
+	if paused && !was {
+		logf("Paused: changes are tracked, but nothing runs until resumed (the pause key, or `scantest resume`).")
+	} else if !paused && was && held != nil {
+		logf("Resumed: running the %s held back.", countPackages(len(held.Packages)))
+	} else if !paused && was {
+		logf("Resumed.")
+	}
+	return paused
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// runPause flips the switch of the scantest running in the working directory
+// (`scantest pause` or `scantest resume`).
+func runPause(command string) {
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = NewPause(workingDirectory).Set(command == "pause"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// plainOutput turns the colors of the console output off (-plain), for screen readers,
+// which read the escape codes out (or choke on them). With -plain, the packages are
+// also introduced by PASS or FAIL, the console isn't cleared or drawn on (see Screen),
+// file:line references aren't hyperlinks, and the runs end with a sentence rather
+// than a rule.
+func plainOutput() {
+	red, green, yellow, reset = "", "", "", ""
+	dim, boldRed, magenta = "", "", ""
+	inverse = ""
+	for x := range streamColors {
+		streamColors[x] = ""
+	}
+}
+
+// describeVerdict is the word that introduces a package with -plain.
+func describeVerdict(result Result) string {
+	if result.Status == TestsPassed {
+		return "PASS"
+	}
+	return "FAIL"
+}
+
+// summarizeRun is the sentence that ends a run with -plain.
+func summarizeRun(results []Result) string {
+	failed := 0
+	for _, result := range results {
+		if result.Status != TestsPassed {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return fmt.Sprintf("PASS: all %s passed.", countPackages(len(results)))
+	}
+	return fmt.Sprintf("FAIL: %d of %s failed.", failed, countPackages(len(results)))
+}
+
+// ring sounds the terminal bell (-bell) when a run has failures, as an audible cue (on
+// stderr, so that -raw output is left alone).
+func ring(results []Result) {
+	if exitStatus(results) != 0 {
+		fmt.Fprint(os.Stderr, "\a")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// platforms are the candidates for compiling files excluded here by their build
+// constraints (foo_linux.go on a mac, //go:build windows, etc...).
+var platforms = []Target{
+	{"linux", "amd64"}, {"linux", "arm64"}, {"darwin", "amd64"}, {"darwin", "arm64"},
+	{"windows", "amd64"}, {"windows", "arm64"}, {"freebsd", "amd64"}, {"openbsd", "amd64"},
+	{"netbsd", "amd64"}, {"dragonfly", "amd64"}, {"solaris", "amd64"}, {"illumos", "amd64"},
+	{"plan9", "amd64"}, {"aix", "ppc64"}, {"android", "arm64"}, {"ios", "arm64"},
+	{"js", "wasm"}, {"wasip1", "wasm"}, {"linux", "386"}, {"linux", "arm"},
+	{"linux", "riscv64"}, {"linux", "ppc64le"}, {"linux", "s390x"}, {"linux", "mips64"},
+}
+
+// excludedPlatforms finds a platform for each modified file of the package that's
+// excluded here by its build constraints (preferring this architecture), so that the
+// package can be compiled for it. The files that no platform includes (ie. those
+// behind custom tags) are returned as unmatched.
+func excludedPlatforms(pkg *Package) (targets []Target, unmatched []string) {
+	ignored := map[string]bool{}
+	for _, name := range pkg.Info.IgnoredGoFiles {
+		ignored[name] = true
+	}
+	candidates := append([]Target{}, platforms...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].GOARCH == runtime.GOARCH && candidates[j].GOARCH != runtime.GOARCH
+	})
+
+	found := map[Target]bool{}
+	for _, path := range pkg.ModifiedFiles {
+		name := filepath.Base(path)
+		if !ignored[name] {
+			continue
+		}
+		matched := false
+		for _, target := range candidates {
+			context := build.Default
+			context.GOOS, context.GOARCH, context.CgoEnabled = target.GOOS, target.GOARCH, false
+			if target.GOOS == build.Default.GOOS && target.GOARCH == build.Default.GOARCH {
+				continue // (excluded here, so by something other than the platform)
+			}
+			if ok, err := context.MatchFile(pkg.Info.Dir, name); err == nil && ok {
+				if !found[target] {
+					found[target] = true
+					targets = append(targets, target)
+				}
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, path)
+		}
+	}
+	return targets, unmatched
+}
+
+// describeUnmatched is the diagnostic for files that no platform compiles.
+func describeUnmatched(path string) string {
+	return fmt.Sprintf("%s changed, but it's excluded by its build constraints (custom tags?) on every platform, so it isn't compiled or tested (add -tags=... to test-args).", relativePath(path))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Plugins are external commands run once per cycle. Each receives a JSON document
+// on stdin and must write the (possibly altered) document to stdout:
+//
+//   - filter plugins receive and return {"packages": ["import/path", ...]}, the
+//     packages selected for testing.
+//   - results plugins receive and return the same JSON document printed by -web
+//     (the run of the run-end message): the packages are taken back (unless it
+//     wrote none), and its new diagnostics added.
+//
+// A plugin that fails (or writes garbage) is skipped and reported as a diagnostic.
+type Plugin string
+
+func (self Plugin) exchange(environment []string, input, output interface{}) error {
+	fields := splitCommandLine(string(self))
+	if len(fields) == 0 {
+		return fmt.Errorf("Plugin command is blank.")
+	}
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	stderr := new(bytes.Buffer)
+	command := exec.Command(fields[0], fields[1:]...)
+	command.Stdin = bytes.NewReader(raw)
+	command.Stderr = stderr
+	command.Env = environment
+	raw, err = command.Output()
+	if err != nil {
+		return fmt.Errorf("Plugin '%s' failed: %s\n%s", self, err, stderr)
+	}
+	if err = json.Unmarshal(raw, output); err != nil {
+		return fmt.Errorf("Plugin '%s' wrote invalid JSON: %s", self, err)
+	}
+	return nil
+}
+
+// PluginList implements flag.Value so that a plugin flag may be repeated.
+type PluginList []Plugin
+
+func (self *PluginList) String() string { return fmt.Sprint(*self) }
+func (self *PluginList) Set(value string) error {
+	*self = append(*self, Plugin(value))
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+type JSONSelection struct {
+	Packages []string `json:"packages"`
+}
+
+type PluginFilter struct {
+	config  *ConfigWatcher
+	plugins PluginList
+	focus   *Focus // see the filter line command
+
+	in  chan *Selection
+	out chan *Selection
+}
+
+func (self *PluginFilter) ListenForever() {
+	for selection := range self.in {
+		self.focus.apply(selection)
+		for _, plugin := range self.plugins {
+			input := JSONSelection{Packages: []string{}}
+			for packageName := range selection.Packages {
+				input.Packages = append(input.Packages, packageName)
+			}
+			sort.Strings(input.Packages)
+
+			var output JSONSelection
+			if err := plugin.exchange(self.config.Settings().Environ(), input, &output); err != nil {
+				selection.Diagnostics = append(selection.Diagnostics, err.Error())
+				continue
+			}
+			selection.Packages = map[string]bool{}
+			for _, packageName := range output.Packages {
+				selection.Packages[packageName] = true
+			}
+		}
+		self.out <- selection
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+type PluginProcessor struct {
+	config  *ConfigWatcher
+	plugins PluginList
+
+	in  chan *Report
+	out chan *Report
+}
+
+func (self *PluginProcessor) ListenForever() {
+	for report := range self.in {
+		for _, plugin := range self.plugins {
+			var output JSONResult
+			if err := plugin.exchange(self.config.Settings().Environ(), newJSONResult(report), &output); err != nil {
+				report.Diagnostics = append(report.Diagnostics, err.Error())
+				continue
+			}
+			if output.Packages == nil { // (ie. a notifier that writes {}: not a verdict that nothing was tested)
+				report.Diagnostics = append(report.Diagnostics, fmt.Sprintf("Plugin '%s' wrote no packages, so its output was ignored.", plugin))
+				continue
+			}
+			report.Results = output.Packages
+			known := map[string]bool{}
+			for _, diagnostic := range report.Diagnostics {
+				known[diagnostic] = true
+			}
+			for _, diagnostic := range output.Diagnostics { // (added to, as those of earlier stages stand)
+				if !known[diagnostic] {
+					known[diagnostic] = true
+					report.Diagnostics = append(report.Diagnostics, diagnostic)
+				}
+			}
+		}
+		self.out <- report
+	}
+}
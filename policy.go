@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	policyVariable = "SCANTEST_POLICY"
+	policyFilename = "policy-%x.toml" // the copy of a policy served over HTTP (under .scantest), by hash of the URL
+	policyRefresh  = 10 * time.Minute
+)
+
+var policySource = os.Getenv(policyVariable) // (or -policy)
+
+// Policy is the config an organization mandates (ie. -race for some packages, skips
+// that aren't acceptable, a minimum coverage), shared by way of a file (in a mounted
+// repository, say) or a URL, and named with -policy or $SCANTEST_POLICY. It takes the
+// same settings as .scantest.toml, beneath the global config and the project's (see
+// loadLayeredConfig). A policy served over HTTP is fetched every policyRefresh, and a
+// copy is kept under .scantest for when it can't be. A nil Policy has no file.
+type Policy struct {
+	source string
+	path   string
+
+	mutex   sync.Mutex
+	checked time.Time
+	problem string // the last one logged
+}
+
+func NewPolicy(root, source string) *Policy {
+	if source == "" {
+		return nil
+	}
+	if isPolicyURL(source) {
+		hash := fnv.New32a()
+		hash.Write([]byte(source))
+		return &Policy{source: source, path: filepath.Join(root, stateFolder, fmt.Sprintf(policyFilename, hash.Sum32()))}
+	}
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(root, source)
+	}
+	return &Policy{source: source, path: source}
+}
+
+func isPolicyURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// Path is the file to load (fetching a policy served over HTTP, when the copy is due
+// for a refresh), or "" when there's none (which is logged).
+func (self *Policy) Path() string {
+	if self == nil {
+		return ""
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if isPolicyURL(self.source) && time.Since(self.checked) >= policyRefresh {
+		self.checked = time.Now()
+		if info, err := os.Stat(self.path); err != nil || time.Since(info.ModTime()) >= policyRefresh {
+			self.report(self.fetch())
+		}
+	}
+	if !isFile(self.path) {
+		if !isPolicyURL(self.source) { // (the failure to fetch it was reported)
+			self.report(fmt.Sprintf("Policy: %s isn't there (so no policy applies).", self.source))
+		}
+		return ""
+	}
+	return self.path
+}
+
+func (self *Policy) fetch() string {
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(self.source)
+	if err == nil && response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s", response.Status)
+	}
+	var raw []byte
+	if err == nil {
+		raw, err = io.ReadAll(response.Body)
+	}
+	if response != nil {
+		response.Body.Close()
+	}
+	if err != nil {
+		if isFile(self.path) {
+			return fmt.Sprintf("Policy: couldn't fetch %s (%s), so the copy in %s applies.", self.source, err, relativePath(self.path))
+		}
+		return fmt.Sprintf("Policy: couldn't fetch %s (%s).", self.source, err)
+	}
+	if existing, err := os.ReadFile(self.path); err == nil && bytes.Equal(existing, raw) {
+		os.Chtimes(self.path, time.Now(), time.Now()) // (fresh, and the same)
+		return ""
+	}
+	if err := os.MkdirAll(filepath.Dir(self.path), 0755); err != nil {
+		return "Policy: " + err.Error()
+	}
+	temporary := self.path + ".tmp"
+	if err := os.WriteFile(temporary, raw, 0644); err != nil {
+		return "Policy: " + err.Error()
+	}
+	if err := os.Rename(temporary, self.path); err != nil {
+		return "Policy: " + err.Error()
+	}
+	return ""
+}
+
+// report logs a problem, unless it's the one last logged.
+func (self *Policy) report(problem string) {
+	if problem != "" && problem != self.problem {
+		logf("%s", problem)
+	}
+	self.problem = problem
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// enforcePolicy fails a package that passed, but skipped tests for a reason matching
+// one of the forbidden-skips of the config, or whose coverage is below its
+// min-coverage (see PackageRule):
+//
+//	forbidden-skips = ["TODO", "flaky"]
+//	min-coverage = 60
+//
+//	[packages."example.com/app/billing/..."]
+//	test-args = ["-race"]
+//	min-coverage = 80
+func enforcePolicy(result *Result, settings Settings) {
+	if result.Status != TestsPassed {
+		return
+	}
+	violations := []string{}
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range settings.ForbiddenSkips {
+		if compiled, err := regexp.Compile(pattern); err == nil {
+			patterns = append(patterns, compiled)
+		}
+	}
+	for _, skip := range result.Skipped {
+		for _, pattern := range patterns {
+			if pattern.MatchString(skip.Reason) {
+				violations = append(violations, fmt.Sprintf("%s was skipped (%s), which the config forbids (%s)", skip.Test, skip.Reason, pattern))
+				break
+			}
+		}
+	}
+	if minimum := settings.minCoverage(result.PackageName); minimum > 0 {
+		if coverage, found := parseCoverage(result.Output); found && coverage < minimum {
+			violations = append(violations, fmt.Sprintf("coverage: %.1f%% of statements, below the minimum of %g%%", coverage, minimum))
+		}
+	}
+	if len(violations) == 0 {
+		return
+	}
+	result.Status = TestsFailed
+	result.Output += "\nFAIL (policy)\n"
+	for _, violation := range violations {
+		result.Failures = append(result.Failures, violation)
+		result.Output += "    " + violation + "\n"
+	}
+}
+
+// minCoverage is the minimum coverage (a percentage) of the package (0: none).
+func (self Settings) minCoverage(packageName string) float64 {
+	if rule := self.packageRule(packageName); rule.MinCoverage > 0 {
+		return rule.MinCoverage
+	}
+	return self.MinCoverage
+}
+
+// parseCoverage finds the coverage go test -cover reports (the lowest, should there
+// be several).
+func parseCoverage(output string) (coverage float64, found bool) {
+	for _, line := range strings.Split(output, "\n") {
+		if index := strings.Index(line, "coverage: "); index >= 0 {
+			field := strings.Fields(line[index+len("coverage: "):])
+			if len(field) == 0 || !strings.HasSuffix(field[0], "%") {
+				continue
+			}
+			if value, err := strconv.ParseFloat(strings.TrimSuffix(field[0], "%"), 64); err == nil && (!found || value < coverage) {
+				coverage, found = value, true
+			}
+		}
+	}
+	return coverage, found
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) percentage(path string, value interface{}) float64 {
+	number, ok := 0.0, true
+	switch value := value.(type) {
+	case float64:
+		number = value
+	case int64:
+		number = float64(value)
+	default:
+		ok = false
+	}
+	if !ok || number < 0 || number > 100 {
+		self.fail(path, "must be a percentage (between 0 and 100).")
+	}
+	return number
+}
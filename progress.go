@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const progressInterval = 5 * time.Second
+
+// RunProgress is how far along a run is (in the progress line, the -web protocol and
+// .scantest/status.json).
+type RunProgress struct {
+	Done      int           `json:"done"`
+	Total     int           `json:"total"`
+	Remaining time.Duration `json:"remaining,omitempty"` // 0: unknown (see Progress)
+}
+
+func (self RunProgress) String() string {
+	counts := fmt.Sprintf("%d of %d packages done", self.Done, self.Total)
+	if self.Remaining <= 0 {
+		return counts
+	}
+	if self.Remaining < time.Second {
+		return "<1s remaining, " + counts
+	}
+	return fmt.Sprintf("~%s remaining, %s", self.Remaining.Round(time.Second), counts)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Progress tracks the packages of a run as they start and finish, estimating what's
+// left from the durations of the previous runs (see History.Estimate), the way
+// schedule predicts the whole run: the packages left are handed to the worker that
+// frees up first, starting from what's left of those still running (as estimated).
+// The time remaining is unknown when a package left has no history.
+type Progress struct {
+	mutex     sync.Mutex
+	estimates map[string]time.Duration
+	queued    []string // in the order they'll start
+	running   map[string]time.Time
+	workers   int
+	done      int
+}
+
+func NewProgress(history *History, queue []string, workers int) *Progress {
+	if workers < 1 {
+		workers = 1
+	}
+	estimates := map[string]time.Duration{}
+	for _, packageName := range queue {
+		estimates[packageName] = history.Estimate(packageName)
+	}
+	return &Progress{
+		estimates: estimates,
+		queued:    append([]string{}, queue...),
+		running:   map[string]time.Time{},
+		workers:   workers,
+	}
+}
+
+func (self *Progress) Start(packageName string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for x, queued := range self.queued {
+		if queued == packageName {
+			self.queued = append(self.queued[:x], self.queued[x+1:]...)
+			break
+		}
+	}
+	self.running[packageName] = time.Now()
+}
+
+func (self *Progress) Done(packageName string) RunProgress {
+	self.mutex.Lock()
+	delete(self.running, packageName)
+	self.done++
+	self.mutex.Unlock()
+	return self.Current()
+}
+
+func (self *Progress) Current() RunProgress {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	progress := RunProgress{Done: self.done, Total: self.done + len(self.running) + len(self.queued)}
+	progress.Remaining = self.remaining(time.Now())
+	return progress
+}
+
+func (self *Progress) remaining(now time.Time) (remaining time.Duration) {
+	loads := make([]time.Duration, 0, self.workers)
+	for packageName, started := range self.running {
+		estimate := self.estimates[packageName]
+		if estimate == 0 {
+			return 0
+		}
+		left := estimate - now.Sub(started)
+		if left < time.Second {
+			left = time.Second // (overdue: it's still running, after all)
+		}
+		loads = append(loads, left)
+	}
+	for len(loads) < self.workers {
+		loads = append(loads, 0)
+	}
+	for _, packageName := range self.queued {
+		if self.estimates[packageName] == 0 {
+			return 0
+		}
+		least := 0
+		for x := range loads {
+			if loads[x] < loads[least] {
+				least = x
+			}
+		}
+		loads[least] += self.estimates[packageName]
+	}
+	for _, load := range loads {
+		if load > remaining {
+			remaining = load
+		}
+	}
+	return remaining
+}
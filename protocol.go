@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Message types of the -web protocol (one JSON object per line on stdout, relayed
+// to the browser by websocketd):
+const (
+	messageRunStart      = "run-start"      // Banner, Packages (in the order they'll start), Predicted, Triggers
+	messagePackageResult = "package-result" // Result, as soon as a package is done (before any results plugin)
+	messageProgress      = "progress"       // Progress (and Text, describing it) as packages finish
+	messageRunEnd        = "run-end"        // Run: the complete (and final) results, as listed by earlier versions
+	messageLog           = "log"            // Text: informational messages (config changes, throttling, etc...)
+	messageHeartbeat     = "heartbeat"      // sent every heartbeatInterval, so the browser knows scantest is alive
+)
+
+const heartbeatInterval = 15 * time.Second
+
+type Message struct {
+	Type      string        `json:"type"`
+	Time      time.Time     `json:"time"`
+	Banner    string        `json:"banner,omitempty"`
+	Packages  []string      `json:"packages,omitempty"`
+	Predicted time.Duration `json:"predicted,omitempty"`
+	Triggers  []Trigger     `json:"triggers,omitempty"`
+	Result    *Result       `json:"result,omitempty"`
+	Progress  *RunProgress  `json:"progress,omitempty"`
+	Run       *JSONResult   `json:"run,omitempty"`
+	Text      string        `json:"text,omitempty"`
+}
+
+// Protocol writes messages (whole lines, never interleaved) and/or publishes them
+// to the subscribers of a hub (see -serve). A nil Protocol (the console) sends nothing.
+type Protocol struct {
+	mutex  sync.Mutex
+	writer io.Writer // nil: the console keeps stdout
+	hub    *Hub
+}
+
+func NewProtocol(writer io.Writer, hub *Hub) *Protocol {
+	return &Protocol{writer: writer, hub: hub}
+}
+
+// Console reports whether the results are still printed to the console (ie. stdout
+// isn't reserved for messages).
+func (self *Protocol) Console() bool {
+	return self == nil || self.writer == nil
+}
+
+func (self *Protocol) Send(message Message) {
+	if self == nil {
+		return
+	}
+	message.Time = time.Now()
+	self.hub.Publish(message)
+	if self.writer == nil {
+		return
+	}
+	raw, err := json.Marshal(message)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.writer.Write(append(raw, '\n'))
+}
+
+func (self *Protocol) HeartbeatForever() {
+	for {
+		time.Sleep(heartbeatInterval)
+		self.Send(Message{Type: messageHeartbeat})
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// protocol is set in -web (and -serve) mode, so that logf reaches the browser as well.
+var protocol *Protocol
+
+// logf reports something informational on stderr (and to the browser, in -web mode).
+func logf(format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	fmt.Fprintln(os.Stderr, text)
+	protocol.Send(Message{Type: messageLog, Text: text})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const redacted = "[redacted]"
+
+// Redactor masks the secrets that tests log (tokens, passwords, connection strings,
+// etc...) in their output, by way of the regular expressions of the redact setting
+// of .scantest.toml, before the output goes anywhere (the console, -stream, files,
+// plugins, -web and -serve):
+//
+//	redact = ['postgres://\S+', '(?i)token=(\S+)']
+//
+// The whole match is masked, unless the expression has groups, in which case only
+// the groups are (so that `token=` is kept, for context).
+type Redactor []*regexp.Regexp
+
+func NewRedactor(patterns []string) (redactor Redactor) {
+	for _, pattern := range patterns {
+		if compiled, err := regexp.Compile(pattern); err == nil { // (validated by the config decoder)
+			redactor = append(redactor, compiled)
+		}
+	}
+	return redactor
+}
+
+func (self Redactor) Redact(text string) string {
+	for _, pattern := range self {
+		matches := pattern.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		masked, last := new(strings.Builder), 0
+		for _, match := range matches {
+			spans := [][]int{match[:2]}
+			if len(match) > 2 {
+				spans = nil
+				for group := 2; group+1 < len(match); group += 2 {
+					if match[group] >= last && match[group+1] > match[group] {
+						spans = append(spans, match[group:group+2])
+					}
+				}
+			}
+			for _, span := range spans {
+				masked.WriteString(text[last:span[0]])
+				masked.WriteString(redacted)
+				last = span[1]
+			}
+		}
+		masked.WriteString(text[last:])
+		text = masked.String()
+	}
+	return text
+}
+
+// result masks the output of the result (and what's derived from it).
+func (self Redactor) result(result Result) Result {
+	if len(self) == 0 {
+		return result
+	}
+	result.Output = self.Redact(result.Output)
+	failures := []string{}
+	for _, failure := range result.Failures {
+		failures = append(failures, self.Redact(failure))
+	}
+	if result.Failures != nil {
+		result.Failures = failures
+	}
+	skipped := append(result.Skipped[:0:0], result.Skipped...)
+	for x := range skipped {
+		skipped[x].Reason = self.Redact(skipped[x].Reason)
+	}
+	result.Skipped = skipped
+	return result
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// RemoteSettings send the `go test` commands of some (or all) packages to another
+// machine (the [remote] table of .scantest.toml), ie. a beefy one for the race or
+// integration tests a laptop struggles with: over SSH, or to a `scantest agent`
+// running there (see runAgent). The working directory is found there at dir (the
+// same path by default, as on a shared file system), and is kept in sync with rsync
+// (over SSH) before each run when sync is true. Everything else (go generate, the
+// validators, etc...) still happens here.
+//
+//	[remote]
+//	ssh = "me@builder"                  # or agent = "localhost:7070" (by way of an SSH tunnel)
+//	dir = "/home/me/src/app"
+//	sync = true
+//	packages = ["example.com/app/integration/..."]
+type RemoteSettings struct {
+	SSH      string
+	Agent    string
+	Dir      string
+	Sync     bool
+	Packages []string // patterns (see matchesPattern): those tested remotely (all of them when empty)
+}
+
+func (self RemoteSettings) enabled() bool {
+	return self.SSH != "" || self.Agent != ""
+}
+
+// covers reports whether the package is tested remotely.
+func (self RemoteSettings) covers(packageName string) bool {
+	if !self.enabled() {
+		return false
+	}
+	for _, pattern := range self.Packages {
+		if matchesPattern(pattern, packageName) {
+			return true
+		}
+	}
+	return len(self.Packages) == 0
+}
+
+// command sends the (local) command over to the remote machine, in the folder that
+// corresponds to its own, with the [env] of .scantest.toml (the rest of the local
+// environment is left behind).
+func (self RemoteSettings) command(ctx context.Context, local *exec.Cmd, env map[string]string) *exec.Cmd {
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return local
+	}
+	folder := local.Dir
+	if folder == "" {
+		folder = workingDirectory
+	} else if !filepath.IsAbs(folder) {
+		folder = filepath.Join(workingDirectory, folder)
+	}
+	if self.Dir != "" {
+		relative, err := filepath.Rel(workingDirectory, folder)
+		if err != nil || !isWithin(relative) {
+			return local // (outside of the working directory, so not synced)
+		}
+		folder = filepath.ToSlash(filepath.Join(self.Dir, relative))
+	}
+	job := AgentJob{Dir: folder, Args: local.Args, Env: env}
+	job.Args[0] = filepath.Base(job.Args[0]) // (found in the remote PATH)
+
+	if self.Agent != "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return local
+		}
+		raw, _ := json.Marshal(job)
+		return newCommand(ctx, executable, "agent", "run", self.Agent, string(raw))
+	}
+	return newCommand(ctx, "ssh", "-o", "BatchMode=yes", self.SSH, job.script())
+}
+
+// sync copies the working directory over to the remote one (rsync only transfers
+// what changed), serialized so that parallel calls don't step on each other.
+func (self RemoteSettings) sync(ctx context.Context) error {
+	if !self.Sync || self.SSH == "" {
+		return nil
+	}
+	remoteSyncs.Lock()
+	defer remoteSyncs.Unlock()
+	destination := self.Dir
+	if destination == "" {
+		workingDirectory, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		destination = filepath.ToSlash(workingDirectory)
+	}
+	command := newCommand(ctx, "rsync", "-az", "--delete", "--exclude=/"+stateFolder, "--exclude=.git", "-e", "ssh -o BatchMode=yes",
+		"./", self.SSH+":"+strings.TrimSuffix(destination, "/")+"/")
+	if output, err := command.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s (%s)", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+var remoteSyncs sync.Mutex
+
+// syncFor syncs at the start of a run, when any of its packages is tested remotely.
+func (self RemoteSettings) syncFor(ctx context.Context, queue []string) error {
+	for _, packageName := range queue {
+		if self.covers(packageName) {
+			return self.sync(ctx)
+		}
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// AgentJob is a command for a remote machine to run (see runAgent).
+type AgentJob struct {
+	Dir  string            `json:"dir"`
+	Args []string          `json:"args"`
+	Env  map[string]string `json:"env,omitempty"`
+}
+
+// script is the job as a line for the remote shell (over SSH).
+func (self AgentJob) script() string {
+	words := []string{"cd", shellQuote(self.Dir), "&&", "exec", "env"}
+	names := []string{}
+	for name := range self.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		words = append(words, shellQuote(name+"="+self.Env[name]))
+	}
+	for _, argument := range self.Args {
+		words = append(words, shellQuote(argument))
+	}
+	return strings.Join(words, " ")
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) remote(path string, value interface{}, remote *RemoteSettings) {
+	for key, value := range self.table(path, value) {
+		switch key {
+		case "ssh":
+			remote.SSH = self.string(path+"."+key, value)
+		case "agent":
+			remote.Agent = self.string(path+"."+key, value)
+		case "dir":
+			remote.Dir = self.string(path+"."+key, value)
+			if remote.Dir != "" && !strings.HasPrefix(remote.Dir, "/") && !filepath.IsAbs(remote.Dir) {
+				self.fail(path+"."+key, "must be an absolute path (on the remote machine).")
+			}
+		case "sync":
+			remote.Sync = self.boolean(path+"."+key, value)
+		case "packages":
+			remote.Packages = self.strings(path+"."+key, value)
+		default:
+			self.unknown(path+"."+key, key, "ssh", "agent", "dir", "sync", "packages")
+		}
+	}
+	if remote.SSH != "" && remote.Agent != "" {
+		self.fail(path+".agent", "can't be set along with ssh (tests run one way or the other).")
+	} else if remote.Sync && remote.SSH == "" {
+		self.fail(path+".sync", "requires ssh (rsync copies the files over SSH).")
+	}
+}
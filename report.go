@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// HTMLReporter renders a static, self-contained HTML page for each run into a
+// folder, named by the time of the run (latest.html is always the most recent).
+type HTMLReporter struct {
+	folder string
+}
+
+func (self *HTMLReporter) Write(report *Report) error {
+	if err := os.MkdirAll(self.folder, 0755); err != nil {
+		return err
+	}
+	now := time.Now()
+	page := newHTMLPage(now, report.Results, report.Git, report.Diagnostics)
+	buffer := new(bytes.Buffer)
+	if err := htmlTemplate.Execute(buffer, page); err != nil {
+		return err
+	}
+	name := filepath.Join(self.folder, "scantest-"+now.Format("20060102-150405")+".html")
+	if err := os.WriteFile(name, buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(self.folder, "latest.html"), buffer.Bytes(), 0644)
+}
+
+// newHTMLPage lays the (sorted) results out, printed last first like on the console.
+func newHTMLPage(now time.Time, results []Result, git *GitState, diagnostics []string) htmlPage {
+	page := htmlPage{Time: now.Format(time.RFC1123), Git: git.String(), Diagnostics: diagnostics}
+	for x := len(results) - 1; x >= 0; x-- {
+		result := results[x]
+		result.Duration = result.Duration.Round(time.Millisecond)
+		page.Packages = append(page.Packages, htmlPackage{
+			Result:   result,
+			Passed:   result.Status == TestsPassed,
+			Label:    statusLabels[result.Status],
+			Coverage: findCoverage(result.Output),
+		})
+		if result.Status == TestsPassed {
+			page.Passed++
+		} else {
+			page.Failed++
+		}
+	}
+	return page
+}
+
+// coverageReport is what `go test -cover` reports, whether on a line of its own (with
+// -v) or at the end of the ok line (ie. "ok  pkg  0.01s  coverage: 81.2% of statements").
+var coverageReport = regexp.MustCompile(`coverage: (?:[\d.]+% of statements(?: in \S+)?|\[no statements\])`)
+
+// findCoverage returns the "coverage: ..." reported by `go test -cover` (if any).
+func findCoverage(output string) string {
+	return coverageReport.FindString(output)
+}
+
+var statusLabels = map[PackageStatus]string{
+	StaleGenerated: "STALE GENERATED",
+	GenerateFailed: "GENERATE FAILED",
+	CompileFailed:  "COMPILE FAILED",
+	TestsFailed:    "FAIL",
+	TestsPassed:    "PASS",
+}
+
+type htmlPage struct {
+	Time        string
+	Git         string
+	Passed      int
+	Failed      int
+	Packages    []htmlPackage
+	Diagnostics []string
+	History     []string // the runs before (see Snapshot)
+}
+
+type htmlPackage struct {
+	Result
+	Passed   bool
+	Label    string
+	Coverage string
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>scantest report ({{.Time}})</title>
+<style>
+body { background-color: #222; color: #DDD; font-family: 'Source Code Pro', 'Monaco', 'Consolas', 'Menlo', monospace; padding: 25px 50px; }
+h1 { font-size: 18px; }
+details { margin: 15px 0; padding: 15px 25px; background-color: #111; border-radius: 10px; }
+summary { cursor: pointer; }
+pre { font-size: 12px; white-space: pre-wrap; word-wrap: break-word; }
+.pass { color: #2ECC40; }
+.fail { color: #FF4136; }
+.warn { color: #FFDC00; }
+</style>
+</head>
+<body>
+<h1 class="{{if .Failed}}fail{{else}}pass{{end}}">{{.Passed}} passed, {{.Failed}} failed &mdash; {{.Time}}{{if .Git}} &mdash; {{.Git}}{{end}}</h1>
+{{if .Diagnostics}}<details open class="warn"><summary>Diagnostics</summary><pre>{{range .Diagnostics}}{{.}}
+{{end}}</pre></details>{{end}}
+{{if .History}}<details><summary>History</summary><pre>{{range .History}}{{.}}
+{{end}}</pre></details>{{end}}
+{{range .Packages}}<details {{if not .Passed}}open{{end}} class="{{if .Passed}}pass{{else}}fail{{end}}">
+<summary>{{.Label}} {{.PackageName}} ({{.Duration}}, finished at {{.Finished.Format "15:04:05"}}){{if .Coverage}} &mdash; {{.Coverage}}{{end}}</summary>
+{{range .Failures}}<pre>{{.}}</pre>{{end}}<pre>{{.Output}}</pre>
+</details>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// RerunRule limits how often the packages matching a pattern (an import path,
+// where "..." matches anything, as with the go command) are run automatically
+// (the [rerun] table of .scantest.toml):
+//
+//	[rerun]
+//	"example.com/app/integration/..." = "5m"
+type RerunRule struct {
+	Pattern  string
+	Interval time.Duration
+}
+
+func (self RerunRule) matches(packageName string) bool {
+	return matchesPattern(self.Pattern, packageName)
+}
+
+// matchesPattern reports whether an import path matches a pattern (where "..."
+// matches anything, as with the go command).
+func matchesPattern(pattern, packageName string) bool {
+	expression := "^" + strings.Replace(regexp.QuoteMeta(pattern), `\.\.\.`, `.*`, -1) + "$"
+	if strings.HasSuffix(pattern, "/...") && packageName == strings.TrimSuffix(pattern, "/...") {
+		return true
+	}
+	matched, _ := regexp.MatchString(expression, packageName)
+	return matched
+}
+
+func rerunInterval(rules []RerunRule, packageName string) (longest time.Duration) {
+	for _, rule := range rules {
+		if rule.Interval > longest && rule.matches(packageName) {
+			longest = rule.Interval
+		}
+	}
+	return longest
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Pending is a package held back by its rerun interval.
+type Pending struct {
+	Package string    `json:"package"`
+	Due     time.Time `json:"due"`
+}
+
+// RerunLimiter holds back the packages that ran less than their rerun interval ago,
+// sending them along by themselves once they're due (or as soon as something is
+// sent on the now channel). The packages held back are listed on each selection.
+type RerunLimiter struct {
+	config *ConfigWatcher
+	now    chan struct{} // run whatever is pending right away
+
+	in  chan *Selection
+	out chan *Selection
+
+	started  map[string]time.Time // key: package, value: when it was last sent along
+	deferred map[string]bool      // key: package, value: modified
+}
+
+func (self *RerunLimiter) ListenForever() {
+	self.started = map[string]time.Time{}
+	self.deferred = map[string]bool{}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case selection := <-self.in:
+			self.limit(selection)
+			if len(selection.Packages) > 0 || len(selection.Diagnostics) > 0 || len(selection.Pending) == 0 {
+				self.out <- selection
+			}
+		case <-ticker.C:
+			if selection := self.release(false); selection != nil {
+				self.out <- selection
+			}
+		case <-self.now:
+			if selection := self.release(true); selection != nil {
+				self.out <- selection
+			}
+		}
+	}
+}
+
+func (self *RerunLimiter) limit(selection *Selection) {
+	rules := self.config.Settings().Rerun
+	now := time.Now()
+	for packageName := range selection.Packages {
+		interval := rerunInterval(rules, packageName)
+		if last, found := self.started[packageName]; found && interval > 0 && now.Sub(last) < interval {
+			self.deferred[packageName] = self.deferred[packageName] || selection.Modified[packageName]
+			delete(selection.Packages, packageName)
+			continue
+		}
+		self.started[packageName] = now
+		delete(self.deferred, packageName)
+	}
+	selection.Pending = self.pending(rules)
+}
+
+// release builds a selection of the deferred packages that are due (or all of them).
+func (self *RerunLimiter) release(all bool) *Selection {
+	if len(self.deferred) == 0 {
+		return nil
+	}
+	rules := self.config.Settings().Rerun
+	now := time.Now()
+	selection := &Selection{Packages: map[string]bool{}, Modified: map[string]bool{}}
+	for packageName, modified := range self.deferred {
+		if !all && now.Sub(self.started[packageName]) < rerunInterval(rules, packageName) {
+			continue
+		}
+		selection.Packages[packageName] = true
+		if modified {
+			selection.Modified[packageName] = true
+		}
+		selection.Triggers = append(selection.Triggers, Trigger{File: "(pending rerun)", Package: packageName})
+		self.started[packageName] = now
+		delete(self.deferred, packageName)
+	}
+	if len(selection.Packages) == 0 {
+		return nil
+	}
+	sort.Slice(selection.Triggers, func(i, j int) bool { return selection.Triggers[i].Package < selection.Triggers[j].Package })
+	selection.Pending = self.pending(rules)
+	return selection
+}
+
+func (self *RerunLimiter) pending(rules []RerunRule) (pending []Pending) {
+	for packageName := range self.deferred {
+		pending = append(pending, Pending{Package: packageName, Due: self.started[packageName].Add(rerunInterval(rules, packageName))})
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Package < pending[j].Package })
+	return pending
+}
+
+func describePending(pending []Pending) string {
+	described := []string{}
+	for _, item := range pending {
+		wait := time.Until(item.Due).Round(time.Second)
+		if wait < 0 {
+			wait = 0
+		}
+		described = append(described, fmt.Sprintf("%s (in %s)", item.Package, wait))
+	}
+	return strings.Join(described, ", ")
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) rerun(path string, value interface{}) (rules []RerunRule) {
+	for pattern, value := range self.table(path, value) {
+		interval, err := time.ParseDuration(self.string(path+"."+pattern, value))
+		if err != nil || interval <= 0 {
+			self.fail(path+"."+pattern, "must be a positive duration (ie. \"5m\").")
+			continue
+		}
+		rules = append(rules, RerunRule{Pattern: pattern, Interval: interval})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Pattern < rules[j].Pattern })
+	return rules
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// RerunFailsReport saves the tests that failed in each run to a file, a line for each
+// (`<package> <test>`, as gotestsum's --rerun-fails-report does), for other tools
+// (or -rerun-fails) to test them again. Each run replaces the file.
+type RerunFailsReport struct {
+	path string
+}
+
+func (self *RerunFailsReport) Write(report *Report) error {
+	lines := []string{}
+	for _, result := range report.Results {
+		if result.Status != TestsFailed {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, failure := range parser.Parse(result.Output) {
+			if failure.Test != "" && !seen[failure.Test] {
+				seen[failure.Test] = true
+				lines = append(lines, result.PackageName+" "+failure.Test)
+			}
+		}
+	}
+	sort.Strings(lines)
+	buffer := new(bytes.Buffer)
+	for _, line := range lines {
+		fmt.Fprintln(buffer, line)
+	}
+	if folder := filepath.Dir(self.path); folder != "." {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(self.path, buffer.Bytes(), 0644)
+}
+
+// readRerunFails reads a file of failed tests (see RerunFailsReport), giving the
+// (top-level) tests to run again in each package.
+func readRerunFails(path string) (map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tests := map[string][]string{}
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected `<package> <test>`, got %q", path, number, scanner.Text())
+		}
+		test := strings.SplitN(fields[1], "/", 2)[0] // (-run narrows to top-level tests)
+		if key := fields[0] + " " + test; !seen[key] {
+			seen[key] = true
+			tests[fields[0]] = append(tests[fields[0]], test)
+		}
+	}
+	return tests, scanner.Err()
+}
+
+// targetRerun makes the (first) selection the tests of the -rerun-fails file, in
+// place of everything.
+func (self *PackageSelector) targetRerun(selection *Selection, all []*Package) {
+	known := map[string]bool{}
+	for _, pkg := range all {
+		known[pkg.Info.ImportPath] = true
+	}
+	selection.Packages, selection.Modified, selection.Tests = map[string]bool{}, map[string]bool{}, map[string][]string{}
+	for packageName, tests := range self.rerun {
+		if !known[packageName] {
+			selection.Diagnostics = append(selection.Diagnostics, fmt.Sprintf("Not rerun (not found under %s): %s %s", self.root, packageName, strings.Join(tests, ", ")))
+			continue
+		}
+		selection.Packages[packageName] = true
+		selection.Modified[packageName] = true
+		selection.Tests[packageName] = tests
+	}
+	self.rerun = nil
+}
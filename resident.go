@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const residentThreshold = 3 * time.Second // the average duration that makes a package with a TestMain expensive
+
+// The flags of go test that are flags of the test binary (-test.<name>), and which of
+// those take no value.
+var (
+	binaryFlags     = map[string]bool{"v": true, "run": true, "skip": true, "count": true, "timeout": true, "short": true, "failfast": true, "parallel": true, "cpu": true, "bench": true, "benchtime": true, "benchmem": true, "list": true, "shuffle": true, "fullpath": true}
+	binaryBoolFlags = map[string]bool{"v": true, "short": true, "failfast": true, "benchmem": true, "fullpath": true}
+)
+
+// Resident keeps the compiled test binaries of the packages with an expensive
+// TestMain (one whose tests take residentThreshold or longer, on average, according
+// to the history), and runs them again (narrowed with -test.run, as usual) for as
+// long as nothing they're built from changes, instead of going through go test
+// (which checks, links and starts over every time). A nil Resident (without
+// -resident) keeps nothing.
+type Resident struct {
+	folder  string
+	history *History
+
+	mutex    sync.Mutex
+	binaries map[string]string // key: package, value: the key of the binary built
+}
+
+func NewResident(root string, history *History) *Resident {
+	return &Resident{folder: filepath.Join(root, stateFolder, "bin"), history: history, binaries: map[string]string{}}
+}
+
+// Command is the command that runs the compiled test binary of the package (building
+// it first, unless it's up to date), or nil when the package isn't one to keep (or
+// the binary doesn't build, in which case go test reports why).
+func (self *Resident) Command(ctx context.Context, pkg *build.Package, testArgs []string, verbose bool, settings Settings) *exec.Cmd {
+	if self == nil || pkg == nil || !hasTestMain(pkg) || self.history.Estimate(pkg.ImportPath) < residentThreshold || settings.packageRule(pkg.ImportPath).Command != "" {
+		return nil
+	}
+	buildArgs, runArgs, ok := splitTestArgs(testArgs)
+	if !ok {
+		return nil
+	}
+	environment := settings.Environ()
+	key := residentKey(pkg, buildArgs, environment, buildTags(settings))
+	path := filepath.Join(self.folder, strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(pkg.ImportPath)+".test")
+	if runtime.GOOS == "windows" {
+		path += ".exe"
+	}
+
+	self.mutex.Lock()
+	built, known := self.binaries[pkg.ImportPath]
+	self.mutex.Unlock()
+	if !known {
+		logf("Resident: %s has a TestMain (and takes ~%s), so its test binary is kept and run again until it changes.", pkg.ImportPath, self.history.Estimate(pkg.ImportPath).Round(time.Second/10))
+	}
+	if built != key || !isFile(path) {
+		if err := os.MkdirAll(self.folder, 0755); err != nil {
+			return nil
+		}
+		compile := settings.Background.apply(newCommand(ctx, "go", append(append([]string{"test", "-c", "-o", path}, buildArgs...), pkg.ImportPath)...))
+		compile.Env = environment
+		if err := compile.Run(); err != nil {
+			self.forget(pkg.ImportPath)
+			return nil
+		}
+		self.mutex.Lock()
+		self.binaries[pkg.ImportPath] = key
+		self.mutex.Unlock()
+	}
+
+	if verbose {
+		runArgs = append([]string{"-test.v"}, runArgs...)
+	}
+	command := settings.Background.apply(exec.CommandContext(ctx, path, runArgs...))
+	command.Dir = pkg.Dir // (like go test)
+	return command
+}
+
+func (self *Resident) forget(packageName string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.binaries[packageName] = ""
+}
+
+// Summarize ends the output of the binary with the line go test would have.
+func (self *Resident) Summarize(output []byte, packageName string, elapsed time.Duration, err error) []byte {
+	if err != nil {
+		return append(output, fmt.Sprintf("FAIL\t%s\t%.3fs (resident test binary)\n", packageName, elapsed.Seconds())...)
+	}
+	return append(output, fmt.Sprintf("ok  \t%s\t%.3fs (resident test binary)\n", packageName, elapsed.Seconds())...)
+}
+
+// ExitCode is the exit code go test would have had where the binary exited with
+// code (see exitCode), so that its results are told apart (and classified by the
+// exit-codes of .scantest.toml) like those of go test: the binary exits 1 when tests
+// fail, 2 when one panics or times out, and 2 as well when it stops on its flags,
+// before running anything (as go test does on flags it doesn't take); a binary that
+// died of a signal, or exited otherwise from a TestMain, fails the package.
+func (self *Resident) ExitCode(code int, output []byte) int {
+	switch {
+	case code == 0:
+		return 0
+	case code == 2 && bytes.Contains(output, []byte("Usage of ")):
+		return 2
+	default:
+		return 1
+	}
+}
+
+func hasTestMain(pkg *build.Package) bool {
+	for _, name := range append(append([]string{}, pkg.TestGoFiles...), pkg.XTestGoFiles...) {
+		if raw, err := os.ReadFile(filepath.Join(pkg.Dir, name)); err == nil && bytes.Contains(raw, []byte("func TestMain(")) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTestArgs separates the arguments of go test into those of the build and those
+// of the test binary. It's not ok with -json (whose output go test converts) or
+// -coverprofile (whose file go test puts together).
+func splitTestArgs(testArgs []string) (buildArgs, runArgs []string, ok bool) {
+	for x := 0; x < len(testArgs); x++ {
+		argument := testArgs[x]
+		if argument == "-args" || argument == "--args" {
+			return buildArgs, append(runArgs, testArgs[x+1:]...), true
+		}
+		name := strings.TrimLeft(argument, "-")
+		if equals := strings.Index(name, "="); equals >= 0 {
+			name = name[:equals]
+		}
+		switch {
+		case name == "json" || name == "coverprofile":
+			return nil, nil, false
+		case !strings.HasPrefix(argument, "-") || !binaryFlags[name]:
+			buildArgs = append(buildArgs, argument)
+		case strings.Contains(argument, "=") || binaryBoolFlags[name]:
+			runArgs = append(runArgs, "-test."+strings.TrimLeft(argument, "-"))
+		case x+1 < len(testArgs):
+			runArgs = append(runArgs, "-test."+name, testArgs[x+1])
+			x++
+		}
+	}
+	return buildArgs, runArgs, true
+}
+
+// residentKey tells apart the builds of the test binary: by the build arguments and
+// the environment, and by the files of the package and those of the packages it
+// imports (but the standard library).
+func residentKey(pkg *build.Package, buildArgs, environment, tags []string) string {
+	hash := sha256.New()
+	fmt.Fprintln(hash, buildArgs, environment)
+	tools := build.Default
+	tools.BuildTags = tags
+	seen := map[string]bool{}
+	var visit func(folder string, imports []string)
+	visit = func(folder string, imports []string) {
+		if seen[folder] {
+			return
+		}
+		seen[folder] = true
+		entries, _ := os.ReadDir(folder)
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && !entry.IsDir() {
+				fmt.Fprintln(hash, filepath.Join(folder, entry.Name()), info.Size(), info.ModTime().UnixNano())
+			}
+		}
+		for _, path := range imports {
+			if imported, err := tools.Import(path, folder, 0); err == nil && !imported.Goroot {
+				visit(imported.Dir, imported.Imports)
+			}
+		}
+	}
+	visit(pkg.Dir, append(append(append([]string{}, pkg.Imports...), pkg.TestImports...), pkg.XTestImports...))
+	return hex.EncodeToString(hash.Sum(nil))
+}
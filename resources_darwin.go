@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func systemLoad() (float64, bool) {
+	output, err := exec.Command("sysctl", "-n", "vm.loadavg").Output() // ie. "{ 1.23 1.10 0.98 }"
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(string(output)), "{}"))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	return load, err == nil
+}
+
+func onBattery() bool {
+	output, err := exec.Command("pmset", "-g", "batt").Output()
+	return err == nil && strings.Contains(string(output), "'Battery Power'")
+}
+
+func maxRSS(state *os.ProcessState) int64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return usage.Maxrss // (in bytes)
+	}
+	return 0
+}
+
+// networkFileSystem names the file system of the path when it's a network one, whose
+// modification times can't be relied on. It's "" for local file systems.
+func networkFileSystem(path string) string {
+	var stat syscall.Statfs_t
+	if syscall.Statfs(path, &stat) != nil {
+		return ""
+	}
+	name := []byte{}
+	for _, c := range stat.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	switch fstype := string(name); fstype {
+	case "nfs", "smbfs", "afpfs", "webdav", "macfuse", "osxfuse":
+		return fstype
+	}
+	return ""
+}
+
+func watchLimit() (int, bool) { return 0, false } // (FSEvents watches trees, not folders)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func systemLoad() (float64, bool) {
+	raw, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(raw))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	return load, err == nil
+}
+
+func onBattery() bool {
+	supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+	battery := false
+	for _, supply := range supplies {
+		kind := readTrimmed(filepath.Join(supply, "type"))
+		if kind == "Mains" && readTrimmed(filepath.Join(supply, "online")) == "1" {
+			return false
+		}
+		if kind == "Battery" && readTrimmed(filepath.Join(supply, "status")) == "Discharging" {
+			battery = true
+		}
+	}
+	return battery
+}
+
+func readTrimmed(path string) string {
+	raw, _ := os.ReadFile(path)
+	return strings.TrimSpace(string(raw))
+}
+
+func maxRSS(state *os.ProcessState) int64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return usage.Maxrss * 1024 // (in kilobytes)
+	}
+	return 0
+}
+
+// networkFileSystem names the file system of the path when it's a network one (or a
+// mount of the host, as with WSL, containers and VMs), whose modification times and
+// change notifications can't be relied on. It's "" for local file systems.
+func networkFileSystem(path string) string {
+	var stat syscall.Statfs_t
+	if syscall.Statfs(path, &stat) != nil {
+		return ""
+	}
+	switch uint32(stat.Type) {
+	case 0x6969:
+		return "nfs"
+	case 0x517b:
+		return "smb"
+	case 0xff534d42:
+		return "cifs"
+	case 0xfe534d42:
+		return "smb2"
+	case 0x01021997:
+		return "9p"
+	case 0x5346414f:
+		return "afs"
+	case 0x65735546:
+		return "fuse" // (sshfs, among others)
+	case 0x786f4256:
+		return "vboxsf"
+	}
+	return ""
+}
+
+// watchLimit is the number of inotify watches a user may have (a watcher needs one
+// per folder).
+func watchLimit() (int, bool) {
+	limit, err := strconv.Atoi(readTrimmed("/proc/sys/fs/inotify/max_user_watches"))
+	return limit, err == nil && limit > 0
+}
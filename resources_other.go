@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+func systemLoad() (float64, bool)         { return 0, false }
+func onBattery() bool                     { return false }
+func maxRSS(state *os.ProcessState) int64 { return 0 }
+func watchLimit() (int, bool)             { return 0, false }
+
+// networkFileSystem recognizes UNC paths (\\server\share) as network ones.
+func networkFileSystem(path string) string {
+	if strings.HasPrefix(path, `\\`) {
+		return "smb"
+	}
+	return ""
+}
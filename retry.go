@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+func exitCode(err error) int {
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode()
+	}
+	return 0
+}
+
+// retry tests a package whose tests failed without -v (see -quiet) again, with -v and
+// the retry-args of .scantest.toml (ie. -race), for the details. Should the tests
+// pass this time, the failure stands (along with both outputs).
+func (self *Runner) retry(ctx context.Context, packageName string, testArgs []string, settings Settings, quiet []byte, quietErr error, result *Result) ([]byte, error) {
+	flags := append([]string{"-v"}, settings.RetryArgs...)
+	command := settings.testCommand(ctx, packageName, append(append([]string{}, testArgs...), settings.RetryArgs...), true)
+	command.Env = settings.Environ()
+	output, spilled, err := self.combinedOutput(command, packageName)
+	if spilled != "" {
+		result.OutputFile = spilled
+	}
+	measureUsage(result, command.ProcessState)
+	if err == nil {
+		return []byte(fmt.Sprintf("%s\n(passed when tested again with %s; flaky?)\n%s", quiet, strings.Join(flags, " "), output)), quietErr
+	}
+	return []byte(fmt.Sprintf("(failed, so tested again with %s)\n%s", strings.Join(flags, " "), output)), err
+}
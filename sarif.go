@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+var validatorName = regexp.MustCompile(`^The (\S+) validator \(`)
+
+// SARIFWriter saves the findings of each run (the file:line references reported by
+// the validators, where `go vet` or a linter would run, and by compile failures,
+// which include the checks of vet that go test runs) as a SARIF 2.1 log, for GitHub
+// code scanning or an editor that speaks SARIF. Each run replaces the file.
+type SARIFWriter struct {
+	path string
+}
+
+func (self *SARIFWriter) Write(report *Report) error {
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "scantest",
+				Version:        version,
+				InformationURI: "https://github.com/smartystreets/scantest",
+			}},
+			Results: []sarifResult{},
+		}},
+	}
+	run := &log.Runs[0]
+	rules := map[string]bool{}
+	for _, result := range report.Results {
+		for _, finding := range findings(result) {
+			rules[finding.RuleID] = true
+			run.Results = append(run.Results, finding)
+		}
+	}
+	for rule := range rules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: rule})
+	}
+	sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool { return run.Tool.Driver.Rules[i].ID < run.Tool.Driver.Rules[j].ID })
+
+	raw, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	if folder := filepath.Dir(self.path); folder != "." {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(self.path, append(raw, '\n'), 0644)
+}
+
+// findings are the lines of the output of a package that failed to generate (by
+// way of a validator) or to compile that start with a reference to an existing file.
+func findings(result Result) (findings []sarifResult) {
+	rule := "compile"
+	switch result.Status {
+	case GenerateFailed:
+		rule = "generate"
+		if match := validatorName.FindStringSubmatch(result.Output); match != nil {
+			rule = match[1]
+		}
+	case CompileFailed:
+	default:
+		return nil
+	}
+	for _, line := range strings.Split(result.Output, "\n") {
+		line = strings.TrimSpace(line)
+		location := fileReference.FindStringIndex(line)
+		if location == nil || location[0] != 0 {
+			continue
+		}
+		message := strings.TrimSpace(strings.TrimPrefix(line[location[1]:], ":"))
+		if message == "" {
+			continue
+		}
+		rewriteReferences(line[:location[1]], result.PackageName, func(reference, path, line, column string) string {
+			region := sarifRegion{}
+			region.StartLine, _ = strconv.Atoi(line)
+			region.StartColumn, _ = strconv.Atoi(column)
+			findings = append(findings, sarifResult{
+				RuleID:  rule,
+				Level:   "error",
+				Message: sarifMessage{Text: message},
+				Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: artifactLocation(path),
+					Region:           region,
+				}}},
+			})
+			return reference
+		})
+	}
+	return findings
+}
+
+// artifactLocation is relative to the working directory (%SRCROOT%), where possible.
+func artifactLocation(path string) sarifArtifactLocation {
+	relative := relativePath(path)
+	if filepath.IsAbs(relative) {
+		slashed := filepath.ToSlash(relative)
+		if !strings.HasPrefix(slashed, "/") {
+			slashed = "/" + slashed // C:/... (windows)
+		}
+		return sarifArtifactLocation{URI: "file://" + (&url.URL{Path: slashed}).EscapedPath()}
+	}
+	return sarifArtifactLocation{URI: (&url.URL{Path: filepath.ToSlash(relative)}).EscapedPath(), URIBaseID: "%SRCROOT%"}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules,omitempty"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
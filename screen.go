@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Screen manages the console: optionally clearing it at the start of each run (so
+// the newest results always start at the top) and pinning a one-line summary to
+// the bottom row (by reserving that row outside of the scrolling region). A nil
+// Screen does nothing.
+type Screen struct {
+	clear  bool
+	sticky bool
+
+	mutex   sync.Mutex
+	rows    int // of the scrolling region currently set (0 when none)
+	summary string
+	started time.Time // of the current run
+}
+
+func NewScreen(clear, sticky bool) *Screen {
+	if !clear && !sticky {
+		return nil
+	}
+	if _, _, ok := terminalSize(os.Stdout.Fd()); !ok {
+		sticky = false // not a terminal (or an unsupported one).
+	}
+	return &Screen{clear: clear, sticky: sticky}
+}
+
+// Start is called as a run begins (before its banner is printed).
+func (self *Screen) Start(packages int) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.clear {
+		fmt.Print("\033[H\033[2J\033[3J") // home, clear the screen and the scrollback.
+	}
+	self.started = time.Now()
+	self.summary = fmt.Sprintf("%sRunning %s... (since %s)%s", yellow, countPackages(packages), self.started.Format("15:04:05"), reset)
+	self.draw()
+}
+
+// Progress is called as packages finish during a run (and every progressInterval).
+func (self *Screen) Progress(progress RunProgress) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.started.IsZero() {
+		return // (a late update)
+	}
+	self.summary = fmt.Sprintf("%sRunning: %s (since %s)%s", yellow, progress, self.started.Format("15:04:05"), reset)
+	self.draw()
+}
+
+// Finish is called once the results of a run are printed.
+func (self *Screen) Finish(results []Result) {
+	if self == nil {
+		return
+	}
+	passed, failed := 0, 0
+	for _, result := range results {
+		if result.Status == TestsPassed {
+			passed++
+		} else {
+			failed++
+		}
+	}
+	color := green
+	if failed > 0 {
+		color = red
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.started = time.Time{}
+	self.summary = fmt.Sprintf("%s%d passed, %d failed (%s)%s", color, passed, failed, time.Now().Format("15:04:05"), reset)
+	self.draw()
+}
+
+// Print writes the text to the console between draws of the summary. A nil Screen
+// just writes it.
+func (self *Screen) Print(text []byte) {
+	if self == nil {
+		os.Stdout.Write(text)
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	os.Stdout.Write(text)
+}
+
+// Close gives the whole terminal back to scrolling.
+func (self *Screen) Close() {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.rows > 0 {
+		fmt.Printf("\0337\033[r\0338\033[%d;1H\033[2K", self.rows)
+		self.rows = 0
+	}
+}
+
+func (self *Screen) draw() {
+	if !self.sticky {
+		return
+	}
+	rows, _, ok := terminalSize(os.Stdout.Fd())
+	if !ok || rows < 2 {
+		return
+	}
+	if rows != self.rows { // first draw, or the terminal was resized.
+		fmt.Print("\n\033[1A") // make sure the cursor isn't left on the bottom row.
+		fmt.Printf("\0337\033[1;%dr\0338", rows-1)
+		self.rows = rows
+	}
+	fmt.Printf("\0337\033[%d;1H\033[2K%s\0338", rows, self.summary)
+}
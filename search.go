@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const searchContext = 2 // lines shown before and after a match
+
+var inverse = "\033[7m"
+
+// SearchMatch is a line of the output of a package (numbered from 1) that matches a
+// search, with the (byte) ranges of the line that do.
+type SearchMatch struct {
+	Package string   `json:"package"`
+	Line    int      `json:"line"`
+	Text    string   `json:"text"`
+	Ranges  [][2]int `json:"ranges"`
+}
+
+// searchResults finds the text in the output of the results: literally, and ignoring
+// case unless the text has upper case letters of its own.
+func searchResults(results []Result, text string) (matches []SearchMatch) {
+	matches = []SearchMatch{}
+	if text == "" {
+		return matches
+	}
+	fold := strings.IndexFunc(text, unicode.IsUpper) < 0
+	if fold {
+		text = strings.ToLower(text)
+	}
+	for _, result := range results {
+		for x, line := range strings.Split(result.Output, "\n") {
+			haystack := line
+			if lower := strings.ToLower(line); fold && len(lower) == len(line) { // (so the ranges hold)
+				haystack = lower
+			}
+			ranges := [][2]int{}
+			for offset := 0; ; {
+				found := strings.Index(haystack[offset:], text)
+				if found < 0 {
+					break
+				}
+				ranges = append(ranges, [2]int{offset + found, offset + found + len(text)})
+				offset += found + len(text)
+			}
+			if len(ranges) > 0 {
+				matches = append(matches, SearchMatch{Package: result.PackageName, Line: x + 1, Text: line, Ranges: ranges})
+			}
+		}
+	}
+	return matches
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Search looks through the output of the last run on the console (see Input.press):
+// / (then the text and enter) finds the first match, after which n and N jump to the
+// next and the previous ones, across packages. A nil Search finds nothing.
+type Search struct {
+	mutex   sync.Mutex
+	results []Result
+	matches []SearchMatch
+	current int
+}
+
+func NewSearch() *Search {
+	return &Search{}
+}
+
+// Remember keeps the results of the last run (ending any search of the previous one).
+func (self *Search) Remember(results []Result) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.results = results
+	self.matches = nil
+}
+
+// Find shows the first match of the text.
+func (self *Search) Find(text string) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.matches = searchResults(self.results, text)
+	self.current = 0
+	if len(self.matches) == 0 {
+		fmt.Fprintf(os.Stderr, "No matches for %q in the output of the last run.\n", text)
+		self.matches = nil
+		return
+	}
+	packages := map[string]bool{}
+	for _, match := range self.matches {
+		packages[match.Package] = true
+	}
+	fmt.Fprintf(os.Stderr, "%d matches for %q in %s (n: next, N: previous)\n", len(self.matches), text, countPackages(len(packages)))
+	self.show()
+}
+
+// Active reports whether n and N jump between matches.
+func (self *Search) Active() bool {
+	if self == nil {
+		return false
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return len(self.matches) > 0
+}
+
+// Jump shows the next match (or, with a step of -1, the previous one).
+func (self *Search) Jump(step int) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if len(self.matches) == 0 {
+		return
+	}
+	self.current = (self.current + step + len(self.matches)) % len(self.matches)
+	self.show()
+}
+
+// Stop ends the search (so that n and N are keys again).
+func (self *Search) Stop() {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.matches = nil
+}
+
+func (self *Search) show() {
+	match := self.matches[self.current]
+	lines := []string{}
+	for _, result := range self.results {
+		if result.PackageName == match.Package {
+			lines = strings.Split(result.Output, "\n")
+			break
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s── %s (%d of %d) ──%s\n", yellow, match.Package, self.current+1, len(self.matches), reset)
+	for x := match.Line - searchContext; x <= match.Line+searchContext; x++ {
+		if x < 1 || x > len(lines) {
+			continue
+		}
+		line := lines[x-1]
+		if x == match.Line {
+			line = highlightRanges(match.Text, match.Ranges)
+		}
+		fmt.Fprintf(os.Stderr, "%s%5d%s  %s\n", dim, x, reset, line)
+	}
+}
+
+func highlightRanges(text string, ranges [][2]int) string {
+	highlighted := new(strings.Builder)
+	previous := 0
+	for _, r := range ranges {
+		highlighted.WriteString(text[previous:r[0]])
+		highlighted.WriteString(inverse + text[r[0]:r[1]] + reset)
+		previous = r[1]
+	}
+	highlighted.WriteString(text[previous:])
+	return highlighted.String()
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// serveSearch answers GET /search?q=<text>&packages=<pattern>,... with the matches of
+// the text in the output of the last run.
+func (self *Hub) serveSearch(response http.ResponseWriter, request *http.Request) {
+	self.mutex.Lock()
+	latest := self.latest
+	self.mutex.Unlock()
+	if latest == nil {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	text := request.URL.Query().Get("q")
+	message, _ := (&Subscriber{patterns: parsePatterns(request)}).filter(*latest)
+	matches := []SearchMatch{}
+	if message.Run != nil {
+		matches = searchResults(message.Run.Packages, text)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(response).Encode(struct {
+		Query   string        `json:"query"`
+		Matches []SearchMatch `json:"matches"`
+	}{text, matches})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"hash/fnv"
+	"os"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// semanticFingerprint is the semanticHash of a go file, as of its checksum (so that
+// it's only parsed again when it changes).
+type semanticFingerprint struct {
+	checksum int64
+	hash     uint64
+}
+
+// semanticChange reports whether a go file that changed (as of its checksum) changed
+// its meaning (-skip-noop), as opposed to its comments, its formatting or the names of
+// its local identifiers (see semanticHash), which gofmt and editors keep churning.
+// Files seen for the first time, and those that don't parse, are changes.
+func (self *Checksummer) semanticChange(file *File, fingerprints map[string]semanticFingerprint) bool {
+	previous, found := self.fingerprints[file.Path]
+	current := previous
+	if !found || previous.checksum != file.Checksum {
+		current = semanticFingerprint{checksum: file.Checksum, hash: semanticHash(file.Path)}
+	}
+	fingerprints[file.Path] = current
+	return !found || current.hash == 0 || current.hash != previous.hash
+}
+
+// semanticHash hashes the code of a go file as gofmt would print it without the
+// comments, and with its local identifiers (parameters, results, variables, constants,
+// types and labels declared in functions) renamed in the order they're declared, so
+// that only edits that can make a difference change it. Comments that mean something
+// to the go command (directives, build constraints and the expected output of
+// examples) are hashed too. Package-level names aren't renamed, since the other files
+// of the package may refer to them. It's 0 when the file doesn't parse, and files
+// using cgo hash as they are (their comments being C code).
+func semanticHash(path string) uint64 {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	comments, err := parser.ParseFile(token.NewFileSet(), path, source, parser.ParseComments)
+	if err != nil {
+		return 0
+	}
+	hash := fnv.New64a()
+	for _, spec := range comments.Imports {
+		if spec.Path.Value == `"C"` {
+			return uint64(contentChecksum(path))
+		}
+	}
+	for _, group := range comments.Comments {
+		text := group.Text()
+		if strings.HasPrefix(text, "Output:") || strings.HasPrefix(text, "Unordered output:") {
+			hash.Write([]byte(text))
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "//go:") || strings.HasPrefix(comment.Text, "//line ") ||
+				strings.HasPrefix(comment.Text, "// +build") || strings.HasPrefix(comment.Text, "//export ") {
+				hash.Write([]byte(comment.Text + "\n"))
+			}
+		}
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), path, source, 0)
+	if err != nil {
+		return 0
+	}
+
+	fields := map[*ast.Ident]bool{} // (renaming those would show in reflection)
+	ast.Inspect(file, func(node ast.Node) bool {
+		if structure, ok := node.(*ast.StructType); ok {
+			for _, field := range structure.Fields.List {
+				for _, name := range field.Names {
+					fields[name] = true
+				}
+			}
+		}
+		return true
+	})
+	names := map[*ast.Object]string{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		ident, ok := node.(*ast.Ident)
+		if !ok || ident.Obj == nil || ident.IsExported() || ident.Name == "_" || fields[ident] {
+			return true
+		}
+		if file.Scope.Lookup(ident.Name) == ident.Obj {
+			return true // (package-level)
+		}
+		if _, found := names[ident.Obj]; !found {
+			names[ident.Obj] = fmt.Sprintf("_%d", len(names))
+		}
+		ident.Name = names[ident.Obj]
+		return true
+	})
+	var buffer bytes.Buffer
+	if err = printer.Fprint(&buffer, token.NewFileSet(), file); err != nil {
+		return 0
+	}
+	hash.Write(buffer.Bytes())
+	return hash.Sum64()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const subscriberBacklog = 256 // messages queued for a client before it's considered gone
+
+// Hub relays the messages of the -web protocol to any number of subscribers (see
+// -serve), so that a single scantest (on a shared machine) serves a whole team and
+// its wall dashboards. Each subscriber may ask for only some packages, and gets just
+// the messages (and the parts of them) about those. A nil Hub relays nothing.
+type Hub struct {
+	mutex       sync.Mutex
+	subscribers map[*Subscriber]bool
+	latest      *Message // the last run-end
+	injector    *FileEvents
+	snapshots   *Snapshots
+	metrics     *Metrics
+}
+
+type Subscriber struct {
+	patterns []string // import paths, where "..." matches anything (none: every package)
+	messages chan []byte
+}
+
+func NewHub(events *FileEvents, snapshots *Snapshots, metrics *Metrics) *Hub {
+	return &Hub{subscribers: map[*Subscriber]bool{}, injector: events, snapshots: snapshots, metrics: metrics}
+}
+
+func (self *Hub) Publish(message Message) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if message.Type == messageRunEnd {
+		self.latest = &message
+	}
+	for subscriber := range self.subscribers {
+		filtered, relevant := subscriber.filter(message)
+		if !relevant {
+			continue
+		}
+		raw, err := json.Marshal(filtered)
+		if err != nil {
+			continue
+		}
+		select {
+		case subscriber.messages <- raw:
+		default: // too far behind (or gone), so let it go (it may reconnect).
+			delete(self.subscribers, subscriber)
+			close(subscriber.messages)
+		}
+	}
+}
+
+func (self *Hub) subscribe(patterns []string) *Subscriber {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	subscriber := &Subscriber{patterns: patterns, messages: make(chan []byte, subscriberBacklog)}
+	self.subscribers[subscriber] = true
+	return subscriber
+}
+
+func (self *Hub) unsubscribe(subscriber *Subscriber) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.subscribers[subscriber] {
+		delete(self.subscribers, subscriber)
+		close(subscriber.messages)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *Subscriber) wants(packageName string) bool {
+	if len(self.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range self.patterns {
+		if matchesPattern(pattern, packageName) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter narrows the message down to the packages of the subscriber (reporting
+// false when there's nothing left of interest).
+func (self *Subscriber) filter(message Message) (Message, bool) {
+	if len(self.patterns) == 0 {
+		return message, true
+	}
+	switch message.Type {
+	case messageRunStart:
+		packages := []string{}
+		for _, packageName := range message.Packages {
+			if self.wants(packageName) {
+				packages = append(packages, packageName)
+			}
+		}
+		message.Packages = packages
+		return message, len(packages) > 0
+	case messagePackageResult:
+		return message, message.Result != nil && self.wants(message.Result.PackageName)
+	case messageRunEnd:
+		run := *message.Run
+		run.Packages = []Result{}
+		for _, result := range message.Run.Packages {
+			if self.wants(result.PackageName) {
+				run.Packages = append(run.Packages, result)
+			}
+		}
+		run.Modules = summarizeModules(run.Packages)
+		message.Run = &run
+		return message, len(run.Packages) > 0
+	}
+	return message, true
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// ServeForever serves the hub over HTTP:
+//
+//	GET /events?packages=<pattern>,...   the messages, as server-sent events (one JSON message per event)
+//	GET /latest?packages=<pattern>,...   the last run-end message (204 before the first run is over)
+//	GET /search?q=<text>&packages=...    the lines of the output of the last run that match (see searchResults)
+//	GET /snapshot?format=html&packages=... the state of the dashboard, to download (see Snapshot)
+//	POST /modified?path=<file>&path=...  the files to take as modified, as though saved (see FileEvents)
+//	GET /output?package=<import path>    the whole output of the package in the last run (see OutputBuffer)
+//	GET /metrics                         the health of the pipeline, in the Prometheus text format (see Metrics)
+func (self *Hub) ServeForever(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", self.events)
+	mux.HandleFunc("/latest", self.serveLatest)
+	mux.HandleFunc("/search", self.serveSearch)
+	mux.HandleFunc("/snapshot", self.serveSnapshot)
+	mux.HandleFunc("/modified", self.serveModified)
+	mux.HandleFunc("/metrics", self.serveMetrics)
+	mux.HandleFunc("/output", self.serveOutput)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func (self *Hub) events(response http.ResponseWriter, request *http.Request) {
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		http.Error(response, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	subscriber := self.subscribe(parsePatterns(request))
+	defer self.unsubscribe(subscriber)
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case raw, open := <-subscriber.messages:
+			if !open {
+				return
+			}
+			if _, err := fmt.Fprintf(response, "data: %s\n\n", raw); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+func (self *Hub) serveLatest(response http.ResponseWriter, request *http.Request) {
+	self.mutex.Lock()
+	latest := self.latest
+	self.mutex.Unlock()
+	if latest == nil {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	message, _ := (&Subscriber{patterns: parsePatterns(request)}).filter(*latest)
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(response).Encode(message)
+}
+
+func parsePatterns(request *http.Request) (patterns []string) {
+	for _, pattern := range strings.Split(request.URL.Query().Get("packages"), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	defaultSettle      = 100 * time.Millisecond
+	maxUnsettledPasses = 5 // after which the files are tested as they are (ie. a file that's written all the time)
+)
+
+// unsettled stats the changed files (go files and hints) again, and once more after
+// the settle delay of .scantest.toml, and reports the first one whose size or
+// modification time moved on in the meantime (or that's gone): a scan can catch an
+// editor halfway through a save (by truncating the file and writing it again, or by
+// way of a temp file and a rename), and testing the half-written file would only end
+// in a phantom compile failure. The Checksummer lets such a pass go before tracking
+// any of its files, so that the next scan picks the file up once it's whole (up to
+// maxUnsettledPasses in a row).
+func unsettled(changed []*File, settle time.Duration) *File {
+	if len(changed) == 0 || settle <= 0 {
+		return nil
+	}
+	before := make([]os.FileInfo, len(changed))
+	for x, file := range changed {
+		info, err := os.Stat(file.Path)
+		if err != nil || info.Size() != file.Size || info.ModTime().Unix() != file.Modified {
+			return file
+		}
+		before[x] = info
+	}
+	time.Sleep(settle)
+	for x, file := range changed {
+		info, err := os.Stat(file.Path)
+		if err != nil || info.Size() != before[x].Size() || !info.ModTime().Equal(before[x].ModTime()) { // (to the nanosecond, where the file system has them)
+			return file
+		}
+	}
+	return nil
+}
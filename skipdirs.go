@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// defaultSkipDirs are the names of the folders never scanned (nor anything under
+// them): those of version control, editors, and build outputs (bazel's output
+// symlinks among them, which lead to huge trees that aren't code under test).
+var defaultSkipDirs = []string{".git", ".hg", ".svn", ".idea", ".vscode", "bazel-*", "dist", "bin", "node_modules"}
+
+// skippedFolder reports whether a folder (or a symlink to one) is left out of the scan,
+// by its name: the state folder, and those matching defaultSkipDirs or the skip-dirs
+// of .scantest.toml, which extend them (a pattern starting with ! scans the
+// folders one of the defaults would skip after all):
+//
+//	skip-dirs = ["tmp", "!bin"]
+func skippedFolder(name string, patterns []string) bool {
+	if name == stateFolder {
+		return true
+	}
+	skipped := false
+	for _, pattern := range append(append([]string{}, defaultSkipDirs...), patterns...) {
+		negated := strings.HasPrefix(pattern, "!")
+		if matched, _ := path.Match(strings.TrimPrefix(pattern, "!"), name); matched {
+			skipped = !negated
+		}
+	}
+	return skipped
+}
+
+func isSymlink(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0
+}
+
+func (self *configDecoder) skipDirs(path string, value interface{}) []string {
+	patterns := self.strings(path, value)
+	for _, pattern := range patterns {
+		name := strings.TrimPrefix(pattern, "!")
+		if _, err := filepath.Match(name, ""); err != nil || name == "" || strings.ContainsAny(name, `/\`) {
+			self.fail(path, "must be folder names, or patterns matching them (ie. \"bazel-*\"), without any slash.")
+			break
+		}
+	}
+	return patterns
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// markUnexpectedSkips flags the skips whose reasons match none of the expected ones
+// (regular expressions, the expected-skips of .scantest.toml), with -warn-skips:
+//
+//	expected-skips = ["short mode", "requires docker"]
+func markUnexpectedSkips(skips []parser.Skip, expected []string) {
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range expected {
+		if compiled, err := regexp.Compile(pattern); err == nil {
+			patterns = append(patterns, compiled)
+		}
+	}
+	for x := range skips {
+		skips[x].Unexpected = true
+		for _, pattern := range patterns {
+			if pattern.MatchString(skips[x].Reason) {
+				skips[x].Unexpected = false
+				break
+			}
+		}
+	}
+}
+
+// describeSkips collapses the skips of a package into a line, by reason.
+func describeSkips(skips []parser.Skip) string {
+	return fmt.Sprintf("%d skipped (%s)", len(skips), describeReasons(skips))
+}
+
+// describeReasons counts the skips by reason, ie. "2: short mode; 1: DB_URL isn't set".
+func describeReasons(skips []parser.Skip) string {
+	counts := map[string]int{}
+	for _, skip := range skips {
+		reason := skip.Reason
+		if reason == "" {
+			reason = "no reason given"
+		}
+		counts[reason]++
+	}
+	reasons := []string{}
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		return counts[reasons[i]] > counts[reasons[j]] || counts[reasons[i]] == counts[reasons[j]] && reasons[i] < reasons[j]
+	})
+	for x, reason := range reasons {
+		reasons[x] = fmt.Sprintf("%d: %s", counts[reason], reason)
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// summarizeSkips counts the skipped tests of a run.
+func summarizeSkips(results []Result) string {
+	skipped, packages, unexpected := 0, 0, 0
+	for _, result := range results {
+		if len(result.Skipped) > 0 {
+			packages++
+		}
+		skipped += len(result.Skipped)
+		for _, skip := range result.Skipped {
+			if skip.Unexpected {
+				unexpected++
+			}
+		}
+	}
+	if skipped == 0 {
+		return ""
+	}
+	summary := fmt.Sprintf("Skipped: %d tests in %s", skipped, countPackages(packages))
+	if unexpected > 0 {
+		summary += fmt.Sprintf(" (%d unexpected)", unexpected)
+	}
+	return summary
+}
+
+// describeNotRun collapses the tests a package left out into a line, by reason.
+func describeNotRun(tests []parser.Skip) string {
+	return fmt.Sprintf("%d not run (%s)", len(tests), describeReasons(tests))
+}
+
+// summarizeNotRun counts the tests a run left out (see unexercisedTests).
+func summarizeNotRun(results []Result) string {
+	tests, packages := 0, 0
+	for _, result := range results {
+		if len(result.NotRun) > 0 {
+			packages++
+		}
+		tests += len(result.NotRun)
+	}
+	if tests == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Not run: %d tests in %s (build constraints, -short, or unset environment variables)", tests, countPackages(packages))
+}
+
+// intersectNotRun keeps the tests that both left out (a test that any entry of a
+// matrix ran was run, see mergeMatrixResult).
+func intersectNotRun(combined, tests []parser.Skip) (common []parser.Skip) {
+	left := map[string]bool{}
+	for _, test := range tests {
+		left[test.Test] = true
+	}
+	for _, test := range combined {
+		if left[test.Test] {
+			common = append(common, test)
+		}
+	}
+	return common
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) patterns(path string, value interface{}) []string {
+	patterns := self.strings(path, value)
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			self.fail(path, "must be regular expressions (%s).", err)
+		}
+	}
+	return patterns
+}
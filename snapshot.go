@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	snapshotsFolder = "snapshots" // under .scantest
+	snapshotHistory = 20          // runs
+)
+
+// Snapshot is the state of the dashboard, to attach to a bug report or to compare
+// between branches: the results of the last run (as in the run-end message), the
+// latest runs recorded in the history (that one included) and the coverage of each
+// package.
+type Snapshot struct {
+	Time     time.Time         `json:"time"`
+	Version  string            `json:"version"`
+	Run      *JSONResult       `json:"run"`
+	History  []HistoryRecord   `json:"history,omitempty"`  // the latest snapshotHistory runs, oldest first
+	Coverage map[string]string `json:"coverage,omitempty"` // key: package, value: the line of go test -cover
+}
+
+// Snapshots keep the results of the last run, for taking snapshots of the dashboard
+// (with the snapshot key or line command, or GET /snapshot of -serve). A nil
+// Snapshots takes none.
+type Snapshots struct {
+	root    string
+	history *History
+
+	mutex sync.Mutex
+	run   *JSONResult
+}
+
+func NewSnapshots(root string, history *History) *Snapshots {
+	return &Snapshots{root: root, history: history}
+}
+
+func (self *Snapshots) Remember(run JSONResult) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.run = &run
+}
+
+// Take is the snapshot of the packages matching the patterns (all of them when there
+// are none), or false before the first run is over.
+func (self *Snapshots) Take(patterns []string) (Snapshot, bool) {
+	if self == nil {
+		return Snapshot{}, false
+	}
+	self.mutex.Lock()
+	latest := self.run
+	self.mutex.Unlock()
+	if latest == nil {
+		return Snapshot{}, false
+	}
+	filtered, _ := (&Subscriber{patterns: patterns}).filter(Message{Type: messageRunEnd, Run: latest})
+	snapshot := Snapshot{Time: time.Now(), Version: describeVersion(), Run: filtered.Run, Coverage: map[string]string{}}
+	for _, result := range snapshot.Run.Packages {
+		if coverage := findCoverage(result.Output); coverage != "" {
+			snapshot.Coverage[result.PackageName] = coverage
+		}
+	}
+	snapshot.History = self.history.Recent(snapshotHistory)
+	return snapshot, true
+}
+
+// Save writes a snapshot to the file (as HTML when its name ends with .html, and as
+// JSON otherwise), or to both a .json and an .html file under .scantest/snapshots
+// when no file is given. It returns the files written.
+func (self *Snapshots) Save(path string) ([]string, error) {
+	snapshot, ok := self.Take(nil)
+	if !ok {
+		return nil, errors.New("There's nothing to snapshot until the first run is over.")
+	}
+	paths := []string{path}
+	if path == "" {
+		stem := filepath.Join(self.root, stateFolder, snapshotsFolder, "snapshot-"+snapshot.Time.Format("20060102-150405"))
+		paths = []string{stem + ".json", stem + ".html"}
+	}
+	for _, path := range paths {
+		raw, err := snapshot.encode(isHTMLFile(path))
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0755)
+		}
+		if err == nil {
+			err = os.WriteFile(path, raw, 0644)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+func (self Snapshot) encode(html bool) ([]byte, error) {
+	if !html {
+		return json.MarshalIndent(self, "", "  ")
+	}
+	page := newHTMLPage(self.Time, self.Run.Packages, self.Run.Git, self.Run.Diagnostics)
+	for x := len(self.History) - 1; x >= 0; x-- {
+		record := self.History[x]
+		passed, failed := 0, 0
+		for _, pkg := range record.Packages {
+			if pkg.Status == TestsPassed {
+				passed++
+			} else {
+				failed++
+			}
+		}
+		line := fmt.Sprintf("%s  %3d passed, %3d failed", record.Time.Format("2006-01-02 15:04:05"), passed, failed)
+		if record.Git != nil {
+			line += "  " + record.Git.String()
+		}
+		page.History = append(page.History, line)
+	}
+	buffer := new(bytes.Buffer)
+	err := htmlTemplate.Execute(buffer, page)
+	return buffer.Bytes(), err
+}
+
+func isHTMLFile(path string) bool {
+	extension := strings.ToLower(filepath.Ext(path))
+	return extension == ".html" || extension == ".htm"
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// serveSnapshot answers GET /snapshot?format=html&packages=<pattern>,... with a
+// snapshot (JSON unless the format is html), as a file to download.
+func (self *Hub) serveSnapshot(response http.ResponseWriter, request *http.Request) {
+	snapshot, ok := self.snapshots.Take(parsePatterns(request))
+	if !ok {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	html := request.URL.Query().Get("format") == "html"
+	raw, err := snapshot.encode(html)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := "snapshot-" + snapshot.Time.Format("20060102-150405") + ".json"
+	response.Header().Set("Content-Type", "application/json")
+	if html {
+		name = strings.TrimSuffix(name, ".json") + ".html"
+		response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	response.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Write(raw)
+}
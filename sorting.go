@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// The keys of the sort setting of .scantest.toml, by which results are ordered (the
+// first ones printed last, closest to the summary), ie:
+//
+//	sort = ["status", "recent", "duration"]
+//
+// Ties are broken by package name, so the order is the same from one run to the next.
+const (
+	sortByStatus   = "status"   // failures first (those that didn't build before those whose tests failed)
+	sortByRecent   = "recent"   // packages with new failures first (see Fingerprints)
+	sortByDuration = "duration" // slowest first
+	sortByName     = "name"     // by import path
+)
+
+var (
+	sortKeys     = []string{sortByStatus, sortByRecent, sortByDuration, sortByName}
+	defaultOrder = []string{sortByStatus, sortByName}
+)
+
+// compareResults compares two results by a sort key (negative when a comes first).
+func compareResults(key string, a, b Result) int {
+	switch key {
+	case sortByStatus:
+		return int(a.Status) - int(b.Status)
+	case sortByRecent:
+		return compareFlags(len(a.NewFailures) > 0, len(b.NewFailures) > 0)
+	case sortByDuration:
+		return compareFlags(a.Duration > b.Duration, b.Duration > a.Duration)
+	case sortByName:
+		return strings.Compare(a.PackageName, b.PackageName)
+	}
+	return 0
+}
+
+// compareFlags puts the one that's true first.
+func compareFlags(a, b bool) int {
+	if a == b {
+		return 0
+	} else if a {
+		return -1
+	}
+	return 1
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) sort(path string, value interface{}) []string {
+	keys := self.strings(path, value)
+	for _, key := range keys {
+		known := false
+		for _, candidate := range sortKeys {
+			known = known || key == candidate
+		}
+		if !known {
+			self.fail(path, "must name sort keys (%s), not '%s'.", strings.Join(sortKeys, ", "), key)
+		}
+	}
+	return keys
+}
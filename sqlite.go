@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	time TEXT NOT NULL,        -- UTC, ie. 2024-01-02T15:04:05.000Z
+	branch TEXT,
+	commit_hash TEXT,
+	dirty INTEGER,             -- files with uncommitted changes
+	packages INTEGER NOT NULL,
+	failed INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS package_results (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	package TEXT NOT NULL,
+	module TEXT,
+	status TEXT NOT NULL,      -- PASS, FAIL, COMPILE FAILED or GENERATE FAILED
+	duration_ms REAL NOT NULL,
+	narrowed INTEGER NOT NULL  -- 1 when only some of the tests ran (see -narrow)
+);
+CREATE TABLE IF NOT EXISTS test_cases (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	package TEXT NOT NULL,
+	name TEXT NOT NULL,        -- including any subtest path
+	status TEXT NOT NULL,      -- PASS, FAIL or SKIP
+	duration_ms REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS package_results_by_package ON package_results (package);
+CREATE INDEX IF NOT EXISTS test_cases_by_name ON test_cases (package, name);
+`
+
+// SQLiteSink appends every run to a SQLite database (see -sqlite), for ad-hoc queries
+// over the testing history. It's written by way of the sqlite3 command, so scantest
+// itself needs no database driver. A nil SQLiteSink records nothing.
+type SQLiteSink struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func NewSQLiteSink(path string) (*SQLiteSink, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, fmt.Errorf("-sqlite: the sqlite3 command is required (see https://sqlite.org/cli.html): %s", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	self := &SQLiteSink{path: path}
+	return self, self.execute(sqliteSchema)
+}
+
+func (self *SQLiteSink) Record(results []Result, git *GitState) {
+	if self == nil || len(results) == 0 {
+		return
+	}
+	if git == nil {
+		git = &GitState{}
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Status != TestsPassed {
+			failed++
+		}
+	}
+
+	script := new(strings.Builder)
+	script.WriteString("BEGIN;\n")
+	fmt.Fprintf(script, "INSERT INTO runs (time, branch, commit_hash, dirty, packages, failed) VALUES (%s, %s, %s, %d, %d, %d);\n",
+		sqlText(time.Now().UTC().Format("2006-01-02T15:04:05.000Z")), sqlText(git.Branch), sqlText(git.Commit), git.Dirty, len(results), failed)
+	const run = "(SELECT max(id) FROM runs)"
+	for _, result := range results {
+		narrowed := 0
+		if len(result.Narrowed) > 0 {
+			narrowed = 1
+		}
+		fmt.Fprintf(script, "INSERT INTO package_results VALUES (%s, %s, %s, %s, %s, %d);\n",
+			run, sqlText(result.PackageName), sqlText(result.Module), sqlText(statusLabels[result.Status]), sqlMilliseconds(result.Duration), narrowed)
+		for _, test := range parser.Tests(result.Output) {
+			fmt.Fprintf(script, "INSERT INTO test_cases VALUES (%s, %s, %s, %s, %s);\n",
+				run, sqlText(result.PackageName), sqlText(test.Name), sqlText(test.Status), sqlMilliseconds(test.Duration))
+		}
+	}
+	script.WriteString("COMMIT;\n")
+
+	if err := self.execute(script.String()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func (self *SQLiteSink) execute(script string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	command := exec.Command("sqlite3", "-bail", self.path)
+	command.Stdin = strings.NewReader(".timeout 5000\n" + script)
+	if output, err := command.CombinedOutput(); err != nil {
+		return fmt.Errorf("sqlite3 %s: %s %s", self.path, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func sqlText(text string) string {
+	return "'" + strings.Replace(text, "'", "''", -1) + "'"
+}
+
+func sqlMilliseconds(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(duration)/float64(time.Millisecond))
+}
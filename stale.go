@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/smartystreets/gunit/gunit/generate"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Fixtures is the state of the gunit fixtures of a folder: the test files gunit reads
+// and the file it generates from them.
+type Fixtures struct {
+	Sources   string // hash of the test files (but the generated one)
+	Generated string // hash of the generated file ("" when there's none)
+}
+
+func hashFixtures(folder string) (fixtures Fixtures) {
+	sources := sha256.New()
+	entries, _ := os.ReadDir(folder)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(folder, name))
+		if err != nil {
+			continue
+		}
+		if isGeneratedFile(name) {
+			generated := sha256.Sum256(raw)
+			fixtures.Generated = hex.EncodeToString(generated[:])
+		} else {
+			fmt.Fprintln(sources, name)
+			sources.Write(raw)
+		}
+	}
+	fixtures.Sources = hex.EncodeToString(sources.Sum(nil))
+	return fixtures
+}
+
+// expectFixtures records the fixtures of the folder as gunit just left them (when the
+// output of go generate shows that it ran).
+func (self *GeneratedFiles) expectFixtures(folder, output string) {
+	if !strings.Contains(output, "gunit") {
+		return
+	}
+	fixtures := hashFixtures(folder)
+	if fixtures.Generated == "" {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.fixtures[folder] = fixtures
+}
+
+// Stale describes how the code gunit generated in the folder is out of date (the
+// fixtures changed since gunit last ran, or the generated file did), so that the
+// tests don't pass against it misleadingly. It's "" when up to date (or unknown,
+// before gunit has run).
+func (self *GeneratedFiles) Stale(folder string) string {
+	if self == nil {
+		return ""
+	}
+	self.mutex.Lock()
+	expected, found := self.fixtures[folder]
+	self.mutex.Unlock()
+	if !found {
+		return ""
+	}
+	current := hashFixtures(folder)
+	path := relativePath(filepath.Join(folder, generate.GeneratedFilename))
+	switch {
+	case current.Generated == "":
+		return ""
+	case current.Sources != expected.Sources:
+		return fmt.Sprintf("%s is out of date: the fixtures changed since gunit generated it, but go generate didn't run gunit again (is the `//go:generate gunit` directive still there?).", path)
+	case current.Generated != expected.Generated:
+		return fmt.Sprintf("%s was changed since gunit generated it (by hand, or by a checkout?), and go generate didn't generate it again.", path)
+	}
+	return ""
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// orphanedFixtures lists the fixtures (types) the code gunit generated in the folder
+// refers to, but which no other file of the folder declares anymore: their files were
+// removed (along with the go:generate directive that would have run gunit again), so
+// the generated file keeps the package from compiling until it's removed too.
+func orphanedFixtures(folder string) (missing []string) {
+	path := filepath.Join(folder, generate.GeneratedFilename)
+	generated, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		return nil
+	}
+	declared := map[string]bool{}
+	entries, _ := os.ReadDir(folder)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(folder, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil // (a file being edited, say: the compiler will tell)
+		}
+		for _, declaration := range file.Decls {
+			if general, ok := declaration.(*ast.GenDecl); ok && general.Tok == token.TYPE {
+				for _, spec := range general.Specs {
+					declared[spec.(*ast.TypeSpec).Name.Name] = true
+				}
+			}
+		}
+	}
+	referenced := map[string]bool{}
+	ast.Inspect(generated, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.CompositeLit: // &Fixture{...}
+			if name, ok := node.Type.(*ast.Ident); ok {
+				referenced[name.Name] = true
+			}
+		case *ast.CallExpr: // new(Fixture)
+			if function, ok := node.Fun.(*ast.Ident); ok && function.Name == "new" && len(node.Args) == 1 {
+				if name, ok := node.Args[0].(*ast.Ident); ok {
+					referenced[name.Name] = true
+				}
+			}
+		}
+		return true
+	})
+	for name := range referenced {
+		if !declared[name] && !isPredeclared(name) {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func isPredeclared(name string) bool {
+	return types.Universe.Lookup(name) != nil
+}
+
+// removeOrphaned deals with the code gunit generated in the folder for fixtures that
+// are gone (see orphanedFixtures): it's removed (with -remove-orphaned), or else
+// described as the problem to fix ("" when there's none).
+func removeOrphaned(folder string, remove bool) string {
+	missing := orphanedFixtures(folder)
+	if len(missing) == 0 {
+		return ""
+	}
+	path := filepath.Join(folder, generate.GeneratedFilename)
+	if !remove {
+		return fmt.Sprintf("%s was generated for fixtures that are gone (%s), so the package can't compile: remove it (or start scantest with -remove-orphaned to have that done).", relativePath(path), strings.Join(missing, ", "))
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Sprintf("%s was generated for fixtures that are gone (%s), but couldn't be removed: %s", relativePath(path), strings.Join(missing, ", "), err)
+	}
+	logf("Removed %s, which was generated for fixtures that are gone (%s).", relativePath(path), strings.Join(missing, ", "))
+	return ""
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const statusFilename = "status.json"
+
+const (
+	stateRunning = "running"
+	statePassed  = "passed"
+	stateFailed  = "failed"
+)
+
+// Status is what .scantest/status.json holds, for shell prompts, tmux status bars
+// and editor statuslines. While running, the counts are those of the previous run
+// (and Progress is that of the current one).
+type Status struct {
+	State    string       `json:"state"`
+	Passed   int          `json:"passed"`
+	Failed   int          `json:"failed"`
+	Time     time.Time    `json:"time"`
+	Progress *RunProgress `json:"progress,omitempty"`
+}
+
+// StatusFile keeps .scantest/status.json up to date (replacing it atomically, so
+// readers never see a partial file). A nil StatusFile writes nothing.
+type StatusFile struct {
+	mutex  sync.Mutex
+	path   string
+	status Status
+}
+
+func NewStatusFile(root string) *StatusFile {
+	return &StatusFile{path: filepath.Join(root, stateFolder, statusFilename)}
+}
+
+func (self *StatusFile) Running(progress RunProgress) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.status.State = stateRunning
+	self.status.Time = time.Now()
+	self.status.Progress = &progress
+	self.write()
+}
+
+func (self *StatusFile) Progress(progress RunProgress) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.status.State != stateRunning {
+		return // (a late update)
+	}
+	self.status.Progress = &progress
+	self.write()
+}
+
+func (self *StatusFile) Finished(results []Result) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.status = Status{State: statePassed, Time: time.Now()}
+	for _, result := range results {
+		if result.Status == TestsPassed {
+			self.status.Passed++
+		} else {
+			self.status.Failed++
+		}
+	}
+	if self.status.Failed > 0 {
+		self.status.State = stateFailed
+	}
+	self.write()
+}
+
+func (self *StatusFile) write() {
+	raw, err := json.Marshal(self.status)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(self.path), 0755); err != nil {
+		return
+	}
+	temporary := self.path + ".tmp"
+	if err = os.WriteFile(temporary, append(raw, '\n'), 0644); err != nil {
+		return
+	}
+	os.Rename(temporary, self.path)
+}
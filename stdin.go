@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// readLines reads stdin a line at a time (when it isn't a terminal, ie. a pipe from a
+// wrapper script, an entr or direnv hook, etc...), taking each line as a command (see
+// command) or else as keys (an empty line being enter).
+func (self *Input) readLines(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			self.press('\n')
+		} else if !self.command(strings.TrimSpace(line)) {
+			for x := 0; x < len(line); x++ {
+				self.press(line[x])
+			}
+		}
+	}
+	select {} // nothing more to read, but keep testing
+}
+
+// command carries out a line command, reporting whether the line was one:
+//
+//	run-all            run everything again (like enter)
+//	run <package>      run the tests of a package (an import path, or a folder like ./store)
+//	filter <regexp>    test only the packages matching the expression from now on (filter alone: everything)
+//	modified <file>... take the files as modified, as though saved (see FileEvents)
+//	/<text>            search the output of the last run (see Search; then n and N)
+//	snapshot [file]    save a snapshot of the last run (see Snapshots.Save)
+//	run-pending, pause, quit, help (like their keys)
+func (self *Input) command(line string) bool {
+	name, argument := line, ""
+	if space := strings.IndexAny(line, " \t"); space >= 0 {
+		name, argument = line[:space], strings.TrimSpace(line[space:])
+	}
+	if strings.HasPrefix(line, "/") {
+		self.search.Find(line[1:])
+		return true
+	}
+	switch name {
+	case actionRunAll, actionRunPending, actionPause, actionQuit, actionHelp:
+		self.idle.Touch()
+		return self.perform(name, self.config.Settings())
+	case actionSnapshot:
+		self.snapshot(argument)
+		return true
+	case "run":
+		self.idle.Touch()
+		self.run(argument)
+		return true
+	case "modified":
+		if argument == "" {
+			fmt.Fprintln(os.Stderr, "Usage: modified <file>... (relative to the working directory, or absolute)")
+		} else if err := self.events.Modified(splitCommandLine(argument)...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return true
+	case "filter":
+		if err := self.focus.Set(argument); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		} else if argument == "" {
+			logf("Filter cleared: every selected package is tested.")
+		} else {
+			logf("Filter: only the packages matching %s are tested (`filter` alone clears it).", argument)
+		}
+		return true
+	}
+	return false
+}
+
+func (self *Input) run(argument string) {
+	if argument == "" {
+		fmt.Fprintln(os.Stderr, "Usage: run <package> (an import path, or a folder like ./store)")
+		return
+	}
+	folder := diskPath(resolveFolder(self.root, argument))
+	if pkg, err := build.ImportDir(folder, 0); err != nil || len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) == 0 {
+		fmt.Fprintf(os.Stderr, "No tests to run in %s.\n", argument)
+		return
+	}
+	self.targets <- folder
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Focus narrows the runs down to the packages whose import paths match a regular
+// expression (see the filter line command). A nil Focus lets everything through.
+type Focus struct {
+	mutex   sync.Mutex
+	pattern *regexp.Regexp
+}
+
+func NewFocus() *Focus {
+	return &Focus{}
+}
+
+// Set replaces the expression ("" to let everything through).
+func (self *Focus) Set(expression string) error {
+	if self == nil {
+		return nil
+	}
+	var pattern *regexp.Regexp
+	if expression != "" {
+		var err error
+		if pattern, err = regexp.Compile(expression); err != nil {
+			return err
+		}
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.pattern = pattern
+	return nil
+}
+
+func (self *Focus) apply(selection *Selection) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	pattern := self.pattern
+	self.mutex.Unlock()
+	if pattern == nil {
+		return
+	}
+	filtered := []string{}
+	for packageName := range selection.Packages {
+		if !pattern.MatchString(packageName) {
+			delete(selection.Packages, packageName)
+			filtered = append(filtered, packageName)
+		}
+	}
+	if len(filtered) > 0 {
+		sort.Strings(filtered)
+		selection.Diagnostics = append(selection.Diagnostics, fmt.Sprintf("Filtered out (filter %s): %s", pattern, strings.Join(filtered, ", ")))
+	}
+}
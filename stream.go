@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+var streamColors = []string{"\033[36m", "\033[35m", "\033[34m", "\033[33m", "\033[32m", "\033[96m", "\033[95m", "\033[94m"}
+
+// Multiplexer interleaves the output of the packages tested at once on the console
+// (see -stream), a whole line at a time (so that the lines of packages tested at
+// once don't get mixed up), each prefixed with its package: aligned, and in a color
+// of its own. Lines go by way of the screen, so they don't garble the sticky summary.
+// A nil Multiplexer streams nothing.
+type Multiplexer struct {
+	screen *Screen
+
+	mutex  sync.Mutex
+	width  int
+	colors map[string]string
+}
+
+func NewMultiplexer(screen *Screen) *Multiplexer {
+	return &Multiplexer{screen: screen, colors: map[string]string{}}
+}
+
+// Start is called as a run begins, with the packages it's about to test.
+func (self *Multiplexer) Start(packages []string) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.width = 0
+	self.colors = map[string]string{}
+	for x, packageName := range packages {
+		if len(packageName) > self.width {
+			self.width = len(packageName)
+		}
+		self.colors[packageName] = streamColors[x%len(streamColors)]
+	}
+}
+
+func (self *Multiplexer) writer(packageName string, redactor Redactor) *streamWriter {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	prefix := fmt.Sprintf("%s%-*s |%s ", self.colors[packageName], self.width, packageName, reset)
+	return &streamWriter{prefix: prefix, redactor: redactor, multiplexer: self}
+}
+
+func (self *Multiplexer) emit(prefix string, line []byte) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.screen.Print(append([]byte(prefix), line...))
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// streamWriter hands whole lines to the multiplexer as they come.
+type streamWriter struct {
+	prefix      string
+	partial     []byte
+	redactor    Redactor
+	multiplexer *Multiplexer
+}
+
+func (self *streamWriter) Write(p []byte) (int, error) {
+	self.partial = append(self.partial, p...)
+	for {
+		end := bytes.IndexByte(self.partial, '\n')
+		if end < 0 {
+			break
+		}
+		self.multiplexer.emit(self.prefix, self.redact(self.partial[:end+1]))
+		self.partial = self.partial[end+1:]
+	}
+	return len(p), nil
+}
+
+func (self *streamWriter) Flush() {
+	if len(self.partial) > 0 {
+		self.multiplexer.emit(self.prefix, self.redact(append(self.partial, '\n')))
+		self.partial = nil
+	}
+}
+
+func (self *streamWriter) redact(line []byte) []byte {
+	if len(self.redactor) == 0 {
+		return line
+	}
+	return []byte(self.redactor.Redact(string(line)))
+}
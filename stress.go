@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/smartystreets/scantest/parser"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const stressFolder = "stress" // under .scantest
+
+// runStress implements `scantest stress <package>`, which compiles the test binary
+// of a package once and runs it over and over (for a number of iterations or for a
+// duration) to flush out flaky tests, tallying the outcomes of each test and saving
+// the output of every failed iteration. <ctrl>+c stops early (with the tally so far).
+func runStress(arguments []string) {
+	flags := flag.NewFlagSet("stress", flag.ExitOnError)
+	count := flags.Int("count", 0, "The number of iterations (100 unless -duration is given).")
+	duration := flags.Duration("duration", 0, "How long to keep running iterations (ie. -duration=5m).")
+	run := flags.String("run", "", "Only the tests matching this regular expression are run (as with `go test -run`).")
+	race := flags.Bool("race", false, "When true, the test binary is built with the race detector.")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: scantest stress [flags] <package>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(arguments)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *count == 0 && *duration == 0 {
+		*count = 100
+	}
+
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	pkg, err := build.Default.Import(flags.Arg(0), workingDirectory, build.FindOnly)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	environment := NewConfigWatcher(workingDirectory, Settings{}).Settings().Environ()
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		cancel()
+	}()
+
+	binary := filepath.Join(os.TempDir(), fmt.Sprintf("scantest-stress-%d.test", os.Getpid()))
+	defer os.Remove(binary)
+	compile := []string{"test", "-c", "-o", binary}
+	if *race {
+		compile = append(compile, "-race")
+	}
+	fmt.Println("Compiling", pkg.ImportPath, "...")
+	command := newCommand(ctx, "go", append(compile, ".")...)
+	command.Dir = pkg.Dir
+	command.Env = environment
+	if output, err := command.CombinedOutput(); err != nil {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(string(output)))
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	stress := &Stress{
+		ctx:         ctx,
+		environment: environment,
+		binary:      binary,
+		folder:      pkg.Dir,
+		run:         *run,
+		failures:    filepath.Join(workingDirectory, stateFolder, stressFolder),
+		tests:       map[string]*StressTally{},
+	}
+	deadline := time.Now().Add(*duration)
+	for iteration := 1; ctx.Err() == nil; iteration++ {
+		if *count > 0 && iteration > *count || *duration > 0 && time.Now().After(deadline) {
+			break
+		}
+		stress.iterate(iteration, pkg.ImportPath)
+	}
+	if ok := stress.report(pkg.ImportPath); !ok {
+		os.Exit(1)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+type Stress struct {
+	ctx         context.Context
+	environment []string
+	binary      string
+	folder      string
+	run         string
+	failures    string // folder for the output of failed iterations
+
+	iterations int
+	failed     int
+	saved      []string
+	tests      map[string]*StressTally // key: test name
+	elapsed    time.Duration
+}
+
+type StressTally struct {
+	Name   string
+	Passed int
+	Failed int
+}
+
+func (self *Stress) iterate(iteration int, importPath string) {
+	arguments := []string{"-test.v", "-test.count=1"}
+	if self.run != "" {
+		arguments = append(arguments, "-test.run="+self.run)
+	}
+	command := newCommand(self.ctx, self.binary, arguments...)
+	command.Dir = self.folder // like `go test`
+	command.Env = self.environment
+	started := time.Now()
+	output, err := command.CombinedOutput()
+	if self.ctx.Err() != nil {
+		return // interrupted, so the outcome means nothing.
+	}
+	self.elapsed += time.Since(started)
+	self.iterations++
+
+	for _, test := range parser.Tests(string(output)) {
+		tally, found := self.tests[test.Name]
+		if !found {
+			tally = &StressTally{Name: test.Name}
+			self.tests[test.Name] = tally
+		}
+		switch test.Status {
+		case "PASS":
+			tally.Passed++
+		case "FAIL":
+			tally.Failed++
+		}
+	}
+
+	if err == nil {
+		fmt.Printf("%s#%d passed%s\n", green, iteration, reset)
+		return
+	}
+	self.failed++
+	fmt.Printf("%s#%d FAILED%s\n", red, iteration, reset)
+	name := fmt.Sprintf("%s-%s-%d.txt", strings.Replace(importPath, "/", "_", -1), time.Now().Format("20060102-150405"), iteration)
+	path := filepath.Join(self.failures, name)
+	if err := os.MkdirAll(self.failures, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else if err := os.WriteFile(path, output, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		self.saved = append(self.saved, path)
+	}
+}
+
+// report prints the tally (flaky tests first) and reports whether every iteration passed.
+func (self *Stress) report(importPath string) bool {
+	if self.iterations == 0 {
+		fmt.Println("No iterations completed.")
+		return true
+	}
+	color := green
+	if self.failed > 0 {
+		color = red
+	}
+	fmt.Printf("\n%s%s: %d of %d iterations failed (%.1f%%), %s per iteration on average%s\n", color, importPath,
+		self.failed, self.iterations, 100*float64(self.failed)/float64(self.iterations),
+		(self.elapsed / time.Duration(self.iterations)).Round(time.Millisecond), reset)
+
+	tallies := []*StressTally{}
+	for _, tally := range self.tests {
+		if tally.Failed > 0 {
+			tallies = append(tallies, tally)
+		}
+	}
+	sort.Slice(tallies, func(i, j int) bool {
+		if tallies[i].Failed != tallies[j].Failed {
+			return tallies[i].Failed > tallies[j].Failed
+		}
+		return tallies[i].Name < tallies[j].Name
+	})
+	for _, tally := range tallies {
+		fmt.Printf("  %-60s failed %d of %d\n", tally.Name, tally.Failed, tally.Passed+tally.Failed)
+	}
+	if len(self.saved) > 0 {
+		fmt.Println("Output of the failed iterations:")
+		for _, path := range self.saved {
+			fmt.Println("  " + relativePath(path))
+		}
+	}
+	return self.failed == 0
+}
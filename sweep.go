@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+const sweepCheckInterval = 15 * time.Second
+
+// SweepSettings schedule sweeps: runs of the whole suite on top of those the changes
+// trigger, so that regressions the cascade misses (packages coupled at runtime, tests
+// that depend on the clock or the network, etc...) still show up during a long session
+// (the [sweep] table of .scantest.toml):
+//
+//	[sweep]
+//	every = "1h"            # at least this often
+//	idle = "10m"            # once nothing has changed for this long (once per quiet spell)
+//	at = ["12:30", "17:00"] # at these times of day
+type SweepSettings struct {
+	Every time.Duration
+	Idle  time.Duration
+	At    []string // HH:MM
+}
+
+func (self SweepSettings) enabled() bool {
+	return self.Every > 0 || self.Idle > 0 || len(self.At) > 0
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Sweeper runs the whole suite according to the [sweep] table (by way of the
+// Checksummer, so a paused session stays paused). The runs it triggers don't count as
+// activity for the idle mode (see Idle), so an idle sweep happens once per quiet spell.
+type Sweeper struct {
+	config *ConfigWatcher
+	idle   *Idle
+	out    chan struct{}
+
+	last  time.Time // of the latest sweep (or the start)
+	quiet time.Time // the latest activity an idle sweep followed
+}
+
+func NewSweeper(config *ConfigWatcher, idle *Idle, out chan struct{}) *Sweeper {
+	return &Sweeper{config: config, idle: idle, out: out, last: time.Now()}
+}
+
+func (self *Sweeper) ScheduleForever() {
+	checked := time.Now()
+	for {
+		time.Sleep(sweepCheckInterval)
+		now := time.Now()
+		if reason := self.due(self.config.Settings().Sweep, checked, now); reason != "" {
+			logf("Sweep: running everything (%s).", reason)
+			self.last = now
+			self.out <- struct{}{}
+		}
+		checked = now
+	}
+}
+
+// due describes why a sweep is due (between the previous check and now), or is "".
+func (self *Sweeper) due(settings SweepSettings, previous, now time.Time) string {
+	if !settings.enabled() {
+		self.last = now // (so that enabling a schedule doesn't sweep straight away)
+		return ""
+	}
+	for _, at := range settings.At {
+		clock, err := time.ParseInLocation("15:04", at, now.Location())
+		if err != nil {
+			continue
+		}
+		scheduled := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if scheduled.After(previous) && !scheduled.After(now) {
+			return "it's " + at
+		}
+	}
+	if settings.Every > 0 && now.Sub(self.last) >= settings.Every {
+		return "every " + settings.Every.String()
+	}
+	if last := self.idle.Last(); settings.Idle > 0 && now.Sub(last) >= settings.Idle && last.After(self.quiet) {
+		self.quiet = last
+		return fmt.Sprintf("nothing changed for %s", settings.Idle)
+	}
+	return ""
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) sweep(path string, value interface{}, sweep *SweepSettings) {
+	for key, value := range self.table(path, value) {
+		switch key {
+		case "every", "idle":
+			duration, err := time.ParseDuration(self.string(path+"."+key, value))
+			if err != nil || duration < time.Minute {
+				self.fail(path+"."+key, "must be a duration of a minute or more (ie. \"1h\").")
+			}
+			if key == "every" {
+				sweep.Every = duration
+			} else {
+				sweep.Idle = duration
+			}
+		case "at":
+			sweep.At = self.strings(path+"."+key, value)
+			for _, at := range sweep.At {
+				if _, err := time.Parse("15:04", at); err != nil {
+					self.fail(path+"."+key, "must be times of day (ie. \"12:30\").")
+				}
+			}
+		default:
+			self.unknown(path+"."+key, key, "every", "idle", "at")
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+type Target struct {
+	GOOS   string
+	GOARCH string
+}
+
+func (self Target) String() string { return self.GOOS + "/" + self.GOARCH }
+
+// parseTargets parses a comma-separated list of GOOS/GOARCH pairs (ie. "linux/amd64,windows/amd64").
+func parseTargets(value string) (targets []Target, err error) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid target '%s' (expected GOOS/GOARCH, like linux/amd64).", item)
+		}
+		targets = append(targets, Target{GOOS: parts[0], GOARCH: parts[1]})
+	}
+	return targets, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// mergeTargets appends the targets that aren't listed yet.
+func mergeTargets(targets, more []Target) []Target {
+	merged := append([]Target{}, targets...)
+	for _, target := range more {
+		found := false
+		for _, existing := range merged {
+			found = found || existing == target
+		}
+		if !found {
+			merged = append(merged, target)
+		}
+	}
+	return merged
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// crossCompile builds (without running) the test binary of the package for each
+// of the targets (see -targets, and excludedPlatforms), recording the targets that
+// failed to compile. It reports false if ctx was cancelled in the meantime.
+func (self *Runner) crossCompile(ctx context.Context, packageName string, targets []Target, result *Result) bool {
+	for _, target := range targets {
+		settings := self.config.Settings()
+		command := settings.Background.apply(newCommand(ctx, "go", "test", "-c", "-o", os.DevNull, packageName))
+		command.Env = append(settings.Environ(), "GOOS="+target.GOOS, "GOARCH="+target.GOARCH, "CGO_ENABLED=0")
+		output, err := command.CombinedOutput()
+		if ctx.Err() != nil {
+			return false
+		}
+		if err == nil {
+			continue
+		}
+		result.Status = CompileFailed
+		result.FailedTargets = append(result.FailedTargets, target.String())
+		result.Output += fmt.Sprintf("\n[%s] test binary failed to compile:\n%s", target, output)
+	}
+	return true
+}
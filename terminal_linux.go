@@ -0,0 +1,39 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	getTermios = syscall.TCGETS
+	setTermios = syscall.TCSETS
+)
+
+// enterCbreakMode delivers keystrokes as they are typed (without echo), leaving
+// signals like <ctrl>+c alone. The returned func restores the terminal.
+func enterCbreakMode(fd uintptr) (restore func(), ok bool) {
+	var original syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, getTermios, uintptr(unsafe.Pointer(&original))); errno != 0 {
+		return func() {}, false // not a terminal
+	}
+	cbreak := original
+	cbreak.Lflag &^= syscall.ICANON | syscall.ECHO
+	cbreak.Cc[syscall.VMIN] = 1
+	cbreak.Cc[syscall.VTIME] = 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, setTermios, uintptr(unsafe.Pointer(&cbreak))); errno != 0 {
+		return func() {}, false
+	}
+	return func() {
+		syscall.Syscall(syscall.SYS_IOCTL, fd, setTermios, uintptr(unsafe.Pointer(&original)))
+	}, true
+}
+
+// terminalSize reports false when fd isn't a terminal.
+func terminalSize(fd uintptr) (rows, columns int, ok bool) {
+	var size struct{ Rows, Columns, X, Y uint16 }
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0, 0, false
+	}
+	return int(size.Rows), int(size.Columns), size.Rows > 0
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package main
+
+func enterCbreakMode(fd uintptr) (restore func(), ok bool) { return func() {}, false }
+
+func terminalSize(fd uintptr) (rows, columns int, ok bool) { return 0, 0, false }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// ThrottleSettings configure the resource-aware mode (the [throttle] table of
+// .scantest.toml, or -throttle), which scans less often and tests fewer packages
+// at once while the machine is busy or running on battery power.
+type ThrottleSettings struct {
+	Enabled      bool
+	MaxLoad      float64       // the 1-minute load average (per CPU) above which to throttle
+	OnBattery    bool          // whether to throttle while on battery power
+	ScanInterval time.Duration // the time between scans while throttled
+	Parallel     int           // the maximum number of packages tested at once while throttled
+}
+
+var defaultThrottle = ThrottleSettings{
+	MaxLoad:      1.0,
+	OnBattery:    true,
+	ScanInterval: 2 * time.Second,
+	Parallel:     1,
+}
+
+const normalScanInterval = time.Millisecond * 250
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+type Throttle struct {
+	config *ConfigWatcher
+
+	mutex  sync.Mutex
+	reason string // blank when not throttled
+}
+
+// MonitorForever samples the system every few seconds, reporting when throttling starts and stops.
+func (self *Throttle) MonitorForever() {
+	for {
+		settings := self.config.Settings().Throttle
+		reason := ""
+		if settings.Enabled {
+			reason = self.sample(settings)
+		}
+
+		self.mutex.Lock()
+		previous := self.reason
+		self.reason = reason
+		self.mutex.Unlock()
+
+		if (reason == "") != (previous == "") {
+			if reason == "" {
+				logf("Throttling: off")
+			} else {
+				logf("Throttling: %s (scanning every %s, testing %d package(s) at a time)",
+					reason, settings.ScanInterval, settings.Parallel)
+			}
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (self *Throttle) sample(settings ThrottleSettings) string {
+	reasons := []string{}
+	if settings.OnBattery && onBattery() {
+		reasons = append(reasons, "on battery power")
+	}
+	if load, ok := systemLoad(); ok && settings.MaxLoad > 0 && load/float64(runtime.NumCPU()) > settings.MaxLoad {
+		reasons = append(reasons, fmt.Sprintf("load average %.2f", load))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// State describes why scantest is throttled (blank when it isn't).
+func (self *Throttle) State() string {
+	if self == nil {
+		return ""
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.reason
+}
+
+func (self *Throttle) ScanInterval() time.Duration {
+	if self.State() == "" {
+		return normalScanInterval
+	}
+	return self.config.Settings().Throttle.ScanInterval
+}
+
+func (self *Throttle) Parallel(parallel int) int {
+	if self.State() == "" {
+		return parallel
+	}
+	if limit := self.config.Settings().Throttle.Parallel; limit > 0 && limit < parallel {
+		return limit
+	}
+	return parallel
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// parseTOML understands the subset of TOML used by .scantest.toml: comments, [tables]
+// (with dotted names), dotted keys, basic and literal strings, integers, floats,
+// booleans, arrays and inline tables. Values come back as string, int64, float64,
+// bool, []interface{} or map[string]interface{}. The positions of the keys are
+// returned as well (by dotted path), for the benefit of error messages.
+func parseTOML(source string) (map[string]interface{}, map[string]Position, error) {
+	parser := &tomlParser{
+		source:    source,
+		line:      1,
+		column:    1,
+		root:      map[string]interface{}{},
+		positions: map[string]Position{},
+		headers:   map[string]bool{},
+	}
+	err := parser.parse()
+	return parser.root, parser.positions, err
+}
+
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (self Position) String() string { return fmt.Sprintf("%d:%d", self.Line, self.Column) }
+
+type tomlParser struct {
+	source       string
+	offset       int
+	line, column int
+	root         map[string]interface{}
+	positions    map[string]Position
+	headers      map[string]bool // the [tables] defined so far (by dotted path)
+}
+
+func (self *tomlParser) position() Position { return Position{Line: self.line, Column: self.column} }
+
+func (self *tomlParser) errorf(position Position, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", position, fmt.Sprintf(format, args...))
+}
+
+func (self *tomlParser) peek() rune {
+	if self.offset >= len(self.source) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(self.source[self.offset:])
+	return r
+}
+
+func (self *tomlParser) next() rune {
+	if self.offset >= len(self.source) {
+		return 0
+	}
+	r, size := utf8.DecodeRuneInString(self.source[self.offset:])
+	self.offset += size
+	if r == '\n' {
+		self.line++
+		self.column = 1
+	} else {
+		self.column++
+	}
+	return r
+}
+
+// skip passes over spaces and tabs (and, if multiline, newlines and comments too).
+func (self *tomlParser) skip(multiline bool) {
+	for {
+		switch self.peek() {
+		case ' ', '\t', '\r':
+			self.next()
+		case '\n':
+			if !multiline {
+				return
+			}
+			self.next()
+		case '#':
+			if !multiline {
+				return
+			}
+			for self.peek() != '\n' && self.peek() != 0 {
+				self.next()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (self *tomlParser) endOfLine() error {
+	self.skip(false)
+	if self.peek() == '#' {
+		for self.peek() != '\n' && self.peek() != 0 {
+			self.next()
+		}
+	}
+	if r := self.peek(); r != '\n' && r != 0 {
+		return self.errorf(self.position(), "unexpected %q (expected the end of the line)", r)
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *tomlParser) parse() error {
+	table := self.root
+	prefix := ""
+	for {
+		self.skip(true)
+		if self.peek() == 0 {
+			return nil
+		}
+		if self.peek() == '[' {
+			position := self.position()
+			self.next()
+			if self.peek() == '[' {
+				return self.errorf(position, "arrays of tables are not supported")
+			}
+			keys, err := self.parseKeys()
+			if err != nil {
+				return err
+			}
+			if self.next() != ']' {
+				return self.errorf(position, "unterminated table header")
+			}
+			path := strings.Join(keys, ".")
+			if self.headers[path] {
+				return self.errorf(position, "the table %q is defined more than once", path)
+			}
+			self.headers[path] = true
+			if table, err = self.descend(self.root, keys, position); err != nil {
+				return err
+			}
+			prefix = path + "."
+			self.positions[path] = position
+		} else if err := self.parseKeyValue(table, prefix); err != nil {
+			return err
+		}
+		if err := self.endOfLine(); err != nil {
+			return err
+		}
+	}
+}
+
+func (self *tomlParser) parseKeyValue(table map[string]interface{}, prefix string) error {
+	position := self.position()
+	keys, err := self.parseKeys()
+	if err != nil {
+		return err
+	}
+	if self.next() != '=' {
+		return self.errorf(position, "expected '=' after the key %q", strings.Join(keys, "."))
+	}
+	self.skip(false)
+	value, err := self.parseValue(prefix + strings.Join(keys, "."))
+	if err != nil {
+		return err
+	}
+	parent, err := self.descend(table, keys[:len(keys)-1], position)
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, found := parent[last]; found {
+		return self.errorf(position, "the key %q is defined more than once", prefix+strings.Join(keys, "."))
+	}
+	parent[last] = value
+	self.positions[prefix+strings.Join(keys, ".")] = position
+	return nil
+}
+
+func (self *tomlParser) descend(table map[string]interface{}, keys []string, position Position) (map[string]interface{}, error) {
+	for _, key := range keys {
+		existing, found := table[key]
+		if !found {
+			child := map[string]interface{}{}
+			table[key] = child
+			table = child
+			continue
+		}
+		child, ok := existing.(map[string]interface{})
+		if !ok {
+			return nil, self.errorf(position, "the key %q is already defined as a value", key)
+		}
+		table = child
+	}
+	return table, nil
+}
+
+func (self *tomlParser) parseKeys() (keys []string, err error) {
+	for {
+		self.skip(false)
+		position := self.position()
+		var key string
+		switch r := self.peek(); {
+		case r == '"':
+			value, err := self.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			key = value
+		case r == '\'':
+			value, err := self.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			key = value
+		default:
+			for isBareKey(self.peek()) {
+				key += string(self.next())
+			}
+			if key == "" {
+				return nil, self.errorf(position, "expected a key, found %q", self.peek())
+			}
+		}
+		keys = append(keys, key)
+		self.skip(false)
+		if self.peek() != '.' {
+			return keys, nil
+		}
+		self.next()
+	}
+}
+
+func isBareKey(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *tomlParser) parseValue(path string) (interface{}, error) {
+	position := self.position()
+	switch r := self.peek(); {
+	case r == '"':
+		return self.parseBasicString()
+	case r == '\'':
+		return self.parseLiteralString()
+	case r == '[':
+		return self.parseArray(path)
+	case r == '{':
+		return self.parseInlineTable(path)
+	case r == 't' || r == 'f':
+		word := self.parseWord()
+		if word == "true" || word == "false" {
+			return word == "true", nil
+		}
+		return nil, self.errorf(position, "invalid value %q", word)
+	case r == '+' || r == '-' || r >= '0' && r <= '9':
+		word := strings.Replace(self.parseWord(), "_", "", -1)
+		if integer, err := strconv.ParseInt(word, 0, 64); err == nil {
+			return integer, nil
+		}
+		if float, err := strconv.ParseFloat(word, 64); err == nil {
+			return float, nil
+		}
+		return nil, self.errorf(position, "invalid number %q", word)
+	case r == 0 || r == '\n':
+		return nil, self.errorf(position, "missing value")
+	default:
+		return nil, self.errorf(position, "invalid value starting with %q (strings must be quoted)", r)
+	}
+}
+
+func (self *tomlParser) parseWord() string {
+	word := ""
+	for r := self.peek(); r != 0 && !strings.ContainsRune(" \t\r\n,]}#", r); r = self.peek() {
+		word += string(self.next())
+	}
+	return word
+}
+
+func (self *tomlParser) parseBasicString() (string, error) {
+	position := self.position()
+	self.next()
+	value := new(strings.Builder)
+	for {
+		switch r := self.next(); r {
+		case '"':
+			return value.String(), nil
+		case 0, '\n':
+			return "", self.errorf(position, "unterminated string")
+		case '\\':
+			switch escaped := self.next(); escaped {
+			case 'n':
+				value.WriteRune('\n')
+			case 't':
+				value.WriteRune('\t')
+			case 'r':
+				value.WriteRune('\r')
+			case '"', '\\':
+				value.WriteRune(escaped)
+			case 'u', 'U':
+				size := 4
+				if escaped == 'U' {
+					size = 8
+				}
+				hex := ""
+				for x := 0; x < size; x++ {
+					hex += string(self.next())
+				}
+				code, err := strconv.ParseUint(hex, 16, 32)
+				if err != nil {
+					return "", self.errorf(position, "invalid unicode escape \\%c%s", escaped, hex)
+				}
+				value.WriteRune(rune(code))
+			default:
+				return "", self.errorf(position, "invalid escape sequence \\%c", escaped)
+			}
+		default:
+			value.WriteRune(r)
+		}
+	}
+}
+
+func (self *tomlParser) parseLiteralString() (string, error) {
+	position := self.position()
+	self.next()
+	value := new(strings.Builder)
+	for {
+		switch r := self.next(); r {
+		case '\'':
+			return value.String(), nil
+		case 0, '\n':
+			return "", self.errorf(position, "unterminated string")
+		default:
+			value.WriteRune(r)
+		}
+	}
+}
+
+func (self *tomlParser) parseArray(path string) (interface{}, error) {
+	position := self.position()
+	self.next()
+	values := []interface{}{}
+	for {
+		self.skip(true)
+		if self.peek() == ']' {
+			self.next()
+			return values, nil
+		}
+		if self.peek() == 0 {
+			return nil, self.errorf(position, "unterminated array")
+		}
+		value, err := self.parseValue(path)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		self.skip(true)
+		if self.peek() == ',' {
+			self.next()
+		} else if self.peek() == 0 {
+			return nil, self.errorf(position, "unterminated array")
+		} else if self.peek() != ']' {
+			return nil, self.errorf(self.position(), "expected ',' or ']' in array, found %q", self.peek())
+		}
+	}
+}
+
+func (self *tomlParser) parseInlineTable(path string) (interface{}, error) {
+	position := self.position()
+	self.next()
+	table := map[string]interface{}{}
+	for {
+		self.skip(false)
+		if self.peek() == '}' {
+			self.next()
+			return table, nil
+		}
+		if self.peek() == 0 || self.peek() == '\n' {
+			return nil, self.errorf(position, "unterminated inline table")
+		}
+		if err := self.parseKeyValue(table, path+"."); err != nil {
+			return nil, err
+		}
+		self.skip(false)
+		if self.peek() == ',' {
+			self.next()
+		} else if self.peek() == 0 || self.peek() == '\n' {
+			return nil, self.errorf(position, "unterminated inline table")
+		} else if self.peek() != '}' {
+			return nil, self.errorf(self.position(), "expected ',' or '}' in inline table, found %q", self.peek())
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOMLStrings(t *testing.T) {
+	for _, test := range []struct {
+		source   string
+		expected string
+	}{
+		{`x = "plain"`, "plain"},
+		{`x = "tab\there\nnewline"`, "tab\there\nnewline"},
+		{`x = "quote \" and backslash \\"`, `quote " and backslash \`},
+		{`x = "caf\u00e9 \U0001F600"`, "café 😀"},
+		{`x = 'C:\Users\me\no escapes'`, `C:\Users\me\no escapes`},
+		{`x = "café # not a comment" # a comment`, "café # not a comment"},
+		{`x = ''`, ""},
+	} {
+		document, _, err := parseTOML(test.source)
+		if err != nil {
+			t.Errorf("parseTOML(%q): %s", test.source, err)
+			continue
+		}
+		if actual := document["x"]; actual != test.expected {
+			t.Errorf("parseTOML(%q): %q, want %q", test.source, actual, test.expected)
+		}
+	}
+}
+
+func TestParseTOMLDocument(t *testing.T) {
+	const source = `
+# comments are ignored
+parallel = 4
+timeout = 1.5
+quiet = true
+test-args = ["-short", '-race',
+	"-count=1", # (arrays can span lines)
+]
+empty = []
+retry.max = 2
+"quoted key" = -1_000
+
+[env]
+GOFLAGS = "-mod=mod"
+
+[packages."example.com/slow"]
+command = { name = "go", args = ["test", "-p", "1"], nested.deep = true }
+
+[packages]
+other = "fine (the table was only implied before)"
+`
+	expected := map[string]interface{}{
+		"parallel":   int64(4),
+		"timeout":    1.5,
+		"quiet":      true,
+		"test-args":  []interface{}{"-short", "-race", "-count=1"},
+		"empty":      []interface{}{},
+		"retry":      map[string]interface{}{"max": int64(2)},
+		"quoted key": int64(-1000),
+		"env":        map[string]interface{}{"GOFLAGS": "-mod=mod"},
+		"packages": map[string]interface{}{
+			"example.com/slow": map[string]interface{}{
+				"command": map[string]interface{}{
+					"name":   "go",
+					"args":   []interface{}{"test", "-p", "1"},
+					"nested": map[string]interface{}{"deep": true},
+				},
+			},
+			"other": "fine (the table was only implied before)",
+		},
+	}
+	document, positions, err := parseTOML(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(document, expected) {
+		t.Errorf("parseTOML: %#v, want %#v", document, expected)
+	}
+	for path, position := range map[string]Position{
+		"parallel":                               {3, 1},
+		"retry.max":                              {10, 1},
+		"env":                                    {13, 1},
+		"env.GOFLAGS":                            {14, 1},
+		"packages.example.com/slow.command":      {17, 1},
+		"packages.example.com/slow.command.args": {17, 26},
+		"packages.example.com/slow.command.nested.deep": {17, 54},
+	} {
+		if positions[path] != position {
+			t.Errorf("the position of %q: %s, want %s", path, positions[path], position)
+		}
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	for _, test := range []struct {
+		source   string
+		expected string
+	}{
+		{"[env]\nA = \"1\"\n\n[env]\nB = \"2\"", `4:1: the table "env" is defined more than once`},
+		{"[a.b]\nx = 1\n[a.b]", `3:1: the table "a.b" is defined more than once`},
+		{"x = 1\nx = 2", `2:1: the key "x" is defined more than once`},
+		{"[t]\ny = { z = 1, z = 2 }", `2:14: the key "t.y.z" is defined more than once`},
+		{"x = 1\n[x]", `2:1: the key "x" is already defined as a value`},
+		{"x = \"open", `1:5: unterminated string`},
+		{"x = \"bad \\q\"", `1:5: invalid escape sequence \q`},
+		{"x = \"\\u00zz\"", `1:5: invalid unicode escape \u00zz`},
+		{"x = bare", `1:5: invalid value starting with 'b' (strings must be quoted)`},
+		{"x = 12abc", `1:5: invalid number "12abc"`},
+		{"x =", `1:4: missing value`},
+		{"x = [1, 2", `1:5: unterminated array`},
+		{"x = [1 2]", `1:8: expected ',' or ']' in array, found '2'`},
+		{"x = { a = 1\n}", `1:5: unterminated inline table`},
+		{"x = 1 y = 2", `1:7: unexpected 'y' (expected the end of the line)`},
+		{"[[tables]]", `1:1: arrays of tables are not supported`},
+		{"[open", `1:1: unterminated table header`},
+		{"x 1", `1:1: expected '=' after the key "x"`},
+	} {
+		_, _, err := parseTOML(test.source)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("parseTOML(%q): %v, want %s", test.source, err, test.expected)
+		}
+	}
+}
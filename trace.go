@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Tracer saves a trace of each run (see -trace) in the trace event format of Chrome,
+// for chrome://tracing or https://ui.perfetto.dev: the scan and the selection that
+// led to the run, then a row for each package with its phases (generate, validate,
+// test, which includes building the test binary, retry, build, cross-compile and
+// fuzz), so it's plain where the time between saving a file and seeing the results
+// goes. A nil Tracer traces nothing.
+type Tracer struct {
+	folder string
+
+	mutex     sync.Mutex
+	scan      traceEvent // the last one
+	selection []traceEvent
+	events    []traceEvent // of the run underway
+	lanes     map[string]int
+}
+
+type traceEvent struct {
+	Name     string            `json:"name"`
+	Category string            `json:"cat,omitempty"`
+	Phase    string            `json:"ph"`
+	Time     int64             `json:"ts"` // microseconds
+	Duration int64             `json:"dur,omitempty"`
+	Process  int               `json:"pid"`
+	Thread   int               `json:"tid"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+func NewTracer(folder string) *Tracer {
+	if folder == "" {
+		return nil
+	}
+	return &Tracer{folder: folder}
+}
+
+func span(name string, lane int, started time.Time, args map[string]string) traceEvent {
+	return traceEvent{
+		Name:     name,
+		Category: name,
+		Phase:    "X",
+		Time:     started.UnixNano() / int64(time.Microsecond),
+		Duration: int64(time.Since(started) / time.Microsecond),
+		Process:  1,
+		Thread:   lane,
+		Args:     args,
+	}
+}
+
+func lane(id int, name string) traceEvent {
+	return traceEvent{Name: "thread_name", Phase: "M", Process: 1, Thread: id, Args: map[string]string{"name": name}}
+}
+
+// Scanned is called after each scan of the file system.
+func (self *Tracer) Scanned(started time.Time) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.scan = span("scan", 0, started, nil)
+}
+
+// Selected is called once the packages to test are selected (following the last scan).
+func (self *Tracer) Selected(started time.Time) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.selection = append(self.selection, self.scan, span("selection", 0, started, nil))
+}
+
+// Begin starts the trace of a run (leaving out any run it interrupted).
+func (self *Tracer) Begin() {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.events = append([]traceEvent{lane(0, "scantest")}, self.selection...)
+	self.selection = nil
+	self.lanes = map[string]int{}
+}
+
+// Span records a phase of testing a package, from started until now.
+func (self *Tracer) Span(packageName, phase string, started time.Time) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	id, found := self.lanes[packageName]
+	if !found {
+		id = len(self.lanes) + 1
+		self.lanes[packageName] = id
+		self.events = append(self.events, lane(id, packageName))
+	}
+	self.events = append(self.events, span(phase, id, started, map[string]string{"package": packageName}))
+}
+
+// Write saves the trace of the run that just finished (as trace-<time>.json, and
+// latest.json).
+func (self *Tracer) Write() error {
+	if self == nil {
+		return nil
+	}
+	self.mutex.Lock()
+	raw, err := json.Marshal(struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}{self.events})
+	self.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(self.folder, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(self.folder, "trace-"+time.Now().Format("20060102-150405")+".json")
+	if err := os.WriteFile(name, raw, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(self.folder, "latest.json"), raw, 0644)
+}
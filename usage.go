@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// measureUsage adds up the resources used by a finished child process (and the
+// processes it waited for, like the test binary of `go test`): CPU time and the
+// largest resident set (where the platform reports it).
+func measureUsage(result *Result, state *os.ProcessState) {
+	if state == nil {
+		return
+	}
+	result.CPUTime += state.UserTime() + state.SystemTime()
+	if rss := maxRSS(state); rss > result.MaxRSS {
+		result.MaxRSS = rss
+	}
+}
+
+// describeUsage is included in the timing of a package in the console.
+func describeUsage(result Result) string {
+	if result.CPUTime == 0 {
+		return ""
+	}
+	described := fmt.Sprintf(", cpu %s", result.CPUTime.Round(time.Millisecond*10))
+	if result.MaxRSS > 0 {
+		described += fmt.Sprintf(", %.1f MB max rss", float64(result.MaxRSS)/(1<<20))
+	}
+	return described
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// runConfig implements `scantest config validate`, which checks each config file on
+// its own (the policy, the global config and .scantest.toml), and then all of them
+// together, the way scantest would load them (see loadLayeredConfig).
+func runConfig(arguments []string) {
+	if len(arguments) == 0 || arguments[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "Usage: scantest config validate [-policy <file or URL>] [-profile <name>]")
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	profile := flags.String("profile", "", "The profile to resolve (the default is the one the files name).")
+	flags.StringVar(&policySource, "policy", policySource, "A file or a URL (the default is $SCANTEST_POLICY) with the config an organization mandates.")
+	flags.Parse(arguments[1:])
+
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	policy := NewPolicy(workingDirectory, policySource).Path()
+	if policySource != "" && policy == "" {
+		os.Exit(1) // (the reason was logged)
+	}
+	project := filepath.Join(workingDirectory, configFilename)
+
+	failed := false
+	for _, path := range []string{policy, globalConfigPath(), project} {
+		if !isFile(path) {
+			continue
+		}
+		document, positions, err := readConfigDocument(path)
+		if err == nil {
+			_, err = decodeConfig(document, positions)
+		}
+		if err != nil {
+			fmt.Printf("%s:%s\n", relativePath(path), err)
+			failed = true
+		} else {
+			fmt.Printf("%s: ok\n", relativePath(path))
+		}
+	}
+	if !failed {
+		config, err := loadLayeredConfig([]string{policy, globalConfigPath()}, project)
+		if err == nil {
+			_, err = config.Resolve(*profile)
+		}
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// conflicts fails for settings that are fine on their own, but not together.
+func (self *configDecoder) conflicts(config *Config) {
+	arguments := map[string][]string{"test-args": config.TestArgs, "retry-args": config.RetryArgs}
+	for name, profile := range config.Profiles {
+		arguments["profiles."+name+".test-args"] = profile.TestArgs
+	}
+	for _, rule := range config.Packages {
+		arguments["packages."+rule.Pattern+".test-args"] = rule.TestArgs
+	}
+	for path, testArgs := range arguments {
+		if hasArgument(testArgs, "json") {
+			self.fail(path, "can't have -json: scantest reads the text output of go test.")
+		}
+	}
+	idle, sweep := config.Idle, config.Sweep
+	if sweep.Idle > 0 && idle.Pause && idle.After > 0 && idle.After <= sweep.Idle {
+		self.fail("sweep.idle", "is never reached: scanning pauses after %s without activity (see idle.pause).", idle.After)
+	}
+}
+
+// closest is the candidate a (mistyped) key most likely meant, or "" when none is
+// close enough.
+func closest(key string, candidates []string) (suggestion string) {
+	best := len(key)/3 + 1 // (the most edits that still make a plausible typo)
+	for _, candidate := range candidates {
+		if distance := editDistance(strings.ToLower(key), candidate); distance < best {
+			best, suggestion = distance, candidate
+		}
+	}
+	return suggestion
+}
+
+// editDistance is the Levenshtein distance between the strings.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for y := range previous {
+		previous[y] = y
+	}
+	for x := 1; x <= len(a); x++ {
+		current := make([]int, len(b)+1)
+		current[0] = x
+		for y := 1; y <= len(b); y++ {
+			current[y] = previous[y-1] // (the same character)
+			if a[x-1] != b[y-1] {
+				current[y]++ // (substituted)
+			}
+			if previous[y]+1 < current[y] {
+				current[y] = previous[y] + 1 // (deleted)
+			}
+			if current[y-1]+1 < current[y] {
+				current[y] = current[y-1] + 1 // (inserted)
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
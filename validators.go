@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"go/build"
+	"sort"
+	"strings"
+
+	"github.com/smartystreets/gunit/gunit/generate"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Validator checks a package after `go generate` and before `go test`. A problem
+// fails the package (as GENERATE FAILED) without running its tests.
+type Validator interface {
+	Validate(ctx context.Context, pkg *build.Package, generateOutput string) (problem string)
+}
+
+// ValidatorRule is a [validators.<name>] table of .scantest.toml: either one of the
+// built-in validators (by name, without a command) or a command run in the folder
+// of each package, which fails the package by exiting non-zero:
+//
+//	[validators.gunit]
+//
+//	[validators.headers]
+//	command = "./scripts/check-headers.sh"
+//
+// Without a [validators] table, just the gunit validator is used.
+type ValidatorRule struct {
+	Name    string
+	Command string
+}
+
+var builtinValidators = map[string]Validator{
+	"gunit": GunitValidator{},
+}
+
+var defaultValidators = []ValidatorRule{{Name: "gunit"}}
+
+func (self ValidatorRule) validator(environment []string) Validator {
+	if self.Command != "" {
+		return CommandValidator{rule: self, environment: environment}
+	}
+	return builtinValidators[self.Name]
+}
+
+// isGeneratedFile reports whether the file is written by one of the built-in
+// validators' tools (and should therefore not trigger runs of its own).
+func isGeneratedFile(name string) bool {
+	return name == generate.GeneratedFilename
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// GunitValidator requires packages whose tests import gunit to invoke the gunit
+// command by way of a go:generate directive.
+type GunitValidator struct{}
+
+func (GunitValidator) Validate(ctx context.Context, pkg *build.Package, generateOutput string) string {
+	for _, i := range pkg.TestImports {
+		if i == "github.com/smartystreets/gunit" && !strings.Contains(generateOutput, "gunit") {
+			return pkg.ImportPath + " imports gunit but is missing a go generate directive to invoke the gunit command (`//go:generate gunit`)..."
+		}
+	}
+	return ""
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+type CommandValidator struct {
+	rule        ValidatorRule
+	environment []string
+}
+
+func (self CommandValidator) Validate(ctx context.Context, pkg *build.Package, generateOutput string) string {
+	command := newShellCommand(ctx, self.rule.Command)
+	command.Dir = pkg.Dir
+	command.Env = self.environment
+	output, err := command.CombinedOutput()
+	if err != nil && ctx.Err() == nil {
+		return "The " + self.rule.Name + " validator (" + self.rule.Command + ") failed: " + err.Error() + "\n" + string(output)
+	}
+	return ""
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+func (self *configDecoder) validators(path string, value interface{}) (rules []ValidatorRule) {
+	rules = []ValidatorRule{}
+	for name, table := range self.table(path, value) {
+		rule := ValidatorRule{Name: name}
+		for key, value := range self.table(path+"."+name, table) {
+			if key == "command" {
+				rule.Command = self.string(path+"."+name+"."+key, value)
+			} else {
+				self.unknown(path+"."+name+"."+key, key, "command")
+			}
+		}
+		if _, found := builtinValidators[name]; !found && rule.Command == "" {
+			self.fail(path+"."+name, "needs a command (it isn't a built-in validator).")
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
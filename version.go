@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Set by releases: go build -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234"
+var (
+	version = "dev"
+	commit  = ""
+)
+
+const (
+	releasesURL         = "https://api.github.com/repos/smartystreets/scantest/releases/latest"
+	updateCheckFilename = "update-check.json"
+	updateCheckInterval = 24 * time.Hour
+)
+
+// buildCommit is the commit scantest was built from: the one given to the linker,
+// or else the one the go command recorded (when built from a checkout).
+func buildCommit() (revision string, modified bool) {
+	if commit != "" {
+		return commit, false
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+	}
+	return revision, modified
+}
+
+func describeVersion() string {
+	details := []string{}
+	if revision, modified := buildCommit(); revision != "" {
+		if modified {
+			revision += "+dirty"
+		}
+		details = append(details, shortCommit(revision))
+	}
+	details = append(details, runtime.Version(), runtime.GOOS+"/"+runtime.GOARCH)
+	return fmt.Sprintf("scantest %s (%s)", version, strings.Join(details, ", "))
+}
+
+//////////////////////////////////////////////////////////////////////////////////////
+
+// UpdateCheck is what's remembered (in the user's cache folder, so across projects)
+// of the last look for a newer release.
+type UpdateCheck struct {
+	Time   time.Time `json:"time"`
+	Latest string    `json:"latest"`
+}
+
+// CheckForUpdatesForever looks for a newer release (at most once a day, when
+// enabled by check-updates in .scantest.toml) and announces it. Nothing is ever
+// installed. Development builds (without a version) are never out of date.
+func CheckForUpdatesForever(config *ConfigWatcher) {
+	if version == "dev" {
+		return
+	}
+	announced := ""
+	for {
+		if config.Settings().CheckUpdates {
+			if latest := latestRelease(); latest != "" && latest != version && latest != announced {
+				logf("scantest %s is available (this is %s): go get -u github.com/smartystreets/scantest", latest, version)
+				announced = latest
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// latestRelease is the tag of the latest release (as of the last check, if that was
+// less than a day ago).
+func latestRelease() string {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(cache, "scantest", updateCheckFilename)
+	var check UpdateCheck
+	if raw, err := os.ReadFile(path); err == nil && json.Unmarshal(raw, &check) == nil && time.Since(check.Time) < updateCheckInterval {
+		return check.Latest
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, releasesURL, nil)
+	if err != nil {
+		return ""
+	}
+	request.Header.Set("User-Agent", "scantest/"+version)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return check.Latest // (offline, probably; try again later)
+	}
+	defer response.Body.Close()
+	var release struct {
+		Tag string `json:"tag_name"`
+	}
+	if response.StatusCode != http.StatusOK || json.NewDecoder(response.Body).Decode(&release) != nil {
+		return check.Latest
+	}
+
+	check = UpdateCheck{Time: time.Now(), Latest: release.Tag}
+	if raw, err := json.Marshal(check); err == nil && os.MkdirAll(filepath.Dir(path), 0755) == nil {
+		os.WriteFile(path, raw, 0644)
+	}
+	return check.Latest
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// Warmer builds everything (`go build ./...`, at the lowest priority) right after
+// startup (-warm), so that the build cache is warm by the first edit, and the first
+// runs it triggers are as quick as the ones after. The build gives way to the runs
+// edits trigger (it's cancelled when one starts), and to quitting. A nil Warmer
+// builds nothing.
+type Warmer struct {
+	mutex   sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	runs    int
+	stopped bool // (quitting)
+}
+
+func NewWarmer(settings Settings) *Warmer {
+	ctx, cancel := context.WithCancel(context.Background())
+	self := &Warmer{cancel: cancel, done: make(chan struct{})}
+	go self.build(ctx, settings)
+	return self
+}
+
+func (self *Warmer) build(ctx context.Context, settings Settings) {
+	defer close(self.done)
+	started := time.Now()
+	arguments := []string{"build"}
+	if buildArgs, _, ok := splitTestArgs(settings.TestArgs); ok { // (the same build, so the same cache entries)
+		for _, argument := range buildArgs {
+			if !testOnlyFlags[strings.SplitN(strings.TrimLeft(argument, "-"), "=", 2)[0]] {
+				arguments = append(arguments, argument)
+			}
+		}
+	}
+	command := newCommand(ctx, "go", append(arguments, "./...")...) // (which discards what it builds)
+	command.Env = settings.Environ()
+	lowerPriority(command, 19)
+	err := command.Run()
+	self.mutex.Lock()
+	stopped := self.stopped
+	self.mutex.Unlock()
+	switch {
+	case stopped:
+	case ctx.Err() != nil:
+		logf("Warm-up: cancelled (a run started).")
+	case err != nil:
+		logf("Warm-up: go build ./... failed after %s (the tests will tell why).", time.Since(started).Round(time.Second/10))
+	default:
+		logf("Warm-up: the build cache is warm (go build ./... took %s).", time.Since(started).Round(time.Second/10))
+	}
+}
+
+// testOnlyFlags are the flags of go test's build that go build doesn't take.
+var testOnlyFlags = map[string]bool{"vet": true, "exec": true, "c": true, "o": true, "json": true}
+
+// RunStarted cancels the build for any run but the first (which startup triggers).
+func (self *Warmer) RunStarted() {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	self.runs++
+	runs := self.runs
+	self.mutex.Unlock()
+	if runs > 1 {
+		self.cancel()
+	}
+}
+
+// Stop cancels the build, and waits (a little) for it to be gone.
+func (self *Warmer) Stop() {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	self.stopped = true
+	self.mutex.Unlock()
+	self.cancel()
+	select {
+	case <-self.done:
+	case <-time.After(time.Second):
+	}
+}
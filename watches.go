@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////
+
+// describeWatchLimit warns when the tree has at least as many folders as the inotify
+// watches a user may have (see watchLimit): scantest polls, so it doesn't run out, but
+// the editors, gopls and other tools that watch the tree (a watch per folder) do, and
+// then silently miss changes, which looks like scantest missing saves. It's "" when
+// the limit is unknown (ie. not on Linux) or high enough.
+func describeWatchLimit(folders, limit int) string {
+	if limit <= 0 || folders < limit {
+		return ""
+	}
+	suggested := 524288
+	for suggested < folders*2 {
+		suggested *= 2
+	}
+	return fmt.Sprintf("This tree has %d folders, and a user may only have %d inotify watches (fs.inotify.max_user_watches), so editors and tools that watch it will miss changes (scantest polls, so it won't). Raise the limit with: sudo sysctl fs.inotify.max_user_watches=%d (and add it to /etc/sysctl.conf to keep it).", folders, limit, suggested)
+}